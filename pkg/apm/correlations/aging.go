@@ -0,0 +1,38 @@
+package correlations
+
+import (
+	"errors"
+)
+
+func validatePriorityAging(conf Config) error {
+	if conf.PriorityAging < 0 {
+		return errors.New("correlation priority aging must not be negative")
+	}
+	return nil
+}
+
+// takeWaiting takes the oldest queued normal priority request without blocking so that its age
+// can be compared to the priority aging, or returns nil if none are queued.  Requests on the
+// overflow are only taken once the request channel is empty, as in processChan.
+func (cc *Client) takeWaiting() *request {
+	select {
+	case r := <-cc.requestChan:
+		return r
+	default:
+	}
+	if len(cc.requestChan) > 0 {
+		return nil
+	}
+	select {
+	case r := <-cc.overflowChan:
+		return r
+	default:
+		return nil
+	}
+}
+
+// aged returns whether the normal priority request has waited long enough to be sent ahead of
+// high priority requests
+func (cc *Client) aged(r *request) bool {
+	return cc.now().Sub(r.enqueuedAt) >= cc.priorityAging
+}
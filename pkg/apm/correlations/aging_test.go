@@ -0,0 +1,55 @@
+package correlations
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePriorityAging(t *testing.T) {
+	require.NoError(t, validatePriorityAging(Config{}))
+	require.NoError(t, validatePriorityAging(Config{PriorityAging: time.Second}))
+	require.Error(t, validatePriorityAging(Config{PriorityAging: -time.Second}))
+}
+
+func TestCorrelationClientPriorityAging(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		aging    time.Duration
+		expected []string
+		aged     int64
+	}{
+		{name: "disabled", expected: []string{"high-1", "high-2", "normal"}},
+		{name: "aged", aging: 20 * time.Millisecond, expected: []string{"normal", "high-1", "high-2"}, aged: 1},
+		{name: "not aged yet", aging: time.Hour, expected: []string{"high-1", "high-2", "normal"}},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+				conf.PriorityAging = tc.aging
+				// send one request at a time so that they arrive in the order they are sent
+				conf.MaxRequests = 1
+			})
+			defer close(serverCh)
+			defer cancel()
+
+			client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "normal"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+			time.Sleep(50 * time.Millisecond)
+			for _, value := range []string{"high-1", "high-2"} {
+				client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: value}, CorrelateCB(func(_ *Correlation, _ error) {}), RequestOptions{Priority: PriorityHigh})
+			}
+			client.Start()
+
+			cors := waitForCors(serverCh, 3, 3)
+			require.Len(t, cors, 3)
+			values := make([]string, len(cors))
+			for i, cor := range cors {
+				values[i] = cor.Value
+			}
+			require.Equal(t, tc.expected, values)
+			require.Equal(t, tc.aged, atomic.LoadInt64(&client.TotalAgedRequests))
+		})
+	}
+}
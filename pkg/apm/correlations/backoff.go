@@ -0,0 +1,166 @@
+package correlations
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/signalfx/signalfx-agent/pkg/apm/requests"
+	"github.com/signalfx/signalfx-agent/pkg/apm/requests/requestcounter"
+)
+
+// BackoffStrategy determines how the delay between retries is computed
+type BackoffStrategy string
+
+const (
+	// BackoffConstant waits the retry delay before every retry
+	BackoffConstant BackoffStrategy = "constant"
+	// BackoffFullJitter waits a random duration between 0 and the retry delay doubled for each
+	// previous retry, capped at the max retry delay
+	BackoffFullJitter BackoffStrategy = "full_jitter"
+)
+
+// validateBackoffStrategy returns an error if the strategy isn't known.  An empty strategy is
+// treated as BackoffConstant.
+func validateBackoffStrategy(strategy BackoffStrategy) error {
+	switch strategy {
+	case "", BackoffConstant, BackoffFullJitter:
+		return nil
+	default:
+		return fmt.Errorf("invalid correlation backoff strategy %q", strategy)
+	}
+}
+
+// shouldRetry returns whether an attempt that failed with the status code, 0 if there was no
+// response, should be retried.  3xx and 4xx responses won't be remedied by retrying, except for a
+// 404 to a Correlate when RetryCorrelateNotFound is set.  A final 1xx response is most likely
+// from a misbehaving proxy and is retried.  A Correlate that reached the maximum entries isn't
+// retried, even if MaxEntriesStatusCode is a 5xx.  Nothing is retried for an operation whose
+// retries are disabled.
+func (cc *Client) shouldRetry(op Operation, statusCode int) bool {
+	switch {
+	case cc.operationRetry(op).Disabled:
+		return false
+	case requests.IsInformationalStatus(statusCode):
+		return true
+	case op == OperationCorrelate && statusCode != 0 && statusCode == cc.maxEntriesStatus:
+		return false
+	case statusCode == http.StatusNotFound && op == OperationCorrelate:
+		return cc.retryNotFound
+	}
+	return retryableStatus(statusCode)
+}
+
+// retryableStatus returns whether an attempt that failed with the status code, 0 if there was no
+// response, could succeed if it is retried
+func retryableStatus(statusCode int) bool {
+	return statusCode < http.StatusMultipleChoices || statusCode >= http.StatusInternalServerError
+}
+
+// retryDelayFor returns how long to wait before retrying the request
+func (cc *Client) retryDelayFor(r *request) time.Duration {
+	return cc.retryDelayAfter(r, requestcounter.GetRequestCount(r.ctx))
+}
+
+// retryDelayAfter returns how long to wait before retrying the request once it has been attempted
+// the given number of times
+func (cc *Client) retryDelayAfter(r *request, attempt uint32) time.Duration {
+	cc.RLock()
+	base, initial, strategy, maxRetryDelay := cc.retryDelay, cc.initialRetryDelay, cc.backoffStrategy, cc.maxRetryDelay
+	envDelay, hasEnvDelay := cc.conf.EnvironmentRetryDelays[environmentOf(r.Correlation)]
+	opDelay := operationRetry(cc.conf, r.operation).RetryDelay
+	cc.RUnlock()
+
+	switch {
+	case r.opts.RetryDelay > 0:
+		base = r.opts.RetryDelay
+	case opDelay > 0:
+		base = opDelay
+	case attempt == 0 && initial > 0:
+		base = initial
+	case hasEnvDelay:
+		base = envDelay
+	}
+	if strategy != BackoffFullJitter {
+		return base
+	}
+	return cc.jitter(exponentialDelay(base, maxRetryDelay, attempt))
+}
+
+// maxAttemptsFor returns how many times a request for the operation may be attempted
+func (cc *Client) maxAttemptsFor(op Operation) uint32 {
+	cc.RLock()
+	defer cc.RUnlock()
+	retry := operationRetry(cc.conf, op)
+	switch {
+	case retry.Disabled:
+		return 0
+	case retry.MaxRetries > 0:
+		return uint32(retry.MaxRetries) + 1
+	}
+	return cc.maxAttempts
+}
+
+// environmentOf returns the environment a correlation is for, or an empty string if it isn't an
+// environment correlation
+func environmentOf(cor *Correlation) string {
+	if cor == nil || cor.Type != Environment {
+		return ""
+	}
+	return cor.Value
+}
+
+// validateEnvironmentRetryDelays returns an error if any of the overrides can't be used
+func validateEnvironmentRetryDelays(delays map[string]time.Duration) error {
+	for env, delay := range delays {
+		if env == "" {
+			return errors.New("correlation environment retry delays must be for a named environment")
+		}
+		if delay < 0 {
+			return fmt.Errorf("invalid correlation retry delay %v for environment %q", delay, env)
+		}
+	}
+	return nil
+}
+
+// exponentialDelay returns base * 2^attempt capped at max.  A max of 0 leaves the delay uncapped
+// except to prevent it from overflowing.
+func exponentialDelay(base time.Duration, max time.Duration, attempt uint32) time.Duration {
+	if max <= 0 {
+		max = math.MaxInt64
+	}
+	delay := base
+	for i := uint32(0); i < attempt && delay < max; i++ {
+		if delay > max/2 {
+			return max
+		}
+		delay *= 2
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+// parseRetryAfter parses the Retry-After header in either its delay-seconds or HTTP-date form.
+// It returns false if the header is absent or can't be parsed.
+func parseRetryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := date.Sub(now); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
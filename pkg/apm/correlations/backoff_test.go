@@ -0,0 +1,187 @@
+package correlations
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/signalfx/signalfx-agent/pkg/apm/requests/requestcounter"
+)
+
+func TestFullJitterBackoffBounds(t *testing.T) {
+	cc := &Client{
+		jitter:          randomJitter,
+		retryDelay:      time.Second,
+		backoffStrategy: BackoffFullJitter,
+		maxRetryDelay:   10 * time.Second,
+	}
+
+	for _, tc := range []struct {
+		attempt uint32
+		max     time.Duration
+	}{
+		{attempt: 0, max: time.Second},
+		{attempt: 1, max: 2 * time.Second},
+		{attempt: 3, max: 8 * time.Second},
+		// capped from here on
+		{attempt: 4, max: 10 * time.Second},
+		{attempt: 100, max: 10 * time.Second},
+	} {
+		r := &request{ctx: requestcounter.ContextWithRequestCounter(context.Background())}
+		for i := uint32(0); i < tc.attempt; i++ {
+			requestcounter.IncrementRequestCount(r.ctx)
+		}
+		for i := 0; i < 100; i++ {
+			delay := cc.retryDelayFor(r)
+			require.GreaterOrEqual(t, int64(delay), int64(0))
+			require.Less(t, int64(delay), int64(tc.max), "attempt %d", tc.attempt)
+		}
+	}
+}
+
+func TestFullJitterBackoffUsesUpperBound(t *testing.T) {
+	cc := &Client{
+		// return the upper bound so that the computed range can be checked exactly
+		jitter:          func(max time.Duration) time.Duration { return max },
+		retryDelay:      time.Second,
+		backoffStrategy: BackoffFullJitter,
+	}
+	r := &request{ctx: requestcounter.ContextWithRequestCounter(context.Background())}
+	requestcounter.IncrementRequestCount(r.ctx)
+	requestcounter.IncrementRequestCount(r.ctx)
+	require.Equal(t, 4*time.Second, cc.retryDelayFor(r))
+
+	// the per request retry delay replaces the base delay
+	r.opts.RetryDelay = 10 * time.Millisecond
+	require.Equal(t, 40*time.Millisecond, cc.retryDelayFor(r))
+}
+
+func TestConstantBackoff(t *testing.T) {
+	cc := &Client{retryDelay: time.Second}
+	r := &request{ctx: requestcounter.ContextWithRequestCounter(context.Background())}
+	requestcounter.IncrementRequestCount(r.ctx)
+	require.Equal(t, time.Second, cc.retryDelayFor(r))
+}
+
+func TestValidateBackoffStrategy(t *testing.T) {
+	require.NoError(t, validateBackoffStrategy(""))
+	require.NoError(t, validateBackoffStrategy(BackoffConstant))
+	require.NoError(t, validateBackoffStrategy(BackoffFullJitter))
+	require.Error(t, validateBackoffStrategy("linear"))
+}
+
+func TestExponentialDelayDoesNotOverflow(t *testing.T) {
+	require.Equal(t, time.Duration(math.MaxInt64), exponentialDelay(time.Second, 0, 1000))
+}
+
+func TestInitialRetryDelay(t *testing.T) {
+	cc := &Client{retryDelay: time.Second, initialRetryDelay: 5 * time.Second}
+	r := &request{ctx: requestcounter.ContextWithRequestCounter(context.Background())}
+	require.Equal(t, 5*time.Second, cc.retryDelayFor(r))
+	requestcounter.IncrementRequestCount(r.ctx)
+	require.Equal(t, time.Second, cc.retryDelayFor(r))
+
+	// it is the base of the first retry when backing off exponentially
+	cc.backoffStrategy = BackoffFullJitter
+	cc.jitter = func(max time.Duration) time.Duration { return max }
+	r = &request{ctx: requestcounter.ContextWithRequestCounter(context.Background())}
+	require.Equal(t, 5*time.Second, cc.retryDelayFor(r))
+	requestcounter.IncrementRequestCount(r.ctx)
+	require.Equal(t, 2*time.Second, cc.retryDelayFor(r))
+}
+
+func TestValidateEnvironmentRetryDelays(t *testing.T) {
+	require.NoError(t, validateEnvironmentRetryDelays(nil))
+	require.NoError(t, validateEnvironmentRetryDelays(map[string]time.Duration{"prod": time.Second, "dev": 0}))
+	require.Error(t, validateEnvironmentRetryDelays(map[string]time.Duration{"prod": -time.Second}))
+	require.Error(t, validateEnvironmentRetryDelays(map[string]time.Duration{"": time.Second}))
+}
+
+func TestEnvironmentRetryDelays(t *testing.T) {
+	cc := &Client{retryDelay: time.Second}
+	cc.conf.EnvironmentRetryDelays = map[string]time.Duration{"prod": 10 * time.Second}
+	newRequest := func(typ Type, value string) *request {
+		r := &request{
+			Correlation: &Correlation{Type: typ, DimName: "host", DimValue: "a", Value: value},
+			ctx:         requestcounter.ContextWithRequestCounter(context.Background()),
+		}
+		requestcounter.IncrementRequestCount(r.ctx)
+		return r
+	}
+
+	require.Equal(t, 10*time.Second, cc.retryDelayFor(newRequest(Environment, "prod")))
+	require.Equal(t, time.Second, cc.retryDelayFor(newRequest(Environment, "dev")))
+	// services with the same name as an environment aren't overridden
+	require.Equal(t, time.Second, cc.retryDelayFor(newRequest(Service, "prod")))
+
+	// the request's own retry delay takes precedence
+	r := newRequest(Environment, "prod")
+	r.opts.RetryDelay = 3 * time.Second
+	require.Equal(t, 3*time.Second, cc.retryDelayFor(r))
+
+	// it is the base of the backoff
+	cc.backoffStrategy = BackoffFullJitter
+	cc.jitter = func(max time.Duration) time.Duration { return max }
+	require.Equal(t, 20*time.Second, cc.retryDelayFor(newRequest(Environment, "prod")))
+}
+
+func TestGetRetryPolicy(t *testing.T) {
+	cc := &Client{retryDelay: time.Second, initialRetryDelay: 5 * time.Second, maxAttempts: 3}
+	newRequest := func(op Operation, attempts int) *request {
+		r := &request{
+			Correlation: &Correlation{DimName: "host", DimValue: "a"},
+			operation:   op,
+			ctx:         requestcounter.ContextWithRequestCounter(context.Background()),
+		}
+		for i := 0; i < attempts; i++ {
+			requestcounter.IncrementRequestCount(r.ctx)
+		}
+		return r
+	}
+
+	// gets use the same policy as writes unless they have their own
+	require.Equal(t, uint32(3), cc.maxAttemptsFor(OperationGet))
+	require.Equal(t, 5*time.Second, cc.retryDelayFor(newRequest(OperationGet, 0)))
+
+	cc.conf.GetMaxRetries = 9
+	cc.conf.GetRetryDelay = 100 * time.Millisecond
+	require.Equal(t, uint32(10), cc.maxAttemptsFor(OperationGet))
+	require.Equal(t, 100*time.Millisecond, cc.retryDelayFor(newRequest(OperationGet, 0)))
+	require.Equal(t, 100*time.Millisecond, cc.retryDelayFor(newRequest(OperationGet, 1)))
+
+	// writes are unaffected
+	for _, op := range []Operation{OperationCorrelate, OperationDelete} {
+		require.Equal(t, uint32(3), cc.maxAttemptsFor(op))
+		require.Equal(t, 5*time.Second, cc.retryDelayFor(newRequest(op, 0)))
+		require.Equal(t, time.Second, cc.retryDelayFor(newRequest(op, 1)))
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	cc := &Client{}
+	for _, op := range []Operation{OperationCorrelate, OperationDelete, OperationGet} {
+		require.True(t, cc.shouldRetry(op, 0))
+		require.True(t, cc.shouldRetry(op, http.StatusServiceUnavailable))
+		require.True(t, cc.shouldRetry(op, http.StatusSwitchingProtocols))
+		require.False(t, cc.shouldRetry(op, http.StatusTemporaryRedirect))
+		require.False(t, cc.shouldRetry(op, http.StatusBadRequest))
+		require.False(t, cc.shouldRetry(op, http.StatusNotFound))
+	}
+
+	// only correlates that aren't found are retried
+	cc.retryNotFound = true
+	require.True(t, cc.shouldRetry(OperationCorrelate, http.StatusNotFound))
+	require.False(t, cc.shouldRetry(OperationCorrelate, http.StatusBadRequest))
+	require.False(t, cc.shouldRetry(OperationDelete, http.StatusNotFound))
+	require.False(t, cc.shouldRetry(OperationGet, http.StatusNotFound))
+
+	// a correlate that reached the max entries isn't retried even if the status is a 5xx
+	cc.maxEntriesStatus = http.StatusServiceUnavailable
+	require.False(t, cc.shouldRetry(OperationCorrelate, http.StatusServiceUnavailable))
+	require.True(t, cc.shouldRetry(OperationDelete, http.StatusServiceUnavailable))
+	require.True(t, cc.shouldRetry(OperationCorrelate, 0))
+}
@@ -0,0 +1,36 @@
+package correlations
+
+import (
+	"context"
+)
+
+// CorrelateBlocking is like Correlate but waits for room on the request queue when it is full
+// rather than dropping the request, so that a caller producing correlations faster than they can
+// be sent is slowed down instead.  It returns an error if the request couldn't be queued, e.g.
+// because ctx was done first, and the callback is invoked with it as for Correlate.  The overflow
+// buffer and DropPolicy aren't used while waiting.  Like Correlate, it cancels the delete scheduled
+// by CorrelateWithTTL.
+//
+// The queue is only drained by the client's routines, which also invoke the callbacks, so calling
+// CorrelateBlocking from a callback or an Observer can deadlock the client once the queue is full.
+// Use Correlate there instead.
+func (cc *Client) CorrelateBlocking(ctx context.Context, cor *Correlation, cb CorrelateCB, opts ...RequestOptions) error {
+	cc.expiries.cancel(*cor)
+	r := cc.correlateRequest(cor, cb, mergeRequestOptions(opts))
+	r.enqueueCtx = ctx
+	return cc.putRequestOnChan(r)
+}
+
+// putRequestBlocking waits until the request can be put on the channel, its enqueue context is
+// done or the client is shut down
+func (cc *Client) putRequestBlocking(requestChan chan *request, r *request) error {
+	select {
+	case requestChan <- r:
+		return nil
+	case <-cc.ctx.Done():
+		return errShutdown
+	case <-r.enqueueCtx.Done():
+		err := r.enqueueCtx.Err()
+		return &DroppedError{Cause: DropCauseChannelFull, msg: "request channel full: " + err.Error(), err: err}
+	}
+}
@@ -0,0 +1,52 @@
+package correlations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelationClientCorrelateBlocking(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.MaxBuffered = 2
+	})
+	defer cancel()
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, client.CorrelateBlocking(context.Background(), &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: fmt.Sprint(i)}, nil))
+	}
+
+	// the queue is full and nothing is draining it
+	ctx, cancelWait := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelWait()
+	err := client.CorrelateBlocking(ctx, &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "timeout"}, nil)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	cause, ok := dropCauseForErr(err)
+	require.True(t, ok)
+	require.Equal(t, DropCauseChannelFull, cause)
+	require.Equal(t, int64(1), client.TotalDropped(DropCauseChannelFull))
+
+	// the caller waits until the client makes room
+	queued := make(chan error, 1)
+	done := make(chan error, 1)
+	go func() {
+		queued <- client.CorrelateBlocking(context.Background(), &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "waits"}, func(_ *Correlation, err error) {
+			done <- err
+		})
+	}()
+	select {
+	case <-queued:
+		require.FailNow(t, "request was queued while the queue was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	client.Start()
+	require.NoError(t, <-queued)
+	require.NoError(t, <-done)
+	require.Len(t, waitForCors(serverCh, 3, 5), 3)
+	require.Equal(t, int64(1), client.TotalDropped(DropCauseChannelFull))
+}
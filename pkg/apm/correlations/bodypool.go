@@ -0,0 +1,56 @@
+package correlations
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultMaxPooledBodySize is the largest buffer kept for reuse when no maximum is configured
+const defaultMaxPooledBodySize = 4096
+
+// bodyPool reuses the buffers that correlation PUT bodies are written to.  Buffers that have grown
+// beyond maxSize are discarded instead of being returned to the pool so that an occasional large
+// body doesn't keep a large allocation alive.
+// this is threadsafe
+type bodyPool struct {
+	pool    sync.Pool
+	maxSize int
+
+	hits   int64
+	misses int64
+}
+
+func newBodyPool(maxSize uint) *bodyPool {
+	if maxSize == 0 {
+		maxSize = defaultMaxPooledBodySize
+	}
+	return &bodyPool{maxSize: int(maxSize)}
+}
+
+// get returns a buffer containing the value
+func (p *bodyPool) get(value string) *bytes.Buffer {
+	buf, ok := p.pool.Get().(*bytes.Buffer)
+	if ok {
+		atomic.AddInt64(&p.hits, 1)
+		buf.Reset()
+	} else {
+		atomic.AddInt64(&p.misses, 1)
+		buf = &bytes.Buffer{}
+	}
+	buf.WriteString(value)
+	return buf
+}
+
+// put returns the buffer to the pool unless it is larger than the maximum size
+func (p *bodyPool) put(buf *bytes.Buffer) {
+	if buf.Cap() > p.maxSize {
+		return
+	}
+	p.pool.Put(buf)
+}
+
+// stats returns the number of buffers that were reused and that had to be allocated
+func (p *bodyPool) stats() (hits int64, misses int64) {
+	return atomic.LoadInt64(&p.hits), atomic.LoadInt64(&p.misses)
+}
@@ -0,0 +1,30 @@
+package correlations
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBodyPoolDiscardsOversizedBuffers(t *testing.T) {
+	p := newBodyPool(128)
+
+	buf := p.get("service")
+	require.Equal(t, "service", buf.String())
+	p.put(buf)
+
+	buf = p.get("other-service")
+	require.Equal(t, "other-service", buf.String())
+	hits, misses := p.stats()
+	require.Equal(t, int64(1), hits)
+	require.Equal(t, int64(1), misses)
+
+	// growing the buffer past the maximum keeps it out of the pool
+	buf.WriteString(strings.Repeat("x", 256))
+	p.put(buf)
+	require.Equal(t, "service", p.get("service").String())
+	hits, misses = p.stats()
+	require.Equal(t, int64(1), hits)
+	require.Equal(t, int64(2), misses)
+}
@@ -0,0 +1,43 @@
+package correlations
+
+import (
+	"time"
+)
+
+// remainingBudget returns how much of the request's budget is left.  ok is false if the request
+// has no budget.
+func (cc *Client) remainingBudget(r *request) (remaining time.Duration, ok bool) {
+	cc.RLock()
+	budget := cc.conf.RequestBudget
+	cc.RUnlock()
+	if budget <= 0 {
+		return 0, false
+	}
+	return r.enqueuedAt.Add(budget).Sub(cc.now()), true
+}
+
+// attemptTimeout returns how long the next attempt of the request may take, which is the lesser
+// of the attempt timeout and the remaining budget so that the last attempt can't overrun the
+// budget.  The adaptive timeout is used instead of the static one once it is available.  ok is
+// false if the attempt isn't limited.
+func (cc *Client) attemptTimeout(r *request) (timeout time.Duration, ok bool) {
+	timeout, ok = cc.adaptiveTimeout()
+	if !ok {
+		cc.RLock()
+		timeout = cc.conf.AttemptTimeout
+		cc.RUnlock()
+		ok = timeout > 0
+	}
+
+	if remaining, hasBudget := cc.remainingBudget(r); hasBudget && (!ok || remaining < timeout) {
+		return remaining, true
+	}
+	return timeout, ok
+}
+
+// exceedsBudget returns whether a retry of the request after the delay would start after its
+// budget has run out
+func (cc *Client) exceedsBudget(r *request, delay time.Duration) bool {
+	remaining, ok := cc.remainingBudget(r)
+	return ok && remaining <= delay
+}
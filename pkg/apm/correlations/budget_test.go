@@ -0,0 +1,77 @@
+package correlations
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttemptTimeout(t *testing.T) {
+	now := time.Now()
+	cc := &Client{now: func() time.Time { return now }}
+	r := &request{enqueuedAt: now}
+
+	_, ok := cc.attemptTimeout(r)
+	require.False(t, ok)
+	require.False(t, cc.exceedsBudget(r, time.Hour))
+
+	cc.conf.AttemptTimeout = 10 * time.Second
+	timeout, ok := cc.attemptTimeout(r)
+	require.True(t, ok)
+	require.Equal(t, 10*time.Second, timeout)
+
+	// the remaining budget is smaller than the attempt timeout
+	cc.conf.RequestBudget = 30 * time.Second
+	now = now.Add(25 * time.Second)
+	timeout, ok = cc.attemptTimeout(r)
+	require.True(t, ok)
+	require.Equal(t, 5*time.Second, timeout)
+	require.False(t, cc.exceedsBudget(r, time.Second))
+	require.True(t, cc.exceedsBudget(r, 5*time.Second))
+
+	// the budget alone limits the attempt
+	cc.conf.AttemptTimeout = 0
+	timeout, ok = cc.attemptTimeout(r)
+	require.True(t, ok)
+	require.Equal(t, 5*time.Second, timeout)
+}
+
+func TestCorrelationClientRequestBudget(t *testing.T) {
+	var attempts int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&attempts, 1)
+		// hang until the attempt is cut short
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.AttemptTimeout = 10 * time.Second
+		conf.RequestBudget = 200 * time.Millisecond
+	})
+	defer cancel()
+	client.Start()
+
+	start := time.Now()
+	errs := make(chan error, 1)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, err error) {
+		errs <- err
+	}))
+
+	select {
+	case err := <-errs:
+		var reqErr *RequestError
+		require.True(t, errors.As(err, &reqErr))
+	case <-time.After(3 * time.Second):
+		t.Fatal("attempt was not limited to the remaining budget")
+	}
+	require.Less(t, int64(time.Since(start)), int64(2*time.Second))
+	// the retry was abandoned rather than starting after the budget ran out
+	require.Equal(t, int64(1), atomic.LoadInt64(&attempts))
+	require.Equal(t, int64(1), client.TotalDropped(DropCauseBudgetExceeded))
+}
@@ -1,13 +1,16 @@
 package correlations
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"math/rand"
+	"mime"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
-	"strings"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -19,27 +22,48 @@ import (
 	"github.com/signalfx/signalfx-agent/pkg/apm/requests/requestcounter"
 )
 
-var ErrChFull = errors.New("request channel full")
-var errRetryChFull = errors.New("retry channel full")
-var errMaxAttempts = errors.New("maximum attempts exceeded")
-var errRequestCancelled = errors.New("request cancelled")
+// logThrottleDuration is how often the same throttled message may be logged
+const logThrottleDuration = 20 * time.Second
+
+// defaultPutContentType is the Content-Type of correlation PUT bodies when none is configured
+const defaultPutContentType = "text/plain"
+
+// defaultAuthHeader is the header the access token is sent in when none is configured
+const defaultAuthHeader = "X-SF-TOKEN"
 
 // ErrMaxEntries is an error returned when the correlation endpoint returns a 418 http status
-// code indicating that the set of services or environments is too large to add another value
+// code, or the configured MaxEntriesStatusCode, indicating that the set of services or
+// environments is too large to add another value
 type ErrMaxEntries struct {
 	MaxEntries int64 `json:"max,omitempty"`
+	// status is the status code the response had if it wasn't 418
+	status int
 }
 
 func (m *ErrMaxEntries) Error() string {
 	return fmt.Sprintf("max entries %d", m.MaxEntries)
 }
 
+// Retryable returns false because the set of values won't shrink by retrying
+func (m *ErrMaxEntries) Retryable() bool {
+	return false
+}
+
+// StatusCode returns the status code the endpoint responded with, 418 unless MaxEntriesStatusCode
+// is set
+func (m *ErrMaxEntries) StatusCode() int {
+	if m.status != 0 {
+		return m.status
+	}
+	return http.StatusTeapot
+}
+
 var _ error = (*ErrMaxEntries)(nil)
 
 // CorrelationClient is an interface for correlations.Client
 type CorrelationClient interface {
-	Correlate(*Correlation, CorrelateCB)
-	Delete(*Correlation, SuccessfulDeleteCB)
+	Correlate(*Correlation, CorrelateCB, ...RequestOptions)
+	Delete(*Correlation, SuccessfulDeleteCB, ...RequestOptions)
 	Get(dimName string, dimValue string, cb SuccessfulGetCB)
 	InternalMetrics() []*datapoint.Datapoint
 	Start()
@@ -49,16 +73,62 @@ type request struct {
 	*Correlation
 	ctx       context.Context
 	cancel    context.CancelFunc
-	operation string
-	callback  func(body []byte, statuscode int, err error)
+	operation Operation
+	callback  func(body []byte, statuscode int, header http.Header, err error)
 	sendAt    time.Time
+	opts      RequestOptions
+	// body is the pooled buffer holding the PUT body while the request is in flight
+	body *bytes.Buffer
+	// held is true while a delete is being held back for the collapse window
+	held bool
+	// enqueuedAt is when the request was first put on the request channel
+	enqueuedAt time.Time
+	// id identifies the request in the registry
+	id uint64
+	// pending is true from when the request is queued until it completes, guarded by the
+	// registry's lock
+	pending bool
+	// retrying is true while a retry of the request holds a retry slot
+	retrying bool
+	// taken is true once a coalesced correlate has been taken off the queue, guarded by the
+	// coalescer's lock
+	taken bool
+	// enqueueCtx is set for a request that waits for room on the queue until it is done rather
+	// than being dropped when the queue is full
+	enqueueCtx context.Context
+	// scheduledAt is sendAt in unix nanoseconds, set atomically by setSendAt so that
+	// ExportPending can read it
+	scheduledAt int64
+	// completed is set atomically once the callback has been invoked
+	completed int32
+	// retryFrom is when the request was queued to be retried, which its retry time is counted from
+	retryFrom time.Time
+	// retryClass is the class of error the request is being retried for, which scales its delay
+	retryClass errorClass
+	// retryHinted is true if the server said when to retry the request
+	retryHinted bool
+	// retryNotBefore is the earliest the request may be retried to keep the minimum interval
+	// between retries of its correlation
+	retryNotBefore time.Time
+}
+
+// complete invokes the request's callback unless it has already been invoked, so that a request
+// that is cancelled or dropped while it is also being sent reports a single outcome
+func (r *request) complete(body []byte, statuscode int, header http.Header, err error) bool {
+	if atomic.CompareAndSwapInt32(&r.completed, 0, 1) {
+		r.callback(body, statuscode, header, err)
+		return true
+	}
+	return false
 }
 
 // Client is a client for making dimensional correlations
 type Client struct {
 	sync.RWMutex
 	log           log.Logger
+	throttledLog  *log.ThrottledLogger
 	ctx           context.Context
+	stop          context.CancelFunc
 	wg            sync.WaitGroup
 	Token         string
 	APIURL        *url.URL
@@ -67,20 +137,136 @@ type Client struct {
 	requestChan   chan *request
 	retryChan     chan *request
 	dedup         *deduplicator
+	// sentDedup remembers the requests that were sent in the dedup state file, nil if disabled
+	sentDedup *sentDeduplicator
+	// sharedSender is true if the request sender was passed in the ClientConfig
+	sharedSender bool
+
+	// highPriorityChan holds requests that are sent before any on requestChan
+	highPriorityChan chan *request
+	// overflowChan holds normal priority requests that didn't fit on requestChan, nil if disabled
+	overflowChan chan *request
+	// heldDeletes holds deletes until the collapse window passes, it is only used by processChan
+	heldDeletes    *retryQueue
+	collapseWindow time.Duration
+	priorityAging  time.Duration
 
 	// For easier unit testing
 	now        func() time.Time
+	jitter     func(max time.Duration) time.Duration
 	logUpdates bool
+	logSampler *logSampler
+
+	retryDelay        time.Duration
+	initialRetryDelay time.Duration
+	maxAttempts       uint32
+	backoffStrategy   BackoffStrategy
+	maxRetryDelay     time.Duration
 
-	retryDelay  time.Duration
-	maxAttempts uint32
+	// connTrace is used to record connection reuse when connection tracing is enabled
+	connTrace *httptrace.ClientTrace
 
 	TotalClientError4xxResponses int64
 	TotalRetriedUpdates          int64
 	TotalInvalidDimensions       int64
+	TotalCallbackPanics          int64
+	TotalInvalidValues           int64
+	TotalConnReused              int64
+	TotalConnNew                 int64
+	TotalFailedDeletes           int64
+	TotalEvictedRequests         int64
+	TotalHedgedRequests          int64
+	TotalHedgeWins               int64
+	TotalCollapsedRequests       int64
+	TotalGetBytesSaved           int64
+	TotalNegativeCacheHits       int64
+	TotalCoalescedRequests       int64
+	TotalURLTooLong              int64
+	TotalFlushedRetries          int64
+	TotalAgedRequests            int64
+	TotalRetrySucceeded          int64
+	TotalRetryExhausted          int64
+	TotalDroppedOutcomes         int64
+	TotalRequestBytes            int64
+	TotalResponseBytes           int64
+	totalDedupSaved              int64
+	totalDedupSavedBytes         int64
+	totalDropped                 [numDropCauses]int64
+	totalCompleted               [OperationGet + 1][numRequestResults]int64
+	totalErrorsByClass           [numErrorClasses]int64
+	dropOldest                   bool
+	putContentType               string
+	signer                       RequestSigner
+	ttlHeader                    string
+	ttlFallback                  TTLFallback
+	expiries                     *expiries
+	shedder                      *shedder
+	dimensionQueues              *dimensionQueues
+	onDeduplicated               func(cor *Correlation)
+	onMaxEntries                 func(cor *Correlation, max int64)
+	onMaxEntriesResolved         func(cor *Correlation)
+	cappedDimensions             *cappedDimensions
+	pauser                       pauser
+	onRetryEnqueued              func(cor *Correlation, attempt uint32)
+	onRetryDequeued              func(cor *Correlation, attempt uint32)
+	warmUpClient                 *http.Client
+	warmUpConnections            int
+	warmedUp                     chan struct{}
+	flushRetriesCh               chan struct{}
+	rescheduleRetriesCh          chan struct{}
+	pathEscaper                  PathEscaper
+	redirectPolicy               RedirectPolicy
+	maxResponseBodySize          int64
+	retryNotFound                bool
+	maxEntriesStatus             int
+	registry                     *registry
+	emitter                      Emitter
+	emitInterval                 time.Duration
+	dimensionCounts              *keyCounter
+	sources                      *sourceCounters
+	enqueueRetries               uint
+	enqueueRetryDelay            time.Duration
+	requeueSlots                 chan struct{}
+	negativeCache                *negativeCache
+	retrySlots                   chan struct{}
+	retriesInFlight              int64
+	observer                     Observer
+	coalescer                    *coalescer
+	health                       *healthTracker
+	getSlots                     chan struct{}
+	bodies                       *bodyPool
+	watchdog                     *watchdog
+	types                        *typeFilter
+	dimensions                   *dimensionFilter
+	hedger                       *hedger
+	retryQueueLen                int64
+	inFlight                     int64
+	stopping                     int32 // set atomically once Stop is called
+	retryQueueEMA                *movingAverage
+	results                      chan RequestOutcome
+	retryLimiter                 *retryLimiter
+	eventEmitter                 EventEmitter
+	resultsBlock                 bool
+	latencyEMA                   *movingAverage
+	requestAge                   *durationHistogram
+	queuedBytes                  int64
+	maxQueuedBytes               int64
 	dedupCleanupInterval         time.Duration
+	startupJitter                time.Duration
+	// conf is the configuration the client is currently running with
+	conf Config
 }
 
+// DropPolicy determines which request is dropped when the request channel is full
+type DropPolicy string
+
+const (
+	// DropNewest rejects the request being enqueued with ErrChFull
+	DropNewest DropPolicy = "drop_newest"
+	// DropOldest evicts the oldest queued request to make room for the request being enqueued
+	DropOldest DropPolicy = "drop_oldest"
+)
+
 // Config defines configuration for correlation settings.
 type Config struct {
 	MaxRequests     uint          `mapstructure:"max_requests"`
@@ -89,6 +275,245 @@ type Config struct {
 	LogUpdates      bool          `mapstructure:"log_updates"`
 	RetryDelay      time.Duration `mapstructure:"retry_delay"`
 	CleanupInterval time.Duration `mapstructure:"cleanup_interval"`
+	// StartupJitter is the maximum random delay before the client starts
+	// processing requests.  This spreads out the initial load when many
+	// agents are restarted at the same time.
+	StartupJitter time.Duration `mapstructure:"startup_jitter"`
+	// TraceConnections enables tracking whether requests reuse idle connections
+	// or dial new ones.
+	TraceConnections bool `mapstructure:"trace_connections"`
+	// DropPolicy determines what is dropped when the request channel is full.
+	// Defaults to DropNewest.
+	DropPolicy DropPolicy `mapstructure:"drop_policy"`
+	// PutContentType is the Content-Type sent with correlation PUT bodies.
+	// Defaults to text/plain.
+	PutContentType string `mapstructure:"put_content_type"`
+	// MaxTrackedDimensions enables counting requests per dimension name for up to this many
+	// dimension names.  Requests for any further dimension names are counted as "other".
+	// Disabled when 0.
+	MaxTrackedDimensions uint `mapstructure:"max_tracked_dimensions"`
+	// MaxTrackedSources is the number of sources, set with RequestOptions.Source, that requests
+	// are counted for.  Requests from any further sources are counted as "other".  Defaults to 10.
+	MaxTrackedSources uint `mapstructure:"max_tracked_sources"`
+	// BackoffStrategy determines how the delay between retries is computed.
+	// Defaults to BackoffConstant.
+	BackoffStrategy BackoffStrategy `mapstructure:"backoff_strategy"`
+	// MaxRetryDelay caps the delay between retries for the BackoffFullJitter strategy.
+	// The delay is uncapped when 0.
+	MaxRetryDelay time.Duration `mapstructure:"max_retry_delay"`
+	// InitialRetryDelay, if set, is the delay before the first retry of a request in place of the
+	// RetryDelay, so that the backend can be given longer to recover from the first failure.  It
+	// is also the base of the first retry for the BackoffFullJitter strategy.
+	InitialRetryDelay time.Duration `mapstructure:"initial_retry_delay"`
+	// LogUpdatesSampleRate, if greater than 1, limits the successful updates logged when
+	// LogUpdates is enabled to 1 in this many on average.  Every update is still counted.
+	LogUpdatesSampleRate uint `mapstructure:"log_updates_sample_rate"`
+	// LogUpdatesSampleSeed seeds the choice of which updates are logged so that the same updates
+	// are logged on every run.  A random seed is used when 0.
+	LogUpdatesSampleSeed int64 `mapstructure:"log_updates_sample_seed"`
+	// OverflowBuffered is the number of normal priority requests that are buffered in a secondary
+	// buffer once MaxBuffered requests are already waiting, to absorb bursts.  Requests only go to
+	// the overflow while the primary buffer is full and are moved to it as room is made.  Disabled
+	// when 0.
+	OverflowBuffered uint `mapstructure:"overflow_buffered"`
+	// HealthFailureThreshold is the number of consecutive server errors, connection failures, or
+	// requests dropped because the request channel is full after which the client is considered
+	// unhealthy.  Defaults to 5.
+	HealthFailureThreshold uint `mapstructure:"health_failure_threshold"`
+	// HealthDebounce is how long a change in health must persist before it is reported.
+	HealthDebounce time.Duration `mapstructure:"health_debounce"`
+	// MaxGetRequests limits how many Get requests may be in flight at once so that they can't use
+	// up all of MaxRequests and delay updates.  Get requests are only limited by MaxRequests when 0.
+	MaxGetRequests uint `mapstructure:"max_get_requests"`
+	// MaxPooledBodySize is the largest PUT body buffer in bytes that is kept for reuse.  Larger
+	// buffers are discarded once their request completes.  Defaults to 4096.
+	MaxPooledBodySize uint `mapstructure:"max_pooled_body_size"`
+	// StallWindow is how long requests may be made without any succeeding before OnStalled is
+	// called.  Stalls aren't detected when 0.
+	StallWindow time.Duration `mapstructure:"stall_window"`
+	// AllowedTypes, if set, are the only correlation types that are sent.  Only one of AllowedTypes
+	// and DeniedTypes may be set.
+	AllowedTypes []Type `mapstructure:"allowed_types"`
+	// DeniedTypes are correlation types that are never sent
+	DeniedTypes []Type `mapstructure:"denied_types"`
+	// HedgePercentile enables hedging Get requests.  A Get that hasn't responded within this
+	// percentile of recent Get latencies, e.g. 0.95, is sent again and whichever response arrives
+	// first is used.  Hedging is disabled when 0.
+	HedgePercentile float64 `mapstructure:"hedge_percentile"`
+	// HedgeDelay is the minimum time to wait before hedging a Get, and the time waited until
+	// enough latencies have been observed to compute the percentile.
+	HedgeDelay time.Duration `mapstructure:"hedge_delay"`
+	// RetryQueueEMADecay is the weight, between 0 and 1, given to each new sample of the number of
+	// requests waiting to be retried when computing its moving average.  Defaults to 0.1.
+	RetryQueueEMADecay float64 `mapstructure:"retry_queue_ema_decay"`
+	// MaxQueuedBytes bounds the approximate memory used by requests waiting to be sent or retried.
+	// Requests that would exceed it are rejected.  Unbounded when 0.
+	MaxQueuedBytes uint `mapstructure:"max_queued_bytes"`
+	// CollapseWindow is how long a delete is held before it is sent.  If a correlate for the same
+	// dimension, type and value arrives while the delete is held, neither is sent and neither
+	// callback is invoked, on the assumption that the correlation still exists.  Disabled when 0.
+	CollapseWindow time.Duration `mapstructure:"collapse_window"`
+	// DedupMaxEntries is how many pending correlates and, separately, deletes the deduplicator
+	// remembers.  The oldest are forgotten once it is full, so a larger value catches duplicates
+	// made further apart at the cost of memory.  Defaults to MaxBuffered when 0.
+	DedupMaxEntries int `mapstructure:"dedup_max_entries"`
+	// DedupStateFile is the file in which the correlates and deletes that were sent successfully are
+	// remembered, keyed by a hash of their content, so that identical requests made within
+	// DedupStateWindow after a restart are deduplicated and complete successfully without being
+	// sent.  It is loaded when the client starts
+	// and saved every CleanupInterval, if set, and when it stops.  Disabled when empty.
+	DedupStateFile string `mapstructure:"dedup_state_file"`
+	// DedupStateWindow is how long a request in the DedupStateFile is remembered.  Defaults to an
+	// hour when 0.
+	DedupStateWindow time.Duration `mapstructure:"dedup_state_window"`
+	// DedupStateMaxEntries bounds the number of requests in the DedupStateFile.  The oldest are
+	// forgotten once it is full.  Defaults to 10000 when 0.
+	DedupStateMaxEntries int `mapstructure:"dedup_state_max_entries"`
+	// PriorityAging is how long a normal priority request can wait before it is sent ahead of
+	// high priority requests, so that a continuous stream of high priority requests can't hold it
+	// back indefinitely.  Requests queued behind it age too, so each waits at most about this long
+	// once it reaches the front of the queue.  Disabled when 0, in which case high priority
+	// requests are always sent first.
+	PriorityAging time.Duration `mapstructure:"priority_aging"`
+	// ResultsBuffered enables the channel returned by Results and is how many outcomes it holds
+	// for a slow consumer.  Every request then allocates an outcome when it completes, so leave it
+	// disabled unless the outcomes are consumed.  Disabled when 0.
+	ResultsBuffered uint `mapstructure:"results_buffered"`
+	// ResultsBlock makes the client wait for the consumer when the results channel is full rather
+	// than dropping the outcome.  A slow consumer then holds up sending requests and retries.
+	ResultsBlock bool `mapstructure:"results_block"`
+	// TTLHeader, if set, is the header the ttl of correlations made with CorrelateWithTTL is sent
+	// in, in seconds, for backends that expire correlations themselves.
+	TTLHeader string `mapstructure:"ttl_header"`
+	// TTLFallback determines how the ttl of correlations made with CorrelateWithTTL is honored
+	// when TTLHeader isn't set, either "delete" (the default) or "none".
+	TTLFallback TTLFallback `mapstructure:"ttl_fallback"`
+	// ShedThreshold enables load shedding.  While the pressure on the agent exceeds it, normal
+	// priority updates are dropped.  Pressure is the number of goroutines unless
+	// ClientConfig.Pressure is set.  Disabled when 0.
+	ShedThreshold float64 `mapstructure:"shed_threshold"`
+	// AuthHeader is the header the access token is sent in.  Defaults to X-SF-TOKEN.
+	AuthHeader string `mapstructure:"auth_header"`
+	// AuthScheme, if set, is sent before the access token separated by a space, e.g. "Bearer" for
+	// an Authorization header.
+	AuthScheme string `mapstructure:"auth_scheme"`
+	// SerializeByDimension makes requests for a dimension wait until earlier requests for the same
+	// dimension, including their retries, have completed so that they are applied in the order
+	// they were made.  Requests for different dimensions are still sent concurrently.
+	SerializeByDimension bool `mapstructure:"serialize_by_dimension"`
+	// EmitInterval is how often internal metrics are sent to ClientConfig.Emitter.  Defaults to
+	// 10s.
+	EmitInterval time.Duration `mapstructure:"emit_interval"`
+	// TimeoutBackoffMultiplier multiplies the retry delay of requests that timed out.  Defaults
+	// to 1.
+	TimeoutBackoffMultiplier float64 `mapstructure:"timeout_backoff_multiplier"`
+	// ConnectionBackoffMultiplier multiplies the retry delay of requests whose connection was
+	// refused or reset, which usually means the endpoint is down.  Defaults to 1.
+	ConnectionBackoffMultiplier float64 `mapstructure:"connection_backoff_multiplier"`
+	// DNSBackoffMultiplier multiplies the retry delay of requests that failed to resolve the
+	// endpoint's host.  Defaults to 1.
+	DNSBackoffMultiplier float64 `mapstructure:"dns_backoff_multiplier"`
+	// RedirectPolicy determines whether redirects are followed, either "follow" (the default) or
+	// "none".  When following, a CheckRedirect set on the http client still decides whether each
+	// redirect is followed.  It is ignored when ClientConfig.RequestSender is set.
+	RedirectPolicy RedirectPolicy `mapstructure:"redirect_policy"`
+	// AttemptTimeout limits how long each attempt of a request may take.  Unlimited apart from the
+	// http client's timeout when 0.
+	AttemptTimeout time.Duration `mapstructure:"attempt_timeout"`
+	// RequestBudget limits the total time a request may take, from when it is queued through all
+	// of its retries.  Attempts are cut short and retries abandoned once it runs out.  Unlimited
+	// when 0.
+	RequestBudget time.Duration `mapstructure:"request_budget"`
+	// AdaptiveTimeoutMultiplier enables adaptive attempt timeouts when greater than 0.  Each
+	// attempt is then limited to the moving average of request latencies times the multiplier,
+	// bounded by AdaptiveTimeoutMin and AdaptiveTimeoutMax, instead of AttemptTimeout.  Until a
+	// latency has been recorded AttemptTimeout is used.
+	AdaptiveTimeoutMultiplier float64 `mapstructure:"adaptive_timeout_multiplier"`
+	// AdaptiveTimeoutMin is the shortest adaptive timeout
+	AdaptiveTimeoutMin time.Duration `mapstructure:"adaptive_timeout_min"`
+	// AdaptiveTimeoutMax is the longest adaptive timeout.  Unbounded when 0.
+	AdaptiveTimeoutMax time.Duration `mapstructure:"adaptive_timeout_max"`
+	// LatencyEMADecay is the weight, between 0 and 1, given to each new request latency in the
+	// moving average that adaptive timeouts are based on.  Defaults to 0.1.
+	LatencyEMADecay float64 `mapstructure:"latency_ema_decay"`
+	// DebugLogRequests logs the method and endpoint of each request at debug level, throttled so
+	// that each distinct endpoint is logged at most once every 20 seconds.  Useful for diagnosing
+	// how dimension and correlation values are encoded.
+	DebugLogRequests bool `mapstructure:"debug_log_requests"`
+	// RetryLogVerbosity determines how much is logged about retried requests, one of "all",
+	// "first_and_last" or "terminal".  Defaults to "all", which logs each retry at debug level.
+	// The others log through the throttled logger to keep the volume down during outages.
+	RetryLogVerbosity RetryLogVerbosity `mapstructure:"retry_log_verbosity"`
+	// AllowedDimensions, if set, are the only dimension names that are correlated.  Requests for
+	// any other dimension name are dropped.
+	AllowedDimensions []string `mapstructure:"allowed_dimensions"`
+	// AllowedDimensionsCaseInsensitive matches dimension names against AllowedDimensions without
+	// regard to case
+	AllowedDimensionsCaseInsensitive bool `mapstructure:"allowed_dimensions_case_insensitive"`
+	// EnqueueRetries is the number of times a request that doesn't fit on the full request
+	// channel is retried in the background before it is dropped, smoothing over momentary
+	// bursts.  At most MaxBuffered requests are retried at once; any more are dropped
+	// immediately.  Disabled when 0.
+	EnqueueRetries uint `mapstructure:"enqueue_retries"`
+	// EnqueueRetryDelay is how long to wait between attempts to enqueue a request.  Defaults to
+	// 100ms.
+	EnqueueRetryDelay time.Duration `mapstructure:"enqueue_retry_delay"`
+	// NegativeCacheTTL is how long a dimension that a Get didn't find is remembered.  Gets for it
+	// in the meantime are answered as not found without a request.  A successful Correlate for
+	// the dimension forgets it.  Disabled when 0.
+	NegativeCacheTTL time.Duration `mapstructure:"negative_cache_ttl"`
+	// MaxRetriesInFlight limits the number of retries that may be in flight at once so that a
+	// burst of failures doesn't resend every request at the same time.  Retries wait until a
+	// slot is free.  Unlimited when 0.
+	MaxRetriesInFlight uint `mapstructure:"max_retries_in_flight"`
+	// CoalesceCorrelates replaces a queued correlate with a newer correlate for the same
+	// dimension and type, so that only the latest value is sent when the value changes rapidly.
+	// The replaced correlate is cancelled and its callback isn't invoked.  Deletes are never
+	// coalesced.
+	CoalesceCorrelates bool `mapstructure:"coalesce_correlates"`
+	// EnvironmentRetryDelays overrides RetryDelay, the base of the backoff, for environment
+	// correlations by environment name so that environments with lower rate tolerances can be
+	// retried more slowly.  Environments without an override use RetryDelay.
+	EnvironmentRetryDelays map[string]time.Duration `mapstructure:"environment_retry_delays"`
+	// MaxURLLength is the longest endpoint url, including the api url, that a request is sent to.
+	// Requests for longer urls are rejected with an ErrURLTooLong without being sent.  0 means
+	// there is no limit.
+	MaxURLLength int `mapstructure:"max_url_length"`
+	// WarmUpConnections is how many connections to the correlation endpoint's host are
+	// established when the client is started so that the first requests don't wait for them.
+	// The transport of the http.Client passed to NewCorrelationClient must be able to keep that
+	// many idle connections per host, and with a shared RequestSender that client should share
+	// the sender's transport.  0 disables warming up.
+	WarmUpConnections int `mapstructure:"warm_up_connections"`
+	// MaxResponseBodySize is the most bytes of a response body that are read, and the most a
+	// compressed Get response may decompress to.  Longer responses fail with a
+	// *requests.ErrResponseTooLarge and aren't retried, even if they are chunked or their
+	// Content-Length is wrong.  Unlimited when 0.
+	MaxResponseBodySize uint `mapstructure:"max_response_body_size"`
+	// RetryCorrelateNotFound retries Correlate requests that the endpoint responds to with a 404,
+	// for gateways that respond with a 404 while their routes are being deployed.  Other 4xx
+	// responses are never retried.
+	RetryCorrelateNotFound bool `mapstructure:"retry_correlate_not_found"`
+	// MaxEntriesStatusCode is the status code the endpoint responds to a Correlate with when the
+	// dimension already has the maximum number of values of the type, for deployments behind
+	// proxies that rewrite the unusual 418.  A Correlate that receives it isn't retried and fails
+	// with an *ErrMaxEntries.  Defaults to 418 when 0.
+	MaxEntriesStatusCode int `mapstructure:"max_entries_status_code"`
+	// GetMaxRetries replaces MaxRetries for Gets, so that lookups, which are cheap and idempotent,
+	// can be retried more aggressively than updates.  Defaults to MaxRetries when 0.
+	GetMaxRetries uint `mapstructure:"get_max_retries"`
+	// GetRetryDelay is the base of the backoff between retries of a Get in place of RetryDelay and
+	// InitialRetryDelay.  Defaults to those when 0.
+	GetRetryDelay time.Duration `mapstructure:"get_retry_delay"`
+	// Retry configures retries of each operation separately, including turning them off.  Its
+	// settings for Gets take precedence over GetMaxRetries and GetRetryDelay.  Operations it
+	// leaves unset are retried like every other request.
+	Retry RetryConfig `mapstructure:"retry"`
+	// MinRetryInterval is the minimum time between retries of the same dimension, type and value,
+	// even by different requests, which caps the rate of requests for a correlation that keeps
+	// failing.  A retry that would be sent within the interval is scheduled for the end of it
+	// instead.  Unlimited when 0.
+	MinRetryInterval time.Duration `mapstructure:"min_retry_interval"`
 }
 
 // ClientConfig for correlation client.
@@ -96,170 +521,917 @@ type ClientConfig struct {
 	Config
 	AccessToken string
 	URL         *url.URL
+	// RequestSender, if set, is used to send requests instead of a sender owned by the client.
+	// Sharing one sender between several clients makes them respect a single concurrency limit
+	// and report combined request counts.  MaxRequests and the http.Client passed to
+	// NewCorrelationClient are ignored in favor of the sender's own.  The sender's metrics aren't
+	// included in the client's InternalMetrics so that they aren't reported once per client; the
+	// owner of the sender should report its InternalMetrics once instead.
+	RequestSender *requests.ReqSender
+	// OnHealthChange, if set, is called when the client becomes unhealthy or recovers.  The reason
+	// describes the most recent failure when unhealthy.
+	OnHealthChange func(healthy bool, reason string)
+	// OnStalled, if set, is called when requests have been made for the StallWindow without any of
+	// them succeeding.  It is called once per stall.
+	OnStalled func()
+	// Pressure, if set, reports the current pressure on the agent that ShedThreshold is compared
+	// against.
+	Pressure func() float64
+	// OnDeduplicated, if set, is called with the correlation of a Correlate or Delete that wasn't
+	// sent because an identical request was already pending.  The callback of a deduplicated
+	// request is never invoked, so this is the only sign that it was dropped.  It is called from
+	// the goroutine that sends requests and should return quickly.
+	OnDeduplicated func(cor *Correlation)
+	// Emitter, if set, is periodically sent the client's internal metrics so that they don't need
+	// to be polled with InternalMetrics.
+	Emitter Emitter
+	// EventEmitter, if set, is sent an event for each request that fails and won't be retried,
+	// with its dimension and the category of the failure
+	EventEmitter EventEmitter
+	// RequestSigner, if set, authenticates requests instead of sending the AccessToken in the
+	// AuthHeader
+	RequestSigner RequestSigner
+	// OnMaxEntries, if set, is called with a correlation that was rejected because its dimension
+	// already has the maximum number of values for the correlation's type, and that maximum.  It
+	// can be used to prune old values.
+	OnMaxEntries func(cor *Correlation, max int64)
+	// OnMaxEntriesResolved, if set, is called with a correlation that succeeded for a dimension
+	// that had previously reached the maximum number of values for the correlation's type, e.g.
+	// because old values were pruned.  It is called once per recovery.  Up to 10000 capped
+	// dimensions are tracked; recoveries of dimensions beyond that aren't reported.
+	OnMaxEntriesResolved func(cor *Correlation)
+	// Observer, if set, is notified as each request moves through the client
+	Observer Observer
+	// OnRetryEnqueued, if set, is called with a correlation when a failed request is queued to be
+	// retried, and the attempt the retry will be.  Together with OnRetryDequeued it allows metrics
+	// such as how long requests wait to be retried to be kept in any metrics system.  Both are
+	// called from the client's routines without any locks held and should return quickly.
+	OnRetryEnqueued func(cor *Correlation, attempt uint32)
+	// OnRetryDequeued, if set, is called with a correlation when a request is taken off the retry
+	// queue, either to be retried or because it was cancelled while waiting
+	OnRetryDequeued func(cor *Correlation, attempt uint32)
+	// PathEscaper, if set, escapes the dimension name and value, type and value of correlations
+	// for the endpoint path instead of url.PathEscape, for gateways that expect a different
+	// encoding.  The escaped segments are used as is, so an escaper that leaves "/", "?", "#" or
+	// "%" unescaped changes which endpoint a request is sent to or makes the url invalid, and
+	// values that escape to the same segment are treated as the same by the endpoint.  Values
+	// are still validated with url.PathEscape.
+	PathEscaper PathEscaper
 }
 
 // NewCorrelationClient returns a new Client
-func NewCorrelationClient(log log.Logger, ctx context.Context, client *http.Client, conf ClientConfig) (CorrelationClient, error) {
-	sender := requests.NewReqSender(ctx, client, conf.MaxRequests, "correlation")
-	return &Client{
-		log:                  log,
+func NewCorrelationClient(logger log.Logger, ctx context.Context, client *http.Client, conf ClientConfig) (CorrelationClient, error) {
+	switch conf.DropPolicy {
+	case "", DropNewest, DropOldest:
+	default:
+		return nil, fmt.Errorf("invalid correlation drop policy %q", conf.DropPolicy)
+	}
+
+	if err := validateBackoffStrategy(conf.BackoffStrategy); err != nil {
+		return nil, err
+	}
+
+	if err := validateTTLFallback(conf.TTLFallback); err != nil {
+		return nil, err
+	}
+
+	if err := validateRedirectPolicy(conf.RedirectPolicy); err != nil {
+		return nil, err
+	}
+
+	if err := validateEnvironmentRetryDelays(conf.EnvironmentRetryDelays); err != nil {
+		return nil, err
+	}
+
+	if err := validateMaxURLLength(conf.MaxURLLength); err != nil {
+		return nil, err
+	}
+
+	if err := validateWarmUpConnections(conf.WarmUpConnections); err != nil {
+		return nil, err
+	}
+
+	if err := validateAdaptiveTimeout(conf.Config); err != nil {
+		return nil, err
+	}
+
+	if err := validateRetryLogVerbosity(conf.RetryLogVerbosity); err != nil {
+		return nil, err
+	}
+
+	if err := validatePriorityAging(conf.Config); err != nil {
+		return nil, err
+	}
+
+	if err := validateDedupMaxEntries(conf.DedupMaxEntries); err != nil {
+		return nil, err
+	}
+
+	if err := validateDedupState(conf.Config); err != nil {
+		return nil, err
+	}
+
+	if err := validateResults(conf.Config); err != nil {
+		return nil, err
+	}
+
+	if err := validateMinRetryInterval(conf.Config); err != nil {
+		return nil, err
+	}
+
+	if err := validateMaxEntriesStatusCode(conf.MaxEntriesStatusCode); err != nil {
+		return nil, err
+	}
+
+	if err := validateRetryConfig(conf.Retry); err != nil {
+		return nil, err
+	}
+
+	types, err := newTypeFilter(conf.AllowedTypes, conf.DeniedTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	dimensions, err := newDimensionFilter(conf.AllowedDimensions, conf.AllowedDimensionsCaseInsensitive)
+	if err != nil {
+		return nil, err
+	}
+
+	retryQueueEMA, err := newMovingAverage(conf.RetryQueueEMADecay)
+	if err != nil {
+		return nil, err
+	}
+
+	latencyEMA, err := newLatencyEMA(conf.LatencyEMADecay)
+	if err != nil {
+		return nil, err
+	}
+
+	putContentType := conf.PutContentType
+	if putContentType == "" {
+		putContentType = defaultPutContentType
+	}
+	if _, _, err := mime.ParseMediaType(putContentType); err != nil {
+		return nil, fmt.Errorf("invalid correlation put content type %q: %v", putContentType, err)
+	}
+
+	signer := conf.RequestSigner
+	if signer == nil {
+		signer = newHeaderSigner(conf.AuthHeader, conf.AuthScheme, conf.AccessToken)
+	}
+
+	// the client's context is cancelled by Stop as well as by the parent context
+	ctx, stop := context.WithCancel(ctx)
+	sender := conf.RequestSender
+	if sender == nil {
+		sender = requests.NewReqSender(ctx, redirectClient(client, conf.RedirectPolicy, signer), conf.MaxRequests, "correlation")
+	}
+	cc := &Client{
+		log:                  logger,
+		throttledLog:         log.NewThrottledLogger(logger, logThrottleDuration),
 		ctx:                  ctx,
+		stop:                 stop,
 		Token:                conf.AccessToken,
 		APIURL:               conf.URL,
 		requestSender:        sender,
+		sharedSender:         conf.RequestSender != nil,
 		client:               client,
 		now:                  time.Now,
+		jitter:               randomJitter,
 		logUpdates:           conf.LogUpdates,
+		logSampler:           newLogSampler(conf.LogUpdatesSampleRate, conf.LogUpdatesSampleSeed),
 		requestChan:          make(chan *request, conf.MaxBuffered),
+		highPriorityChan:     make(chan *request, conf.MaxBuffered),
 		retryChan:            make(chan *request, conf.MaxBuffered),
-		dedup:                newDeduplicator(int(conf.MaxBuffered)),
+		dedup:                newDeduplicator(dedupSize(conf.Config)),
+		sentDedup:            newSentDeduplicator(conf.Config),
 		retryDelay:           conf.RetryDelay,
+		initialRetryDelay:    conf.InitialRetryDelay,
+		backoffStrategy:      conf.BackoffStrategy,
+		maxRetryDelay:        conf.MaxRetryDelay,
 		maxAttempts:          uint32(conf.MaxRetries) + 1,
 		dedupCleanupInterval: conf.CleanupInterval,
-	}, nil
+		startupJitter:        conf.StartupJitter,
+		dropOldest:           conf.DropPolicy == DropOldest,
+		conf:                 conf.Config,
+		bodies:               newBodyPool(conf.MaxPooledBodySize),
+		types:                types,
+		dimensions:           dimensions,
+		sources:              newSourceCounters(int(conf.MaxTrackedSources)),
+		enqueueRetries:       conf.EnqueueRetries,
+		enqueueRetryDelay:    conf.EnqueueRetryDelay,
+		requeueSlots:         make(chan struct{}, conf.MaxBuffered),
+		hedger:               newHedger(conf.HedgePercentile, conf.HedgeDelay),
+		retryQueueEMA:        retryQueueEMA,
+		latencyEMA:           latencyEMA,
+		requestAge:           newDurationHistogram(defaultRequestAgeBuckets),
+		maxQueuedBytes:       int64(conf.MaxQueuedBytes),
+		putContentType:       putContentType,
+		heldDeletes:          &retryQueue{},
+		collapseWindow:       conf.CollapseWindow,
+		priorityAging:        conf.PriorityAging,
+		resultsBlock:         conf.ResultsBlock,
+		ttlHeader:            conf.TTLHeader,
+		redirectPolicy:       conf.RedirectPolicy,
+		onDeduplicated:       conf.OnDeduplicated,
+		onMaxEntries:         conf.OnMaxEntries,
+		onRetryEnqueued:      conf.OnRetryEnqueued,
+		onRetryDequeued:      conf.OnRetryDequeued,
+		warmUpClient:         redirectClient(client, RedirectNone, signer),
+		warmUpConnections:    conf.WarmUpConnections,
+		warmedUp:             make(chan struct{}),
+		flushRetriesCh:       make(chan struct{}, 1),
+		rescheduleRetriesCh:  make(chan struct{}, 1),
+		pathEscaper:          conf.PathEscaper,
+		maxResponseBodySize:  int64(conf.MaxResponseBodySize),
+		retryNotFound:        conf.RetryCorrelateNotFound,
+		maxEntriesStatus:     maxEntriesStatusCode(conf.Config),
+		emitter:              conf.Emitter,
+		eventEmitter:         conf.EventEmitter,
+		emitInterval:         conf.EmitInterval,
+		ttlFallback:          conf.TTLFallback,
+		expiries:             newExpiries(),
+		signer:               signer,
+	}
+	// requests can be queued on each of the channels as well as in flight
+	cc.registry = newRegistry(4 * int(conf.MaxBuffered))
+	if cc.emitInterval <= 0 {
+		cc.emitInterval = defaultEmitInterval
+	}
+	if cc.enqueueRetryDelay <= 0 {
+		cc.enqueueRetryDelay = defaultEnqueueRetryDelay
+	}
+	if conf.MinRetryInterval > 0 {
+		cc.retryLimiter = newRetryLimiter(conf.MinRetryInterval)
+	}
+	if conf.NegativeCacheTTL > 0 {
+		cc.negativeCache = newNegativeCache(conf.NegativeCacheTTL)
+	}
+	if conf.MaxRetriesInFlight > 0 {
+		cc.retrySlots = make(chan struct{}, conf.MaxRetriesInFlight)
+	}
+	if conf.CoalesceCorrelates {
+		cc.coalescer = newCoalescer()
+	}
+	cc.observer = Observer(NopObserver{})
+	if conf.Observer != nil {
+		cc.observer = &recoveringObserver{cc: cc, observer: conf.Observer}
+	}
+	if conf.ResultsBuffered > 0 {
+		cc.results = make(chan RequestOutcome, conf.ResultsBuffered)
+	}
+	if conf.OverflowBuffered > 0 {
+		cc.overflowChan = make(chan *request, conf.OverflowBuffered)
+	}
+	if conf.SerializeByDimension {
+		cc.dimensionQueues = newDimensionQueues()
+	}
+	if conf.ShedThreshold > 0 {
+		cc.shedder = newShedder(conf.ShedThreshold, conf.Pressure)
+	}
+	if cc.ttlFallback == "" {
+		cc.ttlFallback = TTLFallbackDelete
+	}
+	if conf.OnStalled != nil {
+		cc.watchdog = newWatchdog(conf.StallWindow, func() {
+			cc.invokeCallback(nil, 0, conf.OnStalled)
+		})
+	}
+	if conf.OnMaxEntriesResolved != nil {
+		cc.onMaxEntriesResolved = conf.OnMaxEntriesResolved
+		cc.cappedDimensions = newCappedDimensions()
+	}
+	if conf.MaxGetRequests > 0 {
+		cc.getSlots = make(chan struct{}, conf.MaxGetRequests)
+	}
+	if conf.OnHealthChange != nil {
+		cc.health = newHealthTracker(func(healthy bool, reason string) {
+			cc.invokeCallback(nil, 0, func() { conf.OnHealthChange(healthy, reason) })
+		}, conf.HealthFailureThreshold, conf.HealthDebounce)
+	}
+	if conf.MaxTrackedDimensions > 0 {
+		cc.dimensionCounts = newKeyCounter(int(conf.MaxTrackedDimensions))
+	}
+	if conf.TraceConnections {
+		cc.connTrace = &httptrace.ClientTrace{GotConn: cc.recordConn}
+	}
+	return cc, nil
 }
 
+// recordConn records whether a request reused an idle connection or dialed a new one
+func (cc *Client) recordConn(info httptrace.GotConnInfo) {
+	if info.Reused {
+		atomic.AddInt64(&cc.TotalConnReused, int64(1))
+	} else {
+		atomic.AddInt64(&cc.TotalConnNew, int64(1))
+	}
+}
+
+// randomJitter returns a random duration in the range [0, max)
+func randomJitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max))) // nolint: gosec
+}
+
+// putRequestOnChan queues the request to be sent.  A request that is rejected rather than queued
+// has its callback invoked with a DroppedError as well as the error being returned, so that
+// callers are told about every drop the same way whether or not it happens while queuing.
 func (cc *Client) putRequestOnChan(r *request) error {
+	err := cc.enqueue(r)
+	if err != nil {
+		cc.completeFailed(r, nil, 0, nil, droppedErrorFor(err))
+	}
+	return err
+}
+
+// enqueue puts the request on its request channel, or returns the reason it was rejected
+func (cc *Client) enqueue(r *request) error {
 	// prevent requests against empty dimension names and values
 	if r.DimName == "" || r.DimValue == "" {
 		// logging this as debug because this means there's no actual dimension to correlate with
 		// and because this isn't being taken off on the request sender and subject to retries, this could
 		// potentially spam the logs
 		atomic.AddInt64(&cc.TotalInvalidDimensions, int64(1))
-		r.Logger(cc.log).WithFields(log.Fields{"method": r.operation}).Debug("No dimension key or value to correlate to")
+		cc.recordDrop(r, DropCauseInvalidDimension)
+		r.Logger(cc.log).WithFields(log.Fields{"method": r.operation.Method()}).Debug("No dimension key or value to correlate to")
+		cc.completeFailed(r, nil, 0, nil, errInvalidDimension)
 		return nil
 	}
 
+	// reject values that can't be safely encoded into the request endpoint
+	if err := r.Correlation.validate(); err != nil {
+		atomic.AddInt64(&cc.TotalInvalidValues, int64(1))
+		cc.recordDrop(r, DropCauseInvalidValue)
+		return err
+	}
+
+	// reject requests the server would refuse for their url length before they are queued
+	if err := cc.checkURLLength(r); err != nil {
+		atomic.AddInt64(&cc.TotalURLTooLong, int64(1))
+		cc.recordDrop(r, DropCauseURLTooLong)
+		return err
+	}
+
+	if !cc.dimensions.permits(r.DimName) {
+		cc.recordDrop(r, DropCauseFilteredDimension)
+		r.ThrottledLogger(cc.throttledLog).WithFields(log.Fields{"method": r.operation.Method()}).ThrottledDebug("Dropping correlation for a dimension that isn't allowed")
+		cc.completeFailed(r, nil, 0, nil, errFilteredDimension)
+		return nil
+	}
+
+	// gets aren't for a particular type so they aren't filtered
+	if r.operation != OperationGet && !cc.types.permits(r.Type) {
+		cc.recordDrop(r, DropCauseFilteredType)
+		r.ThrottledLogger(cc.throttledLog).WithFields(log.Fields{"method": r.operation.Method()}).ThrottledDebug("Dropping correlation with a filtered type")
+		cc.completeFailed(r, nil, 0, nil, errFilteredType)
+		return nil
+	}
+
+	// gets are made on request rather than in the background so only updates are shed
+	if r.operation != OperationGet && r.opts.Priority == PriorityNormal && cc.shedder.shouldShed() {
+		cc.recordDrop(r, DropCauseShed)
+		r.ThrottledLogger(cc.throttledLog).WithFields(log.Fields{"method": r.operation.Method()}).ThrottledWarn("Shedding correlation update because the agent is under pressure")
+		cc.completeFailed(r, nil, 0, nil, ErrShed)
+		return nil
+	}
+
+	if cc.pauser.rejecting() {
+		cc.recordDrop(r, DropCausePaused)
+		return ErrPaused
+	}
+
+	if cc.dimensionCounts != nil {
+		cc.dimensionCounts.increment(r.DimName)
+	}
+	cc.sources.countRequest(r.opts.Source)
+
 	r.ctx, r.cancel = context.WithCancel(requestcounter.ContextWithRequestCounter(context.Background()))
+	cc.registry.track(r)
+	cc.registry.begin(r)
+	r.enqueuedAt = cc.now()
+
+	requestChan := cc.requestChan
+	if r.opts.Priority == PriorityHigh {
+		requestChan = cc.highPriorityChan
+	}
+
+	if !cc.reserveQueuedBytes(r) {
+		cc.recordDropForErr(r, errMaxQueuedBytes)
+		r.cancel()
+		return errMaxQueuedBytes
+	}
+
+	// the request replaces the queued correlate before it is queued so that it can't be taken off
+	// the queue before it is tracked
+	var stale *request
+	if cc.coalescing(r) {
+		stale = cc.coalescer.replace(r)
+	}
 
 	var err error
 	select {
-	case cc.requestChan <- r:
+	case requestChan <- r:
 	case <-cc.ctx.Done():
-		err = context.DeadlineExceeded
+		err = errShutdown
 	default:
-		err = ErrChFull
+		if r.enqueueCtx != nil {
+			err = cc.putRequestBlocking(requestChan, r)
+			break
+		}
+		switch {
+		case requestChan == cc.requestChan && cc.putRequestOnOverflow(r):
+		case cc.dropOldest:
+			err = cc.putRequestEvictingOldest(requestChan, r)
+		case cc.requeue(requestChan, r):
+		default:
+			err = ErrChFull
+		}
+	}
+	if err == ErrChFull {
+		cc.health.recordFailure("request channel is full", cc.now())
+	}
+	if err == nil {
+		cc.registry.add(r)
+		cc.watchdog.recordEnqueue(cc.now())
+		cc.observer.Enqueued(r.Correlation, r.operation)
+		if stale != nil && cc.coalescer.cancel(stale) {
+			atomic.AddInt64(&cc.TotalCoalescedRequests, int64(1))
+			cc.observer.Deduplicated(stale.Correlation, stale.operation)
+			cc.completeFailed(stale, nil, 0, nil, errCoalesced)
+		}
+	} else {
+		cc.releaseQueuedBytes(r)
+		if cc.coalescing(r) {
+			cc.coalescer.restore(r, stale)
+		}
+		r.cancel()
 	}
+	cc.recordDropForErr(r, err)
 	return err
 }
 
-func (cc *Client) putRequestOnRetryChan(r *request) error {
+// putRequestOnOverflow puts the request on the overflow buffer if it is enabled and has room
+func (cc *Client) putRequestOnOverflow(r *request) bool {
+	select {
+	case cc.overflowChan <- r:
+		return true
+	default:
+		return false
+	}
+}
+
+// refillFromOverflow moves the oldest request on the overflow buffer to the request channel once
+// a request has been taken off it, so that the requests that overflowed are sent ahead of those
+// made after them.  If a new request took the freed slot first, the overflowed request is returned
+// to be sent next instead.  It is only used by processChan.
+func (cc *Client) refillFromOverflow() *request {
+	select {
+	case r := <-cc.overflowChan:
+		select {
+		case cc.requestChan <- r:
+			return nil
+		default:
+			return r
+		}
+	default:
+		return nil
+	}
+}
+
+// putRequestEvictingOldest makes room for the request by cancelling the oldest request queued on
+// the channel
+func (cc *Client) putRequestEvictingOldest(requestChan chan *request, r *request) error {
+	select {
+	case oldest := <-requestChan:
+		cc.releaseQueuedBytes(oldest)
+		if cc.coalescing(oldest) {
+			cc.coalescer.take(oldest)
+		}
+		cc.completeFailed(oldest, nil, 0, nil, errEvicted)
+		oldest.cancel()
+		atomic.AddInt64(&cc.TotalEvictedRequests, int64(1))
+		cc.recordDrop(oldest, DropCauseEvicted)
+	default:
+	}
+
+	// another caller may have filled the slot in the meantime
+	select {
+	case requestChan <- r:
+		return nil
+	default:
+		return ErrChFull
+	}
+}
+
+// retryDequeued invokes the OnRetryDequeued hook for a request taken off the retry queue
+func (cc *Client) retryDequeued(r *request) {
+	if cc.onRetryDequeued != nil {
+		cc.invokeCallback(r.Correlation, r.operation, func() { cc.onRetryDequeued(r.Correlation, requestcounter.GetRequestCount(r.ctx)) })
+	}
+}
+
+// adjustRetryQueueLen updates the number of requests waiting to be retried and its moving average
+func (cc *Client) adjustRetryQueueLen(delta int64) {
+	cc.retryQueueEMA.update(float64(atomic.AddInt64(&cc.retryQueueLen, delta)))
+}
+
+// RetryQueueEMA returns the exponentially weighted moving average of the number of requests
+// waiting to be retried.  It is updated each time a request is added to or removed from the retry
+// queue, and is a steadier signal of sustained backpressure than the current length.
+func (cc *Client) RetryQueueEMA() float64 {
+	return cc.retryQueueEMA.get()
+}
+
+// putRequestOnRetryChan schedules the request to be retried after the given delay, or later if the
+// minimum retry interval requires it, and returns the delay it was scheduled with
+func (cc *Client) putRequestOnRetryChan(r *request, delay time.Duration) (scheduled time.Duration, err error) {
+	// the failure that led to the retry is reported as the request's outcome rather than the drop
+	defer func() {
+		if cause, ok := dropCauseForErr(err); ok {
+			cc.countDrop(r, cause)
+		}
+	}()
+
 	// handle request counter
-	if requestcounter.GetRequestCount(r.ctx) == cc.maxAttempts {
-		return errMaxAttempts
+	maxAttempts := cc.maxAttemptsFor(r.operation)
+	// maxAttempts may have been lowered by Reconfigure after the request was last attempted
+	if requestcounter.GetRequestCount(r.ctx) >= maxAttempts {
+		return 0, errMaxAttempts
 	}
 	requestcounter.IncrementRequestCount(r.ctx)
 
-	// set the time to retry
-	r.sendAt = cc.now().Add(cc.retryDelay)
+	// set the time to retry, no sooner than the minimum interval after the last retry of the
+	// correlation
+	r.retryFrom = cc.now()
+	r.setSendAt(r.retryFrom.Add(delay))
+	key := retryKeyFor(r.Correlation)
+	r.retryNotBefore = cc.retryLimiter.earliest(key, r.retryFrom)
+	if r.sendAt.Before(r.retryNotBefore) {
+		r.setSendAt(r.retryNotBefore)
+		delay = r.retryNotBefore.Sub(r.retryFrom)
+	}
 
 	if r.ctx.Err() != nil {
-		return errRequestCancelled
+		return 0, errRequestCancelled
+	}
+
+	if cc.exceedsBudget(r, delay) {
+		return 0, errBudgetExceeded
+	}
+
+	if !cc.reserveQueuedBytes(r) {
+		return 0, errMaxQueuedBytes
 	}
 
-	var err error
 	select {
 	case <-r.ctx.Done():
 		err = errRequestCancelled
 	case cc.retryChan <- r:
+		cc.retryLimiter.record(key, r.retryFrom.Add(delay), r.retryFrom)
+		cc.adjustRetryQueueLen(1)
+		if cc.onRetryEnqueued != nil {
+			cc.invokeCallback(r.Correlation, r.operation, func() { cc.onRetryEnqueued(r.Correlation, requestcounter.GetRequestCount(r.ctx)) })
+		}
 	case <-cc.ctx.Done():
-		err = context.DeadlineExceeded
+		err = errShutdown
 	default:
 		err = errRetryChFull
 	}
 
-	return err
+	if err != nil {
+		cc.releaseQueuedBytes(r)
+		return 0, err
+	}
+	return delay, nil
+}
+
+// invokeCallback invokes a user supplied callback and recovers from any panic it raises so that a
+// misbehaving callback can't take down the routine that invoked it
+func (cc *Client) invokeCallback(cor *Correlation, op Operation, cb func()) {
+	defer func() {
+		if p := recover(); p != nil {
+			atomic.AddInt64(&cc.TotalCallbackPanics, int64(1))
+			logger := cc.log
+			if cor != nil {
+				logger = cor.Logger(cc.log)
+			}
+			logger.WithFields(log.Fields{"method": op.Method(), "panic": fmt.Sprint(p)}).Error("Recovered from panic in correlation callback")
+		}
+	}()
+	cb()
 }
 
 // CorrelateCB is a call back invoked with Correlate requests
-// it is not invoked if the reqeust is deduplicated, cancelled, or the client context is cancelled
+// it is not invoked if the reqeust is a duplicate of a pending request or the client context is
+// cancelled.  A request that was already sent according to the DedupStateFile invokes it with nil
+// without being sent again.  A request that is rejected, dropped, evicted, replaced by a newer
+// correlate or cancelled before it is sent invokes it with a DroppedError.
 type CorrelateCB func(cor *Correlation, err error)
 
-// Correlate
-func (cc *Client) Correlate(cor *Correlation, cb CorrelateCB) {
-	err := cc.putRequestOnChan(&request{
+// Correlate makes the correlation.  It cancels the delete scheduled when the correlation was made
+// with CorrelateWithTTL, so that it no longer expires.
+func (cc *Client) Correlate(cor *Correlation, cb CorrelateCB, opts ...RequestOptions) {
+	cc.expiries.cancel(*cor)
+	o := mergeRequestOptions(opts)
+	err := cc.putRequestOnChan(cc.correlateRequest(cor, cb, o))
+	if err != nil {
+		withSource(cor.Logger(cc.log), o.Source).WithError(err).WithFields(log.Fields{"method": http.MethodPut}).Debug("Unable to update dimension, not retrying")
+	}
+}
+
+// correlateRequest returns a request to make the correlation that invokes the callback with the
+// outcome
+func (cc *Client) correlateRequest(cor *Correlation, cb CorrelateCB, o RequestOptions) *request {
+	return &request{
 		Correlation: cor,
-		operation:   http.MethodPut,
-		callback: func(body []byte, statuscode int, err error) {
-			switch statuscode {
-			case http.StatusOK:
-				if cc.logUpdates {
-					cor.Logger(cc.log).WithFields(log.Fields{"method": http.MethodPut}).Info("Updated dimension")
+		operation:   OperationCorrelate,
+		opts:        o,
+		callback: func(body []byte, statuscode int, _ http.Header, err error) {
+			switch {
+			case isResponseTooLarge(err):
+				// the body wasn't read, so there is nothing to parse
+			case requests.IsSuccessStatus(statuscode):
+				cc.InvalidateNegativeCache(cor.DimName, cor.DimValue)
+				cc.sentDedup.record(OperationCorrelate, cor, cc.now())
+				if cc.shouldLogUpdates() {
+					withSource(cor.Logger(cc.log), o.Source).WithFields(log.Fields{"method": http.MethodPut}).Info("Updated dimension")
 				}
-			case http.StatusTeapot:
+				if cc.cappedDimensions.resolve(cor) {
+					cc.invokeCallback(cor, OperationCorrelate, func() { cc.onMaxEntriesResolved(cor) })
+				}
+			case statuscode == cc.maxEntriesStatus:
 				max := &ErrMaxEntries{}
+				if statuscode != http.StatusTeapot {
+					max.status = statuscode
+				}
 				err = json.Unmarshal(body, max)
 				if err == nil {
 					err = max
+					cc.cappedDimensions.add(cor)
+					if cc.onMaxEntries != nil {
+						cc.invokeCallback(cor, OperationCorrelate, func() { cc.onMaxEntries(cor, max.MaxEntries) })
+					}
 				}
 			}
-			if err != nil {
-				cor.Logger(cc.log).WithError(err).WithFields(log.Fields{"method": http.MethodPut}).Error("Unable to update dimension, not retrying")
+			switch {
+			case isDropped(err):
+				// drops are counted and logged where they happen
+				withSource(cor.Logger(cc.log), o.Source).WithError(err).WithFields(log.Fields{"method": http.MethodPut}).Debug("Unable to update dimension, not retrying")
+			case err != nil:
+				withSource(cor.Logger(cc.log), o.Source).WithError(err).WithFields(log.Fields{"method": http.MethodPut}).Error("Unable to update dimension, not retrying")
 			}
-			cb(cor, err)
-		}})
-	if err != nil {
-		cor.Logger(cc.log).WithError(err).WithFields(log.Fields{"method": http.MethodPut}).Debug("Unable to update dimension, not retrying")
-	}
+			cc.invokeCallback(cor, OperationCorrelate, func() { cb(cor, err) })
+		}}
 }
 
-// SuccessfulDeleteCB is a call back that is only invoked on successful Deletion operations
+// SuccessfulDeleteCB is a call back that is only invoked on successful Deletion operations,
+// including a delete that was already sent according to the DedupStateFile
 type SuccessfulDeleteCB func(cor *Correlation)
 
 // Delete removes a correlation
-func (cc *Client) Delete(cor *Correlation, callback SuccessfulDeleteCB) {
-	err := cc.putRequestOnChan(&request{
+func (cc *Client) Delete(cor *Correlation, callback SuccessfulDeleteCB, opts ...RequestOptions) {
+	cc.delete(cor, callback, nil, opts)
+}
+
+// delete enqueues a request to remove a correlation.  If result is not nil, it is invoked exactly once
+// with the outcome of the request: nil on success, otherwise the error the request failed with or the
+// reason it was dropped or cancelled before completing.
+func (cc *Client) delete(cor *Correlation, callback SuccessfulDeleteCB, result func(error), opts []RequestOptions) {
+	cc.expiries.cancel(*cor)
+	var once sync.Once
+	complete := func(err error) {
+		if result != nil {
+			once.Do(func() { result(err) })
+		}
+	}
+
+	o := mergeRequestOptions(opts)
+	r := &request{
 		Correlation: cor,
-		operation:   http.MethodDelete,
-		callback: func(_ []byte, statuscode int, err error) {
-			switch statuscode {
-			case http.StatusOK:
-				callback(cor)
-				if cc.logUpdates {
-					cor.Logger(cc.log).WithFields(log.Fields{"method": http.MethodDelete}).Info("Updated dimension")
+		operation:   OperationDelete,
+		opts:        o,
+		callback: func(_ []byte, statuscode int, _ http.Header, err error) {
+			defer complete(err)
+			switch {
+			case err == nil:
+				cc.sentDedup.record(OperationDelete, cor, cc.now())
+				cc.invokeCallback(cor, OperationDelete, func() { callback(cor) })
+				if cc.shouldLogUpdates() {
+					withSource(cor.Logger(cc.log), o.Source).WithFields(log.Fields{"method": http.MethodDelete}).Info("Updated dimension")
 				}
+			case isDropped(err):
+				// drops are counted by their cause rather than as failed deletes
+				withSource(cor.Logger(cc.log), o.Source).WithError(err).WithFields(log.Fields{"method": http.MethodDelete}).Debug("Unable to update dimension, not retrying")
 			default:
-				cc.log.WithError(err).Error("Unable to update dimension, not retrying")
+				atomic.AddInt64(&cc.TotalFailedDeletes, int64(1))
+				// throttled per correlation so that a failed delete doesn't hide those of other correlations
+				withSourceThrottled(cor.ThrottledLogger(cc.throttledLog), o.Source).WithThrottleKey(idempotencyKey(OperationDelete, cor)).WithError(err).WithFields(log.Fields{"method": http.MethodDelete, "statusCode": statuscode}).ThrottledError("Unable to update dimension, not retrying")
 			}
-		}})
+		}}
+	err := cc.putRequestOnChan(r)
 	if err != nil {
-		cor.Logger(cc.log).WithError(err).WithFields(log.Fields{"method": http.MethodDelete}).Debug("Unable to update dimension, not retrying")
+		r.Logger(cc.log).WithError(err).WithFields(log.Fields{"method": http.MethodDelete}).Debug("Unable to update dimension, not retrying")
+		complete(err)
+		return
+	}
+
+	if result == nil {
+		return
+	}
+	if r.ctx == nil {
+		// the request was dropped before it was queued and its callback has reported why
+		return
 	}
+	// requests that are deduplicated or cancelled never invoke their callback
+	go func() {
+		select {
+		case <-r.ctx.Done():
+			complete(cc.cancelledErr())
+		case <-cc.ctx.Done():
+			complete(errShutdown)
+		}
+	}()
+}
+
+// DeleteManyCB is a call back invoked once all of the deletes requested by DeleteMany have completed.
+// The results map each correlation to nil if it was deleted or to the error that prevented its deletion.
+type DeleteManyCB func(results map[*Correlation]error)
+
+// DeleteMany removes multiple correlations and invokes the callback once with the outcome of every
+// delete.  Each delete is retried independently like a regular Delete, so the callback is not invoked
+// until every delete has succeeded, exhausted its retries, been cancelled, or the client is shutdown.
+// If ctx is done first the callback is invoked right away with the deletes that are still
+// outstanding mapped to the context's error; those deletes carry on in the background.
+func (cc *Client) DeleteMany(ctx context.Context, cors []*Correlation, callback DeleteManyCB, opts ...RequestOptions) {
+	var (
+		lock     sync.Mutex
+		wg       sync.WaitGroup
+		results  = make(map[*Correlation]error, len(cors))
+		reported bool
+	)
+
+	wg.Add(len(cors))
+	for _, cor := range cors {
+		cor := cor
+		cc.delete(cor, func(*Correlation) {}, func(err error) {
+			lock.Lock()
+			// the results belong to the callback once they have been reported
+			if !reported {
+				results[cor] = err
+			}
+			lock.Unlock()
+			wg.Done()
+		}, opts)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+		lock.Lock()
+		reported = true
+		for _, cor := range cors {
+			if _, ok := results[cor]; !ok {
+				results[cor] = ctx.Err()
+			}
+		}
+		lock.Unlock()
+		cc.invokeCallback(nil, OperationDelete, func() { callback(results) })
+	}()
 }
 
 // SuccessfulGetCB
 type SuccessfulGetCB func(map[string][]string)
 
-// Get
+// GetResult is the outcome of a GetDetailed request.  A request that completes has one of three
+// outcomes:
+//   - found with data: Found is true and Correlations has the dimension's correlations
+//   - found empty: Found is true and Correlations is empty but not nil, the dimension exists but
+//     has no correlations
+//   - not found: Found is false, StatusCode is 404 and Err is set
+//
+// Any other failure leaves Found false with Err set.
+type GetResult struct {
+	// Correlations for the dimension, nil unless the request succeeded
+	Correlations map[string][]string
+	// Found is true if the correlations were fetched, even if there are none
+	Found      bool
+	StatusCode int
+	// Header is the response header, nil if no response was received
+	Header http.Header
+	Err    error
+}
+
+// GetDetailedCB is a call back invoked with the outcome of GetDetailed requests
+// it is not invoked if the client context is cancelled.  A request that is dropped, evicted or
+// cancelled before it is sent invokes it with a DroppedError in Err.
+type GetDetailedCB func(result GetResult)
+
+// Get retrieves the correlations for a dimension.  The callback is only invoked on success.
 func (cc *Client) Get(dimName string, dimValue string, callback SuccessfulGetCB) {
-	err := cc.putRequestOnChan(&request{
-		Correlation: &Correlation{
-			DimName:  dimName,
-			DimValue: dimValue,
-		},
-		operation: http.MethodGet,
-		callback: func(body []byte, statuscode int, err error) {
-			switch statuscode {
-			case http.StatusOK:
+	cc.GetDetailed(dimName, dimValue, func(result GetResult) {
+		if result.Err == nil {
+			callback(result.Correlations)
+		}
+	})
+}
+
+// GetDetailed retrieves the correlations for a dimension.  Unlike Get, the callback is also invoked
+// when the request fails and it receives the response status code and header.
+func (cc *Client) GetDetailed(dimName string, dimValue string, callback GetDetailedCB) {
+	if err := cc.getDetailed(dimName, dimValue, callback); err != nil {
+		cc.log.WithError(err).WithFields(log.Fields{"dimensionName": dimName, "dimensionValue": dimValue}).Debug("Unable to retrieve correlations for dimension, not retrying")
+	}
+}
+
+// GetSync retrieves the correlations for a dimension and waits for the result.  A dimension that
+// isn't found has no correlations, so an empty map is returned for it rather than an error.  If
+// the request can't be queued the error is returned immediately, e.g. ErrChFull when the request
+// channel is full.  If ctx is done before the result arrives its error is returned; the request
+// itself is not cancelled.
+func (cc *Client) GetSync(ctx context.Context, dimName string, dimValue string) (map[string][]string, error) {
+	// reject gets that can't be made before they are queued
+	if dimName == "" || dimValue == "" {
+		atomic.AddInt64(&cc.TotalInvalidDimensions, int64(1))
+		cc.recordDrop(&request{Correlation: &Correlation{DimName: dimName, DimValue: dimValue}, operation: OperationGet}, DropCauseInvalidDimension)
+		return nil, errInvalidDimension
+	}
+	if !cc.dimensions.permits(dimName) {
+		cc.recordDrop(&request{Correlation: &Correlation{DimName: dimName, DimValue: dimValue}, operation: OperationGet}, DropCauseFilteredDimension)
+		return nil, errFilteredDimension
+	}
+
+	results := make(chan GetResult, 1)
+	if err := cc.getDetailed(dimName, dimValue, func(result GetResult) {
+		results <- result
+	}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case result := <-results:
+		if result.StatusCode == http.StatusNotFound {
+			return map[string][]string{}, nil
+		}
+		return result.Correlations, result.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-cc.ctx.Done():
+		return nil, errShutdown
+	}
+}
+
+// getDetailed queues a Get request and returns the error if it couldn't be queued
+func (cc *Client) getDetailed(dimName string, dimValue string, callback GetDetailedCB) error {
+	cor := &Correlation{
+		DimName:  dimName,
+		DimValue: dimValue,
+	}
+	if cc.answerFromNegativeCache(cor, callback) {
+		return nil
+	}
+	return cc.putRequestOnChan(&request{
+		Correlation: cor,
+		operation:   OperationGet,
+		callback: func(body []byte, statuscode int, header http.Header, err error) {
+			result := GetResult{StatusCode: statuscode, Header: header, Err: err}
+			switch {
+			case err == nil && requests.IsSuccessStatus(statuscode):
 				var response = map[string][]string{}
-				err = json.Unmarshal(body, &response)
-				if err != nil {
-					cc.log.WithError(err).WithFields(log.Fields{"dim": dimName, "value": dimValue}).Error("Unable to unmarshall correlations for dimension")
-					return
+				body, result.Err = cc.decompress(body, header)
+				// a response without content, e.g. a 204, has no correlations
+				if result.Err == nil && len(body) > 0 {
+					result.Err = json.Unmarshal(body, &response)
+				}
+				if result.Err != nil {
+					cc.log.WithError(result.Err).WithFields(log.Fields{"dim": dimName, "value": dimValue}).Error("Unable to unmarshall correlations for dimension")
+				} else {
+					result.Correlations = response
+					result.Found = true
+				}
+			case isDropped(err):
+				cc.log.WithError(err).WithFields(log.Fields{"dimensionName": dimName, "dimensionValue": dimValue}).Debug("Unable to retrieve correlations for dimension, not retrying")
+			case statuscode == http.StatusNotFound:
+				if cc.negativeCache != nil {
+					cc.negativeCache.add(dimensionKey{name: dimName, value: dimValue}, cc.now())
 				}
-				callback(response)
-			case http.StatusNotFound:
 				// only log this as debug because we do a blanket fetch of correlations on the backend
 				// and if the backend fails to find anything this isn't really an error for us
 				cc.log.WithError(err).Debug("Unable to update dimension, not retrying")
 			default:
 				cc.log.WithError(err).Error("Unable to update dimension, not retrying")
 			}
+			cc.invokeCallback(cor, OperationGet, func() { callback(result) })
 		},
 	})
-	if err != nil {
-		cc.log.WithError(err).WithFields(log.Fields{"dimensionName": dimName, "dimensionValue": dimValue}).Debug("Unable to retrieve correlations for dimension, not retrying")
-	}
 }
 
 func (cc *Client) makeRequest(r *request) {
@@ -268,121 +1440,555 @@ func (cc *Client) makeRequest(r *request) {
 		err error
 	)
 
+	// the age includes the time spent queued and waiting to be retried
+	cc.requestAge.observe(cc.now().Sub(r.enqueuedAt))
+
+	// don't attempt a request whose budget ran out while it was queued
+	timeout, limited := cc.attemptTimeout(r)
+	if limited && timeout <= 0 {
+		cc.recordDrop(r, DropCauseBudgetExceeded)
+		cc.recordResult(r.operation, ResultFailure)
+		cc.sources.countFailure(r.opts.Source)
+		cc.releaseRetrySlot(r)
+		cc.observer.Failed(r.Correlation, r.operation, 0, errBudgetExceeded)
+		cc.completeFailed(r, nil, 0, nil, errBudgetExceeded)
+		r.cancel()
+		return
+	}
+
 	// build endpoint url
-	endpoint := fmt.Sprintf("%s/v2/apm/correlate/%s/%s", cc.APIURL, url.PathEscape(r.DimName), url.PathEscape(r.DimValue))
+	endpoint := cc.endpoint(r)
 
 	switch r.operation {
-	case http.MethodGet:
-		req, err = http.NewRequest(r.operation, endpoint, nil)
-	case http.MethodPut:
-		// TODO: pool the reader
-		endpoint = fmt.Sprintf("%s/%s", endpoint, r.Type)
-		req, err = http.NewRequest(r.operation, endpoint, strings.NewReader(r.Value))
-		req.Header.Add("Content-Type", "text/plain")
-	case http.MethodDelete:
-		endpoint = fmt.Sprintf("%s/%s/%s", endpoint, r.Type, url.PathEscape(r.Value))
-		req, err = http.NewRequest(r.operation, endpoint, nil)
+	case OperationGet:
+		req, err = http.NewRequest(r.operation.Method(), endpoint, nil)
+		// ask for compression explicitly rather than leaving it to the transport so that the
+		// response is left compressed and the bytes saved can be counted
+		if err == nil {
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+	case OperationCorrelate:
+		r.body = cc.bodies.get(r.Value)
+		req, err = http.NewRequest(r.operation.Method(), endpoint, r.body)
+		req.Header.Add("Content-Type", cc.putContentType)
+	case OperationDelete:
+		req, err = http.NewRequest(r.operation.Method(), endpoint, nil)
 	default:
-		err = fmt.Errorf("unknown operation")
+		err = fmt.Errorf("unknown operation %d", r.operation)
+	}
+
+	if err == nil {
+		// the attempt is aborted if the client is stopped, even if it is waiting for a sender
+		req = req.WithContext(cc.ctx)
+		if r.opts.TTL > 0 && cc.ttlHeader != "" {
+			req.Header.Add(cc.ttlHeader, strconv.FormatInt(int64(r.opts.TTL/time.Second), 10))
+		}
+		// sign every attempt so that anything time sensitive in the signature is fresh
+		err = cc.signer.Sign(req)
 	}
 
 	if err != nil {
 		// logging this as debug because this means there's something fundamentally wrong with the request
 		// and because this isn't being taken off on the request sender and subject to retries, this could
 		// potentially spam the logs long term.  This would be a really good candidate for a throttled error logger
-		r.Correlation.Logger(cc.log).WithError(err).WithFields(log.Fields{"method": r.operation}).Debug("Unable to make request, not retrying")
+		r.Logger(cc.log).WithError(err).WithFields(log.Fields{"method": r.operation.Method()}).Debug("Unable to make request, not retrying")
+		cc.recordDrop(r, DropCauseInvalidRequest)
+		cc.releaseBody(r)
+		cc.releaseRetrySlot(r)
+		cc.completeFailed(r, nil, 0, nil, &DroppedError{Cause: DropCauseInvalidRequest, msg: "unable to make request", err: err})
 		r.cancel()
 		return
 	}
 
-	req.Header.Add("X-SF-TOKEN", cc.Token)
-
-	req = req.WithContext(
-		context.WithValue(req.Context(), requests.RequestFailedCallbackKey, requests.RequestFailedCallback(func(body []byte, statusCode int, err error) {
-			// retry if the http status code is not 4XX. A 4xx or http client error implies
-			// an error that is not going to be remedied by retrying.
-			if statusCode < 400 || statusCode >= 500 {
-				// The retry (for non 400 errors) is meant to provide some measure of robustness against
-				// temporary API failures.  If the API is down for significant
-				// periods of time, correlation updates will probably eventually back
-				// up beyond conf.MaxBuffered and start dropping.
-				retryErr := cc.putRequestOnRetryChan(r)
-				if retryErr == nil {
-					r.Correlation.Logger(cc.log).WithError(err).WithFields(log.Fields{"method": req.Method}).Debug("Unable to update dimension, retrying")
-					return
-				}
+	if cc.shouldDebugLogRequests() {
+		// the endpoint never contains the token, so it is logged as is
+		cc.throttledLog.ThrottledDebug(fmt.Sprintf("Sending correlation request %s %s", req.Method, endpoint))
+	}
+	cc.countRequestBytes(req)
+
+	// limit the attempt to the attempt timeout and what is left of the budget
+	cancelAttempt := context.CancelFunc(func() {})
+	if limited {
+		var ctx context.Context
+		ctx, cancelAttempt = context.WithTimeout(req.Context(), timeout)
+		req = req.WithContext(ctx)
+	}
+
+	if cc.connTrace != nil {
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), cc.connTrace))
+	}
+
+	if cc.maxResponseBodySize > 0 {
+		req = req.WithContext(context.WithValue(req.Context(), requests.ResponseBodyLimitKey, cc.maxResponseBodySize))
+	}
+
+	start := cc.now()
+	var onSuccess requests.RequestSuccessHeaderCallback
+	onFailure := requests.RequestFailedHeaderCallback(func(body []byte, statusCode int, header http.Header, err error) {
+		// deletes are idempotent, so a retried delete that finds nothing to delete most likely
+		// failed after an earlier attempt deleted the correlation
+		if r.operation == OperationDelete && statusCode == http.StatusNotFound && requestcounter.GetRequestCount(r.ctx) > 0 {
+			r.Logger(cc.log).WithFields(log.Fields{"method": req.Method}).Debug("Retried delete found nothing to delete, assuming an earlier attempt succeeded")
+			onSuccess(body, statusCode, header)
+			return
+		}
+		cancelAttempt()
+		// counted before a retry is scheduled, which counts the retry as an attempt
+		attempts := attemptsMade(r) + 1
+		cc.countResponseBytes(body)
+		cc.recordLatency(start, statusCode, err)
+		cc.releaseSlot(r)
+		cc.releaseRetrySlot(r)
+		cc.releaseBody(r)
+		switch {
+		case statusCode >= 500:
+			cc.health.recordFailure(fmt.Sprintf("correlation endpoint responded with status code %d", statusCode), cc.now())
+		case statusCode == 0:
+			cc.health.recordFailure("unable to reach the correlation endpoint", cc.now())
+		}
+
+		// retry if the http status code is not 3XX or 4XX. A 4xx or http client error implies
+		// an error that is not going to be remedied by retrying.  A 3xx is returned when redirects
+		// aren't followed or when a redirect couldn't be followed.
+		var retryErr error
+		if isResponseTooLarge(err) {
+			cc.throttledLog.WithError(err).ThrottledError("Correlation endpoint responded with a body that is too large, not retrying")
+		} else if statusCode >= 300 && statusCode < 400 {
+			logger := cc.throttledLog.WithFields(log.Fields{"statusCode": statusCode, "location": header.Get("Location")})
+			if cc.redirectPolicy == RedirectNone {
+				logger.ThrottledError("Correlation endpoint redirected the request but redirects are not followed, check the api url")
 			} else {
-				atomic.AddInt64(&cc.TotalClientError4xxResponses, int64(1))
+				logger.ThrottledError("Correlation endpoint redirected the request but the redirect couldn't be followed, check the api url")
+			}
+		} else if cc.shouldRetry(r.operation, statusCode) {
+			// The retry (for non 400 errors) is meant to provide some measure of robustness against
+			// temporary API failures.  If the API is down for significant
+			// periods of time, correlation updates will probably eventually back
+			// up beyond conf.MaxBuffered and start dropping.
+			r.retryClass, r.retryHinted = errorClassOther, false
+			delay := cc.retryDelayFor(r)
+			if statusCode == 0 {
+				r.retryClass = classifyError(err)
+				cc.recordErrorClass(r.retryClass)
+				delay = time.Duration(float64(delay) * cc.backoffMultiplier(r.retryClass))
+			}
+			// honor the server's hint about when to retry if it gave one
+			if retryAfter, ok := parseRetryAfter(header, cc.now()); ok && statusCode >= 500 {
+				delay = retryAfter
+				r.retryHinted = true
 			}
+			delay, retryErr = cc.putRequestOnRetryChan(r, delay)
+			if retryErr == nil {
+				cc.logRetry(r, err)
+				cc.recordResult(r.operation, ResultRetry)
+				cc.observer.RetryScheduled(r.Correlation, r.operation, delay)
+				return
+			}
+		} else {
+			atomic.AddInt64(&cc.TotalClientError4xxResponses, int64(1))
+		}
 
-			// invoke the callback
-			r.callback(body, statusCode, err)
+		cc.recordResult(r.operation, failureResult(statusCode))
+		cc.sources.countFailure(r.opts.Source)
+		cc.logRetriedOutcome(r, err)
+		cc.observer.Failed(r.Correlation, r.operation, statusCode, err)
+		reqErr := cc.requestError(r.operation, statusCode, err)
+		// invoke the callback
+		cc.completeFailed(r, body, statusCode, header, reqErr)
+		cc.emitOutcome(r, statusCode, reqErr, attempts)
+		cc.emitFailureEvent(r, statusCode, err, retryErr, attempts)
 
-			// cancel the request context
-			r.cancel()
-		})))
+		// cancel the request context
+		r.cancel()
+	})
 
-	req = req.WithContext(
-		context.WithValue(req.Context(), requests.RequestSuccessCallbackKey, requests.RequestSuccessCallback(func(body []byte) {
-			r.callback(body, http.StatusOK, nil)
-			// close the request context
-			r.cancel()
-		})))
+	onSuccess = requests.RequestSuccessHeaderCallback(func(body []byte, statusCode int, header http.Header) {
+		cancelAttempt()
+		cc.countResponseBytes(body)
+		cc.recordLatency(start, statusCode, nil)
+		cc.releaseSlot(r)
+		cc.releaseRetrySlot(r)
+		cc.releaseBody(r)
+		cc.health.recordSuccess(cc.now())
+		cc.watchdog.recordSuccess()
+		cc.recordResult(r.operation, ResultSuccess)
+		cc.recordRetrySuccess(r)
+		cc.logRetriedOutcome(r, nil)
+		cc.observer.Succeeded(r.Correlation, r.operation, statusCode)
+		r.complete(body, statusCode, header, nil)
+		cc.emitOutcome(r, statusCode, nil, attemptsMade(r)+1)
+		// close the request context
+		r.cancel()
+	})
 
-	// This will block if we don't have enough requests
+	cc.observer.Sending(r.Correlation, r.operation, requestcounter.GetRequestCount(r.ctx))
+	if cc.hedger != nil && r.operation == OperationGet {
+		cc.sendHedged(r, req, onFailure, onSuccess)
+		return
+	}
+
+	cc.send(r, cc.withCallbacks(req, onFailure, onSuccess))
+}
+
+// withCallbacks returns the request with the callbacks the request sender invokes once it
+// completes, which it succeeds with on any 2xx status.  The callbacks stop counting the request as
+// in flight, even if they panic.
+func (cc *Client) withCallbacks(req *http.Request, onFailure requests.RequestFailedHeaderCallback, onSuccess requests.RequestSuccessHeaderCallback) *http.Request {
+	failed := requests.RequestFailedHeaderCallback(func(body []byte, statusCode int, header http.Header, err error) {
+		defer atomic.AddInt64(&cc.inFlight, -1)
+		onFailure(body, statusCode, header, err)
+	})
+	succeeded := requests.RequestSuccessHeaderCallback(func(body []byte, statusCode int, header http.Header) {
+		defer atomic.AddInt64(&cc.inFlight, -1)
+		onSuccess(body, statusCode, header)
+	})
+	ctx := context.WithValue(req.Context(), requests.RequestFailedHeaderCallbackKey, failed)
+	ctx = context.WithValue(ctx, requests.AnySuccessStatusKey, true)
+	return req.WithContext(context.WithValue(ctx, requests.RequestSuccessHeaderCallbackKey, succeeded))
+}
+
+// sendInFlight hands the request to the request sender, counting it as in flight until one of
+// its callbacks is invoked
+func (cc *Client) sendInFlight(req *http.Request) {
+	atomic.AddInt64(&cc.inFlight, 1)
 	cc.requestSender.Send(req)
 }
 
+// InFlight returns the number of requests that have been handed to the request sender and haven't
+// completed yet.  Compared to MaxRequests, it shows how saturated the senders are.
+func (cc *Client) InFlight() int64 {
+	return atomic.LoadInt64(&cc.inFlight)
+}
+
+// send sends the http request for the request once a slot is available for it
+func (cc *Client) send(r *request, req *http.Request) {
+	if !cc.acquireSlot(r) {
+		// wait for a slot in the background so that updates aren't held up behind the request
+		go func() {
+			select {
+			case cc.getSlots <- struct{}{}:
+				cc.sendInFlight(req)
+			case <-r.ctx.Done():
+				cc.releaseRetrySlot(r)
+				cc.completeFailed(r, nil, 0, nil, cc.cancelledErr())
+			case <-cc.ctx.Done():
+				cc.releaseRetrySlot(r)
+				cc.completeFailed(r, nil, 0, nil, errShutdown)
+			}
+		}()
+		return
+	}
+
+	// This will block if we don't have enough requests
+	cc.sendInFlight(req)
+}
+
+// acquireSlot claims a slot for the request if its operation has a concurrency limit.  It returns
+// false if the limit has been reached.
+func (cc *Client) acquireSlot(r *request) bool {
+	if r.operation != OperationGet || cc.getSlots == nil {
+		return true
+	}
+	select {
+	case cc.getSlots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// releaseBody returns the request's body buffer to the pool once the request has completed
+func (cc *Client) releaseBody(r *request) {
+	if r.body != nil {
+		cc.bodies.put(r.body)
+		r.body = nil
+	}
+}
+
+// releaseSlot releases the slot claimed for the request by acquireSlot
+func (cc *Client) releaseSlot(r *request) {
+	if r.operation != OperationGet || cc.getSlots == nil {
+		return
+	}
+	<-cc.getSlots
+}
+
 // routines
+// waitForStartup blocks for the given startup delay.  It returns false if the client
+// is shutdown before the delay elapses.
+func (cc *Client) waitForStartup(delay time.Duration) bool {
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-cc.ctx.Done():
+		return false
+	}
+}
+
 // processChan processes incoming requests, drops duplicates, and cancels conflicting requests
-func (cc *Client) processChan() {
+func (cc *Client) processChan(startupDelay time.Duration) {
 	defer cc.wg.Done()
+	if !cc.waitForStartup(startupDelay) {
+		return
+	}
 	purgeDeduper := time.NewTimer(cc.dedupCleanupInterval)
 	defer purgeDeduper.Stop()
+	releaseHeld := time.NewTimer(0)
+	defer releaseHeld.Stop()
+	// waiting is the oldest normal priority request, taken off its channel to check its age when
+	// priority aging is enabled or because it couldn't be moved from the overflow
+	var waiting *request
+	defer func() {
+		if waiting != nil {
+			cc.failQueued(waiting)
+		}
+	}()
 	for {
+		if !cc.waitWhilePaused() {
+			return
+		}
+
+		if cc.priorityAging > 0 && waiting == nil {
+			waiting = cc.takeWaiting()
+		}
+		if waiting != nil && cc.aged(waiting) {
+			if len(cc.highPriorityChan) > 0 {
+				atomic.AddInt64(&cc.TotalAgedRequests, int64(1))
+			}
+			r := waiting
+			waiting = nil
+			cc.processRequest(r)
+			continue
+		}
+
+		// send any high priority requests before waiting on the other channels
+		select {
+		case r := <-cc.highPriorityChan:
+			cc.processRequest(r)
+			continue
+		default:
+		}
+
+		if waiting != nil {
+			r := waiting
+			waiting = nil
+			cc.processRequest(r)
+			continue
+		}
+
+		// only take from the overflow once the requests that were queued before it are sent
+		var overflowChan <-chan *request
+		if len(cc.requestChan) == 0 {
+			overflowChan = cc.overflowChan
+		}
+
+		var heldDue <-chan time.Time
+		if next := cc.heldDeletes.peek(); next != nil {
+			if !releaseHeld.Stop() {
+				select {
+				case <-releaseHeld.C:
+				default:
+				}
+			}
+			releaseHeld.Reset(next.sendAt.Sub(cc.now()))
+			heldDue = releaseHeld.C
+		}
+
 		select {
 		case <-cc.ctx.Done():
 			return
 		case <-purgeDeduper.C:
 			cc.dedup.purge()
+			cc.registry.sweep()
 			purgeDeduper.Reset(cc.dedupCleanupInterval)
+		case <-heldDue:
+			cc.sendHeldDeletes()
+		case r := <-cc.highPriorityChan:
+			cc.processRequest(r)
 		case r := <-cc.requestChan:
-			if cc.dedup.isDup(r) {
-				r.cancel()
-				continue
-			}
-			cc.makeRequest(r)
+			waiting = cc.refillFromOverflow()
+			cc.processRequest(r)
+		case r := <-overflowChan:
+			cc.processRequest(r)
 		}
 	}
 }
 
-// processRetryChan is a routine that drains the retry channel and waits until the appropriate time to retry the request
-func (cc *Client) processRetryChan() {
+// processRequest sends a request taken off of a request channel unless it has been cancelled or is
+// a duplicate
+func (cc *Client) processRequest(r *request) {
+	// the client may have been paused while waiting for the request
+	if !cc.waitWhilePaused() {
+		return
+	}
+	cc.releaseQueuedBytes(r)
+	if cc.coalescing(r) {
+		cc.coalescer.take(r)
+	}
+	if r.ctx.Err() != nil {
+		cc.completeFailed(r, nil, 0, nil, cc.cancelledErr())
+		return
+	}
+	if cc.collapseWindow > 0 && cc.dedup.collapse(r) {
+		atomic.AddInt64(&cc.TotalCollapsedRequests, int64(1))
+		r.cancel()
+		cc.observer.Deduplicated(r.Correlation, r.operation)
+		return
+	}
+	dup := cc.dedup.isDup(r)
+	sent := !dup && cc.sentDedup.isDup(r, cc.now())
+	if dup || sent {
+		if sent {
+			// the request already took effect before the client was started, so it succeeded
+			r.complete(nil, 0, nil, nil)
+		}
+		r.cancel()
+		cc.observer.Deduplicated(r.Correlation, r.operation)
+		atomic.AddInt64(&cc.totalDedupSaved, int64(1))
+		atomic.AddInt64(&cc.totalDedupSavedBytes, int64(len(r.DimName)+len(r.DimValue)+len(r.Type)+len(r.Value)))
+		if cc.onDeduplicated != nil {
+			cc.invokeCallback(r.Correlation, r.operation, func() { cc.onDeduplicated(r.Correlation) })
+		}
+		return
+	}
+	if cc.collapseWindow > 0 && r.operation == OperationDelete {
+		r.held = true
+		r.setSendAt(cc.now().Add(cc.collapseWindow))
+		cc.heldDeletes.push(r)
+		return
+	}
+	cc.dispatch(r)
+}
+
+// sendHeldDeletes sends the held deletes whose collapse window has passed
+func (cc *Client) sendHeldDeletes() {
+	for r := cc.heldDeletes.popDue(cc.now()); r != nil; r = cc.heldDeletes.popDue(cc.now()) {
+		r.held = false
+		if r.ctx.Err() != nil {
+			cc.completeFailed(r, nil, 0, nil, cc.cancelledErr())
+			continue
+		}
+		cc.dispatch(r)
+	}
+}
+
+// processRetryChan is a routine that drains the retry channel into a queue ordered by send time and
+// resends each request once it is due.  Requests are resent in order of their send time regardless of
+// the order they were put on the retry channel.
+func (cc *Client) processRetryChan(startupDelay time.Duration) {
 	defer cc.wg.Done()
+	if !cc.waitForStartup(startupDelay) {
+		return
+	}
+
+	// the queue is bounded by the capacity of the retry channel so that draining the channel
+	// doesn't effectively double the number of retries that can be buffered
+	pending := &retryQueue{}
+	defer cc.failRetries(pending)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
 	for {
+		var retryChan <-chan *request
+		if pending.Len() < cap(cc.retryChan) {
+			retryChan = cc.retryChan
+		}
+
+		// retries keep being queued while paused but none are sent until resumed
+		resumed := cc.pauser.waitCh()
+
+		var due <-chan time.Time
+		if next := pending.peek(); next != nil && resumed == nil {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(next.sendAt.Sub(cc.now()))
+			due = timer.C
+		}
+
 		select {
 		case <-cc.ctx.Done(): // client is shutdown
 			return
-		case r := <-cc.retryChan:
+		case r := <-retryChan:
 			if r.ctx.Err() != nil {
+				cc.adjustRetryQueueLen(-1)
+				cc.releaseQueuedBytes(r)
+				cc.retryDequeued(r)
+				cc.recordDrop(r, DropCauseCancelled)
+				cc.completeFailed(r, nil, 0, nil, cc.cancelledErr())
 				continue
 			}
-			select {
-			case <-time.After(time.Until(r.sendAt)): // wait and resend the request
+			pending.push(r)
+		case <-resumed:
+		case <-cc.flushRetriesCh:
+			cc.flushRetries(pending)
+		case <-cc.rescheduleRetriesCh:
+			cc.rescheduleRetries(pending)
+		case <-due:
+			for r := pending.popDue(cc.now()); r != nil; r = pending.popDue(cc.now()) {
+				if cc.Paused() {
+					// leave the rest for once the client is resumed
+					pending.push(r)
+					break
+				}
+				cc.adjustRetryQueueLen(-1)
+				cc.releaseQueuedBytes(r)
+				cc.retryDequeued(r)
+				if r.ctx.Err() != nil { // request is cancelled
+					cc.recordDrop(r, DropCauseCancelled)
+					cc.completeFailed(r, nil, 0, nil, cc.cancelledErr())
+					continue
+				}
+				if !cc.acquireRetrySlot(r) { // client is shutdown
+					cc.failShutdown(r)
+					return
+				}
 				atomic.AddInt64(&cc.TotalRetriedUpdates, int64(1))
 				cc.makeRequest(r)
-			case <-r.ctx.Done(): // request is cancelled
-				continue
-			case <-cc.ctx.Done(): // client is shutdown
-				return
+				if cc.ctx.Err() != nil { // client is shutdown
+					return
+				}
 			}
 		}
 	}
 }
 
-// Start the client's processing queue
+// Start the client's processing queue.  If a startup jitter is configured,
+// processing is delayed by a random duration up to the jitter.  If a dedup state file is
+// configured, the requests sent before the last restart are loaded from it first.
 func (cc *Client) Start() {
+	if err := cc.sentDedup.load(cc.now()); err != nil {
+		cc.log.WithError(err).Error("Unable to load correlation dedup state, duplicates of earlier requests will be sent")
+	}
+	var startupDelay time.Duration
+	if cc.startupJitter > 0 {
+		startupDelay = cc.jitter(cc.startupJitter)
+	}
 	cc.wg.Add(2)
-	go cc.processChan()
-	go cc.processRetryChan()
+	go cc.processChan(startupDelay)
+	go cc.processRetryChan(startupDelay)
+	if cc.watchdog != nil {
+		cc.wg.Add(1)
+		go cc.watchForStalls()
+	}
+	if cc.emitter != nil {
+		cc.wg.Add(1)
+		go cc.emitMetrics()
+	}
+	if cc.warmUpConnections > 0 {
+		cc.wg.Add(1)
+		go cc.warmUp()
+	}
+	if cc.sentDedup != nil && cc.dedupCleanupInterval > 0 {
+		cc.wg.Add(1)
+		go cc.saveDedupStatePeriodically()
+	}
 }
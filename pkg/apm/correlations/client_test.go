@@ -1,21 +1,27 @@
 package correlations
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"regexp"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/signalfx/golib/v3/datapoint"
 	"github.com/signalfx/signalfx-agent/pkg/apm/log"
+	"github.com/signalfx/signalfx-agent/pkg/apm/requests"
 	"github.com/stretchr/testify/require"
 )
 
@@ -63,7 +69,7 @@ func makeHandler(t *testing.T, corCh chan<- *request, forcedRespCode *atomic.Val
 				return
 			}
 			corCh <- &request{
-				operation: r.Method,
+				operation: OperationGet,
 				Correlation: &Correlation{
 					DimName:  match[1],
 					DimValue: match[2],
@@ -84,7 +90,7 @@ func makeHandler(t *testing.T, corCh chan<- *request, forcedRespCode *atomic.Val
 				return
 			}
 			cor = &request{
-				operation: r.Method,
+				operation: OperationCorrelate,
 				Correlation: &Correlation{
 					DimName:  match[1],
 					DimValue: match[2],
@@ -100,7 +106,7 @@ func makeHandler(t *testing.T, corCh chan<- *request, forcedRespCode *atomic.Val
 				return
 			}
 			cor = &request{
-				operation: r.Method,
+				operation: OperationDelete,
 				Correlation: &Correlation{
 					DimName:  match[1],
 					DimValue: match[2],
@@ -120,11 +126,26 @@ func makeHandler(t *testing.T, corCh chan<- *request, forcedRespCode *atomic.Val
 }
 
 func setup(t *testing.T) (CorrelationClient, chan *request, *atomic.Value, *atomic.Value, context.CancelFunc) {
+	client, serverCh, forcedRespCode, forcedRespPayload, cancel := setupUnstarted(t, nil)
+	client.Start()
+	return client, serverCh, forcedRespCode, forcedRespPayload, cancel
+}
+
+// setupUnstarted returns a client that has not been started yet so that tests can adjust it first.
+// The configure function, if not nil, can modify the client config before the client is created.
+func setupUnstarted(t *testing.T, configure func(conf *ClientConfig)) (*Client, chan *request, *atomic.Value, *atomic.Value, context.CancelFunc) {
 	serverCh := make(chan *request, 100)
 
 	var forcedRespCode atomic.Value
 	var forcedRespPayload atomic.Value
-	server := httptest.NewServer(makeHandler(t, serverCh, &forcedRespCode, &forcedRespPayload))
+	client, cancel := newTestClient(t, makeHandler(t, serverCh, &forcedRespCode, &forcedRespPayload), configure)
+
+	return client, serverCh, &forcedRespCode, &forcedRespPayload, cancel
+}
+
+// newTestClient returns an unstarted client that sends requests to a test server using the given handler
+func newTestClient(t *testing.T, handler http.Handler, configure func(conf *ClientConfig)) (*Client, context.CancelFunc) {
+	server := httptest.NewServer(handler)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
@@ -149,6 +170,9 @@ func setup(t *testing.T) (CorrelationClient, chan *request, *atomic.Value, *atom
 		AccessToken: "",
 		URL:         serverURL,
 	}
+	if configure != nil {
+		configure(&conf)
+	}
 
 	httpClient := &http.Client{
 		Timeout: 10 * time.Second,
@@ -169,9 +193,8 @@ func setup(t *testing.T) (CorrelationClient, chan *request, *atomic.Value, *atom
 	if err != nil {
 		panic("could not make correlation client: " + err.Error())
 	}
-	client.Start()
 
-	return client, serverCh, &forcedRespCode, &forcedRespPayload, cancel
+	return client.(*Client), cancel
 }
 
 func TestCorrelationClient(t *testing.T) {
@@ -180,15 +203,15 @@ func TestCorrelationClient(t *testing.T) {
 	defer cancel()
 
 	for _, correlationType := range []Type{Service, Environment} {
-		for _, op := range []string{http.MethodPut, http.MethodDelete} {
+		for _, op := range []Operation{OperationCorrelate, OperationDelete} {
 			op := op
 			correlationType := correlationType
 			t.Run(fmt.Sprintf("%v %v", op, correlationType), func(t *testing.T) {
 				testData := &Correlation{Type: correlationType, DimName: "host", DimValue: "test-box", Value: "test-service"}
 				switch op {
-				case http.MethodPut:
+				case OperationCorrelate:
 					client.Correlate(testData, CorrelateCB(func(_ *Correlation, _ error) {}))
-				case http.MethodDelete:
+				case OperationDelete:
 					client.Delete(testData, SuccessfulDeleteCB(func(_ *Correlation) {}))
 				}
 				cors := waitForCors(serverCh, 1, 5)
@@ -249,3 +272,1798 @@ func TestCorrelationClient(t *testing.T) {
 		require.Equal(t, []*request{}, cors)
 	})
 }
+
+func TestCorrelationClientStartupJitter(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.StartupJitter = time.Hour
+	})
+	defer close(serverCh)
+	defer cancel()
+
+	var requestedJitter time.Duration
+	client.jitter = func(max time.Duration) time.Duration {
+		requestedJitter = max
+		return 2 * time.Second
+	}
+	client.Start()
+	require.Equal(t, time.Hour, requestedJitter)
+
+	testData := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "test-service"}
+	client.Correlate(testData, CorrelateCB(func(_ *Correlation, _ error) {}))
+
+	// nothing should be sent until the startup delay has elapsed
+	cors := waitForCors(serverCh, 1, 1)
+	require.Len(t, cors, 0)
+
+	cors = waitForCors(serverCh, 1, 3)
+	require.Equal(t, []*request{{operation: OperationCorrelate, Correlation: testData}}, cors)
+}
+
+func TestRandomJitter(t *testing.T) {
+	require.Equal(t, time.Duration(0), randomJitter(0))
+	for i := 0; i < 100; i++ {
+		jitter := randomJitter(time.Second)
+		require.True(t, jitter >= 0 && jitter < time.Second, "jitter %v out of range", jitter)
+	}
+}
+
+func TestCorrelationClientRecoversFromCallbackPanics(t *testing.T) {
+	client, serverCh, _, _, cancel := setup(t)
+	defer close(serverCh)
+	defer cancel()
+
+	testData := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "test-service"}
+	client.Correlate(testData, CorrelateCB(func(_ *Correlation, _ error) {
+		panic("buggy callback")
+	}))
+	cors := waitForCors(serverCh, 1, 3)
+	require.Len(t, cors, 1)
+
+	// the processing loop should still be alive and able to send requests
+	otherData := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "other-service"}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	client.Correlate(otherData, CorrelateCB(func(_ *Correlation, err error) {
+		require.Nil(t, err)
+		wg.Done()
+	}))
+	cors = waitForCors(serverCh, 1, 3)
+	require.Equal(t, []*request{{operation: OperationCorrelate, Correlation: otherData}}, cors)
+	wg.Wait()
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&client.(*Client).TotalCallbackPanics) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestCorrelationClientRejectsInvalidValues(t *testing.T) {
+	client, serverCh, _, _, cancel := setup(t)
+	defer close(serverCh)
+	defer cancel()
+
+	testData := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "test\nservice"}
+	var cbErr error
+	err := client.(*Client).putRequestOnChan(&request{Correlation: testData, operation: OperationCorrelate, callback: func(_ []byte, _ int, _ http.Header, err error) {
+		cbErr = err
+	}})
+	require.IsType(t, &ErrInvalidCorrelationValue{}, err)
+	cause, ok := dropCauseForErr(cbErr)
+	require.True(t, ok)
+	require.Equal(t, DropCauseInvalidValue, cause)
+	require.True(t, errors.Is(cbErr, err))
+
+	client.Delete(testData, SuccessfulDeleteCB(func(_ *Correlation) {}))
+	cors := waitForCors(serverCh, 1, 1)
+	require.Len(t, cors, 0)
+	require.Equal(t, int64(2), atomic.LoadInt64(&client.(*Client).TotalInvalidValues))
+}
+
+func TestCorrelationClientTracesConnections(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.TraceConnections = true
+	})
+	defer close(serverCh)
+	defer cancel()
+	client.Start()
+
+	for _, value := range []string{"service-1", "service-2", "service-3"} {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: value}, CorrelateCB(func(_ *Correlation, _ error) {
+			wg.Done()
+		}))
+		wg.Wait()
+		require.Len(t, waitForCors(serverCh, 1, 3), 1)
+	}
+
+	// requests are sent one at a time so the first connection should be reused
+	require.Equal(t, int64(1), atomic.LoadInt64(&client.TotalConnNew))
+	require.Equal(t, int64(2), atomic.LoadInt64(&client.TotalConnReused))
+}
+
+func TestCorrelationClientRetryDelayOverride(t *testing.T) {
+	client, serverCh, forcedRespCode, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.RetryDelay = time.Hour
+		conf.MaxRetries = 1
+	})
+	defer close(serverCh)
+	defer cancel()
+	client.Start()
+
+	forcedRespCode.Store(500)
+
+	// the request without an override should wait out the client's retry delay
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "slow-service"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+	time.Sleep(500 * time.Millisecond)
+	require.Equal(t, int64(0), atomic.LoadInt64(&client.TotalRetriedUpdates))
+
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "fast-service"}, CorrelateCB(func(_ *Correlation, _ error) {}),
+		RequestOptions{RetryDelay: 10 * time.Millisecond})
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&client.TotalRetriedUpdates) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+// retryDelayObserver reports the delay of each retry that is scheduled
+type retryDelayObserver struct {
+	NopObserver
+	delays chan time.Duration
+}
+
+func (o *retryDelayObserver) RetryScheduled(_ *Correlation, _ Operation, delay time.Duration) {
+	o.delays <- delay
+}
+
+func TestCorrelationClientHonorsRetryAfter(t *testing.T) {
+	// the clock is whole seconds since HTTP dates are
+	start := time.Now().Truncate(time.Second)
+	for _, tc := range []struct {
+		name       string
+		retryAfter string
+		delay      time.Duration
+		retried    bool
+	}{
+		{name: "seconds", retryAfter: "30", delay: 30 * time.Second, retried: true},
+		{name: "http date", retryAfter: start.Add(time.Minute).UTC().Format(http.TimeFormat), delay: time.Minute, retried: true},
+		{name: "http date in the past", retryAfter: start.Add(-time.Minute).UTC().Format(http.TimeFormat), delay: 0, retried: true},
+		{name: "unparseable", retryAfter: "soon", delay: time.Hour, retried: false},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var client *Client
+			var attempts int64
+			handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt64(&attempts, 1) == 1 {
+					// hold the retry until the clock has been moved past when it is due
+					client.Pause(false)
+					rw.Header().Set("Retry-After", tc.retryAfter)
+					rw.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				rw.WriteHeader(http.StatusOK)
+			})
+			// the client's own retry delay is far longer than the clock is moved so only a honored
+			// Retry-After header will cause a retry
+			observer := &retryDelayObserver{delays: make(chan time.Duration, 1)}
+			client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+				conf.RetryDelay = time.Hour
+				conf.Observer = observer
+			})
+			defer cancel()
+			var offset int64
+			client.now = func() time.Time { return start.Add(time.Duration(atomic.LoadInt64(&offset))) }
+			client.Start()
+
+			done := make(chan error, 1)
+			client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "test-service"}, CorrelateCB(func(_ *Correlation, err error) {
+				done <- err
+			}))
+			require.Equal(t, tc.delay, <-observer.delays)
+
+			// a retry that is due a minute later is sent once resumed
+			atomic.StoreInt64(&offset, int64(time.Minute))
+			client.Resume()
+			if !tc.retried {
+				require.Equal(t, int64(1), atomic.LoadInt64(&attempts))
+				return
+			}
+			require.NoError(t, <-done)
+			require.Equal(t, int64(2), atomic.LoadInt64(&attempts))
+		})
+	}
+}
+
+func TestCorrelationClientDeleteMany(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		match := deletePathRegexp.FindStringSubmatch(r.URL.Path)
+		switch {
+		case match == nil:
+			rw.WriteHeader(http.StatusNotFound)
+		case match[4] == "bad-service":
+			rw.WriteHeader(http.StatusBadRequest)
+		case match[4] == "flaky-service":
+			rw.WriteHeader(http.StatusInternalServerError)
+		default:
+			rw.WriteHeader(http.StatusOK)
+		}
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.MaxRetries = 2
+	})
+	defer cancel()
+	client.Start()
+
+	good := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "good-service"}
+	bad := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "bad-service"}
+	flaky := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "flaky-service"}
+	noDim := &Correlation{Type: Service, DimName: "host", Value: "good-service"}
+
+	resultsCh := make(chan map[*Correlation]error, 1)
+	client.DeleteMany(context.Background(), []*Correlation{good, bad, flaky, noDim}, DeleteManyCB(func(results map[*Correlation]error) {
+		resultsCh <- results
+	}))
+
+	select {
+	case results := <-resultsCh:
+		require.Len(t, results, 4)
+		require.NoError(t, results[good])
+		require.Error(t, results[bad])
+		// the flaky delete exhausts its retries before failing
+		require.Error(t, results[flaky])
+		require.NotZero(t, atomic.LoadInt64(&client.TotalRetriedUpdates))
+		require.Equal(t, errInvalidDimension, results[noDim])
+		require.Equal(t, int64(2), atomic.LoadInt64(&client.TotalFailedDeletes))
+	case <-time.After(5 * time.Second):
+		t.Fatal("DeleteMany callback was not invoked")
+	}
+}
+
+func TestCorrelationClientDeleteManyContext(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if deletePathRegexp.FindStringSubmatch(r.URL.Path)[4] == "flaky-service" {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.RetryDelay = time.Hour
+	})
+	defer cancel()
+	client.Start()
+
+	good := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "good-service"}
+	flaky := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "flaky-service"}
+	ctx, cancelWait := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancelWait()
+	resultsCh := make(chan map[*Correlation]error, 1)
+	client.DeleteMany(ctx, []*Correlation{good, flaky}, DeleteManyCB(func(results map[*Correlation]error) {
+		resultsCh <- results
+	}))
+
+	// the flaky delete is still waiting to be retried when the context is done
+	select {
+	case results := <-resultsCh:
+		require.Len(t, results, 2)
+		require.NoError(t, results[good])
+		require.Equal(t, context.DeadlineExceeded, results[flaky])
+	case <-time.After(5 * time.Second):
+		t.Fatal("DeleteMany callback was not invoked once the context was done")
+	}
+	require.Equal(t, int64(1), atomic.LoadInt64(&client.retryQueueLen))
+}
+
+func TestCorrelationClientDropOldest(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.MaxBuffered = 2
+		conf.DropPolicy = DropOldest
+	})
+	defer close(serverCh)
+	defer cancel()
+
+	// fill the request channel before the client starts draining it
+	var reqs []*request
+	for _, value := range []string{"service-1", "service-2", "service-3"} {
+		r := &request{Correlation: &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: value}, operation: OperationCorrelate, callback: func(_ []byte, _ int, _ http.Header, _ error) {}}
+		require.NoError(t, client.putRequestOnChan(r))
+		reqs = append(reqs, r)
+	}
+
+	require.Error(t, reqs[0].ctx.Err())
+	require.NoError(t, reqs[1].ctx.Err())
+	require.NoError(t, reqs[2].ctx.Err())
+	require.Equal(t, int64(1), atomic.LoadInt64(&client.TotalEvictedRequests))
+
+	client.Start()
+	cors := waitForCors(serverCh, 2, 3)
+	require.Len(t, cors, 2)
+	require.ElementsMatch(t, []string{"service-2", "service-3"}, []string{cors[0].Correlation.Value, cors[1].Correlation.Value})
+}
+
+func TestCorrelationClientInvalidDropPolicy(t *testing.T) {
+	_, err := NewCorrelationClient(log.Nil, context.Background(), &http.Client{}, ClientConfig{Config: Config{DropPolicy: "drop_random"}})
+	require.Error(t, err)
+}
+
+func TestCorrelationClientPutContentType(t *testing.T) {
+	contentTypes := make(chan string, 1)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		contentTypes <- r.Header.Get("Content-Type")
+		rw.WriteHeader(http.StatusOK)
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.PutContentType = "application/octet-stream"
+	})
+	defer cancel()
+	client.Start()
+
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+	select {
+	case contentType := <-contentTypes:
+		require.Equal(t, "application/octet-stream", contentType)
+	case <-time.After(3 * time.Second):
+		t.Fatal("correlation was not sent")
+	}
+}
+
+func TestCorrelationClientInvalidPutContentType(t *testing.T) {
+	_, err := NewCorrelationClient(log.Nil, context.Background(), &http.Client{}, ClientConfig{Config: Config{PutContentType: "not a mime type"}})
+	require.Error(t, err)
+}
+
+func TestCorrelationClientSharedRequestSender(t *testing.T) {
+	ctx, cancelSender := context.WithCancel(context.Background())
+	defer cancelSender()
+	sender := requests.NewReqSender(ctx, &http.Client{Timeout: 10 * time.Second}, 1, "correlation")
+
+	var clients []*Client
+	var serverChs []chan *request
+	for i := 0; i < 2; i++ {
+		client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+			conf.RequestSender = sender
+		})
+		defer close(serverCh)
+		defer cancel()
+		client.Start()
+		clients = append(clients, client)
+		serverChs = append(serverChs, serverCh)
+	}
+
+	for i, client := range clients {
+		client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+		require.Len(t, waitForCors(serverChs[i], 1, 3), 1)
+	}
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&sender.TotalRequestsCompleted) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	require.LessOrEqual(t, atomic.LoadInt64(&sender.RunningWorkers), int64(1))
+
+	// the shared sender's metrics are left to its owner rather than reported by each client
+	for _, client := range clients {
+		for _, dp := range client.InternalMetrics() {
+			require.NotEqual(t, "sfxagent.dim_updates_started", dp.Metric)
+		}
+	}
+}
+
+func TestCorrelationClientTopDimensions(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.MaxTrackedDimensions = 1
+	})
+	defer close(serverCh)
+	defer cancel()
+
+	for _, dimName := range []string{"host", "host", "container_id"} {
+		r := &request{Correlation: &Correlation{Type: Service, DimName: dimName, DimValue: "test-box", Value: "service"}, operation: OperationCorrelate}
+		require.NoError(t, client.putRequestOnChan(r))
+	}
+
+	require.Equal(t, []KeyCount{{Key: "host", Count: 2}}, client.TopDimensions(5))
+	require.Empty(t, client.TopDimensions(0))
+}
+
+func TestCorrelationClientOnHealthChange(t *testing.T) {
+	changes := make(chan bool, 10)
+	client, serverCh, forcedRespCode, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.MaxRetries = 0
+		conf.HealthFailureThreshold = 2
+		conf.OnHealthChange = func(healthy bool, _ string) {
+			changes <- healthy
+		}
+	})
+	defer close(serverCh)
+	defer cancel()
+	client.Start()
+
+	correlate := func(value string) {
+		done := make(chan struct{})
+		client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: value}, CorrelateCB(func(_ *Correlation, _ error) {
+			close(done)
+		}))
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Fatal("correlation callback was not invoked")
+		}
+	}
+
+	forcedRespCode.Store(http.StatusServiceUnavailable)
+	correlate("service-1")
+	correlate("service-2")
+	require.False(t, <-changes)
+
+	forcedRespCode.Store(http.StatusOK)
+	correlate("service-3")
+	require.True(t, <-changes)
+}
+
+func TestCorrelationClientGetDetailed(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("X-RateLimit-Remaining", "42")
+		switch getPathRegexp.FindStringSubmatch(r.URL.Path)[2] {
+		case "missing-box":
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		case "empty-box":
+			_, _ = rw.Write([]byte(`{}`))
+			return
+		}
+		_, _ = rw.Write([]byte(`{"sf_services":["service-1"]}`))
+	})
+	client, cancel := newTestClient(t, handler, nil)
+	defer cancel()
+	client.Start()
+
+	results := make(chan GetResult, 1)
+	client.GetDetailed("host", "test-box", GetDetailedCB(func(result GetResult) {
+		results <- result
+	}))
+	result := <-results
+	require.NoError(t, result.Err)
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	require.Equal(t, "42", result.Header.Get("X-RateLimit-Remaining"))
+	require.Equal(t, map[string][]string{"sf_services": {"service-1"}}, result.Correlations)
+	require.True(t, result.Found)
+
+	client.GetDetailed("host", "empty-box", GetDetailedCB(func(result GetResult) {
+		results <- result
+	}))
+	result = <-results
+	require.NoError(t, result.Err)
+	require.True(t, result.Found)
+	require.NotNil(t, result.Correlations)
+	require.Empty(t, result.Correlations)
+
+	client.GetDetailed("host", "missing-box", GetDetailedCB(func(result GetResult) {
+		results <- result
+	}))
+	result = <-results
+	require.False(t, result.Found)
+	require.Error(t, result.Err)
+	require.Equal(t, http.StatusNotFound, result.StatusCode)
+	require.Equal(t, "42", result.Header.Get("X-RateLimit-Remaining"))
+	require.Nil(t, result.Correlations)
+}
+
+func TestCorrelationClientMaxGetRequests(t *testing.T) {
+	release := make(chan struct{})
+	gets := make(chan string, 10)
+	puts := make(chan string, 10)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			gets <- getPathRegexp.FindStringSubmatch(r.URL.Path)[2]
+			<-release
+			_, _ = rw.Write([]byte(`{}`))
+		default:
+			puts <- r.URL.Path
+			rw.WriteHeader(http.StatusOK)
+		}
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.MaxGetRequests = 1
+	})
+	defer cancel()
+	client.Start()
+
+	client.Get("host", "box-1", SuccessfulGetCB(func(map[string][]string) {}))
+	client.Get("host", "box-2", SuccessfulGetCB(func(map[string][]string) {}))
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+
+	// the update isn't held up by the get that is waiting for a slot
+	first := <-gets
+	select {
+	case <-puts:
+	case <-time.After(3 * time.Second):
+		t.Fatal("update was blocked by get requests")
+	}
+	select {
+	case dimValue := <-gets:
+		t.Fatalf("get for %s was sent before a slot was released", dimValue)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(release)
+	second := <-gets
+	require.ElementsMatch(t, []string{"box-1", "box-2"}, []string{first, second})
+}
+
+func TestCorrelationClientReconfigure(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, nil)
+	defer close(serverCh)
+	defer cancel()
+
+	conf := client.conf
+	conf.RetryDelay = time.Minute
+	conf.MaxRetries = 1
+	conf.LogUpdates = false
+	conf.BackoffStrategy = BackoffFullJitter
+	require.NoError(t, client.Reconfigure(conf))
+	require.Equal(t, uint32(2), client.maxAttempts)
+	require.Equal(t, time.Minute, client.retryDelay)
+	require.False(t, client.shouldLogUpdates())
+
+	// buffer sizes can't be changed while running and nothing should be applied
+	cold := conf
+	cold.MaxBuffered = 1000
+	cold.MaxRetries = 5
+	require.Error(t, client.Reconfigure(cold))
+	require.Equal(t, uint32(2), client.maxAttempts)
+	require.Equal(t, 10, cap(client.requestChan))
+}
+
+func TestCorrelationClientReconfigureReschedulesRetries(t *testing.T) {
+	var attempts int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&attempts, 1) == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.RetryDelay = time.Hour
+	})
+	defer cancel()
+	client.Start()
+
+	done := make(chan error, 1)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, err error) {
+		done <- err
+	}))
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&client.retryQueueLen) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// the retry waiting out the old delay is sent once the new one has passed
+	conf := client.conf
+	conf.RetryDelay = time.Millisecond
+	require.NoError(t, client.Reconfigure(conf))
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("retry was not rescheduled")
+	}
+	require.Equal(t, int64(2), atomic.LoadInt64(&attempts))
+	require.Equal(t, int64(0), atomic.LoadInt64(&client.TotalFlushedRetries))
+}
+
+func TestHotReloadableFields(t *testing.T) {
+	conf := reflect.TypeOf(Config{})
+	for _, name := range HotReloadableFields() {
+		_, ok := conf.FieldByName(name)
+		require.True(t, ok, name)
+		require.Contains(t, errRestartRequired.Error(), name)
+	}
+	require.Subset(t, HotReloadableFields(), retryScheduleFields)
+}
+
+func TestCorrelationClientCountsDropsByCause(t *testing.T) {
+	client, serverCh, forcedRespCode, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.MaxBuffered = 1
+		conf.MaxRetries = 0
+	})
+	defer close(serverCh)
+	defer cancel()
+
+	noop := CorrelateCB(func(_ *Correlation, _ error) {})
+	client.Correlate(&Correlation{Type: Service, DimName: "host", Value: "service"}, noop)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: ".."}, noop)
+	// the second request doesn't fit in the unstarted client's channel
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service-1"}, noop)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service-2"}, noop)
+
+	require.Equal(t, int64(1), client.TotalDropped(DropCauseInvalidDimension))
+	require.Equal(t, int64(1), client.TotalDropped(DropCauseInvalidValue))
+	require.Equal(t, int64(1), client.TotalDropped(DropCauseChannelFull))
+
+	forcedRespCode.Store(http.StatusInternalServerError)
+	client.Start()
+	require.Eventually(t, func() bool {
+		return client.TotalDropped(DropCauseMaxAttempts) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	var dropped int
+	for _, dp := range client.InternalMetrics() {
+		if dp.Metric == "sfxagent.correlation_requests_dropped" {
+			dropped++
+		}
+	}
+	require.Equal(t, len(DropCauses()), dropped)
+}
+
+func TestCorrelationClientSendsHighPriorityFirst(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		// send one request at a time so that the order they arrive in is deterministic
+		conf.MaxRequests = 1
+	})
+	defer close(serverCh)
+	defer cancel()
+
+	noop := CorrelateCB(func(_ *Correlation, _ error) {})
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "normal-1"}, noop)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "normal-2"}, noop)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "critical"}, noop, RequestOptions{Priority: PriorityHigh})
+	client.Start()
+
+	cors := waitForCors(serverCh, 3, 3)
+	require.Len(t, cors, 3)
+	require.Equal(t, "critical", cors[0].Correlation.Value)
+	require.Equal(t, "normal-1", cors[1].Correlation.Value)
+	require.Equal(t, "normal-2", cors[2].Correlation.Value)
+}
+
+func TestCorrelationClientOnStalled(t *testing.T) {
+	stalled := make(chan struct{}, 1)
+	client, serverCh, forcedRespCode, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.StallWindow = 100 * time.Millisecond
+		conf.OnStalled = func() { stalled <- struct{}{} }
+	})
+	defer close(serverCh)
+	defer cancel()
+	forcedRespCode.Store(http.StatusInternalServerError)
+	client.Start()
+
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+	select {
+	case <-stalled:
+	case <-time.After(3 * time.Second):
+		t.Fatal("OnStalled was not called")
+	}
+}
+
+func TestCorrelationClientFiltersTypes(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.DeniedTypes = []Type{Environment}
+	})
+	defer close(serverCh)
+	defer cancel()
+	client.Start()
+
+	client.Correlate(&Correlation{Type: Environment, DimName: "host", DimValue: "test-box", Value: "prod"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+	client.Delete(&Correlation{Type: Environment, DimName: "host", DimValue: "test-box", Value: "prod"}, SuccessfulDeleteCB(func(_ *Correlation) {}))
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+
+	cors := waitForCors(serverCh, 1, 3)
+	require.Len(t, cors, 1)
+	require.Equal(t, Service, cors[0].Correlation.Type)
+	require.Equal(t, int64(2), client.TotalDropped(DropCauseFilteredType))
+}
+
+func TestCorrelationClientAllowedDimensions(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.AllowedDimensions = []string{"Host"}
+		conf.AllowedDimensionsCaseInsensitive = true
+	})
+	defer close(serverCh)
+	defer cancel()
+	client.Start()
+
+	client.Correlate(&Correlation{Type: Service, DimName: "container_id", DimValue: "abc", Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+	client.Delete(&Correlation{Type: Service, DimName: "container_id", DimValue: "abc", Value: "service"}, SuccessfulDeleteCB(func(_ *Correlation) {}))
+	_, err := client.GetSync(context.Background(), "container_id", "abc")
+	require.True(t, errors.Is(err, errFilteredDimension))
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+
+	cors := waitForCors(serverCh, 1, 3)
+	require.Len(t, cors, 1)
+	require.Equal(t, "host", cors[0].Correlation.DimName)
+	require.Equal(t, int64(3), client.TotalDropped(DropCauseFilteredDimension))
+}
+
+func TestCorrelationClientHedgesSlowGets(t *testing.T) {
+	var attempts int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// only the first attempt is slow
+		if atomic.AddInt64(&attempts, 1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+		_, _ = rw.Write([]byte(`{"sf_services":["service-1"]}`))
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.HedgePercentile = 0.95
+		conf.HedgeDelay = 50 * time.Millisecond
+	})
+	defer cancel()
+	client.Start()
+
+	results := make(chan map[string][]string, 1)
+	client.Get("host", "test-box", SuccessfulGetCB(func(cors map[string][]string) {
+		results <- cors
+	}))
+	select {
+	case cors := <-results:
+		require.Equal(t, map[string][]string{"sf_services": {"service-1"}}, cors)
+	case <-time.After(3 * time.Second):
+		t.Fatal("hedged get did not complete")
+	}
+	require.Equal(t, int64(1), atomic.LoadInt64(&client.TotalHedgedRequests))
+	require.Equal(t, int64(1), atomic.LoadInt64(&client.TotalHedgeWins))
+}
+
+func TestCorrelationClientRetryQueueEMA(t *testing.T) {
+	client, serverCh, forcedRespCode, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.RetryDelay = time.Hour
+		conf.RetryQueueEMADecay = 0.5
+	})
+	defer close(serverCh)
+	defer cancel()
+	forcedRespCode.Store(http.StatusInternalServerError)
+	client.Start()
+
+	require.Zero(t, client.RetryQueueEMA())
+	for _, value := range []string{"service-1", "service-2"} {
+		client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: value}, CorrelateCB(func(_ *Correlation, _ error) {}))
+	}
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&client.retryQueueLen) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	// samples of 1 then 2 with a decay of 0.5
+	require.Equal(t, 1.25, client.RetryQueueEMA())
+}
+
+func TestCorrelationClientCallbackErrorsAreTyped(t *testing.T) {
+	client, serverCh, forcedRespCode, _, cancel := setupUnstarted(t, nil)
+	defer close(serverCh)
+	defer cancel()
+	forcedRespCode.Store(http.StatusForbidden)
+	client.Start()
+
+	errs := make(chan error, 1)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, err error) {
+		errs <- err
+	}))
+	var reqErr *RequestError
+	require.True(t, errors.As(<-errs, &reqErr))
+	require.Equal(t, OperationCorrelate, reqErr.Operation)
+	require.Equal(t, http.StatusForbidden, reqErr.StatusCode())
+	require.False(t, reqErr.Retryable())
+}
+
+func TestCorrelationClientMaxQueuedBytes(t *testing.T) {
+	cors := []*Correlation{
+		{Type: Service, DimName: "host", DimValue: "test-box", Value: "service-1"},
+		{Type: Service, DimName: "host", DimValue: "test-box", Value: "service-2"},
+		{Type: Service, DimName: "host", DimValue: "test-box", Value: "service-3"},
+	}
+	budget := approxEntryBytes(cors[0]) + approxEntryBytes(cors[1])
+	noop := func(_ []byte, _ int, _ http.Header, _ error) {}
+	client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.MaxQueuedBytes = uint(budget)
+	})
+	defer close(serverCh)
+	defer cancel()
+
+	require.NoError(t, client.putRequestOnChan(&request{Correlation: cors[0], operation: OperationCorrelate, callback: noop}))
+	require.NoError(t, client.putRequestOnChan(&request{Correlation: cors[1], operation: OperationCorrelate, callback: noop}))
+	err := client.putRequestOnChan(&request{Correlation: cors[2], operation: OperationCorrelate, callback: noop})
+	require.Equal(t, errMaxQueuedBytes, err)
+	require.Equal(t, budget, client.QueuedBytes())
+	require.Equal(t, int64(1), client.TotalDropped(DropCauseMaxQueuedBytes))
+
+	// the budget is released as requests are sent
+	client.Start()
+	require.Eventually(t, func() bool {
+		return client.QueuedBytes() == 0
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestCorrelationClientAcceptsAny2xx(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusNoContent)
+	})
+	client, cancel := newTestClient(t, handler, nil)
+	defer cancel()
+	client.Start()
+
+	errs := make(chan error, 1)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, err error) {
+		errs <- err
+	}))
+	require.NoError(t, <-errs)
+
+	deleted := make(chan struct{}, 1)
+	client.Delete(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, SuccessfulDeleteCB(func(_ *Correlation) {
+		deleted <- struct{}{}
+	}))
+	<-deleted
+	require.Equal(t, int64(0), atomic.LoadInt64(&client.TotalFailedDeletes))
+
+	results := make(chan GetResult, 1)
+	client.GetDetailed("host", "test-box", GetDetailedCB(func(result GetResult) {
+		results <- result
+	}))
+	result := <-results
+	require.NoError(t, result.Err)
+	require.Equal(t, http.StatusNoContent, result.StatusCode)
+	require.Empty(t, result.Correlations)
+}
+
+func TestCorrelationClientCollapsesDeleteThenCorrelate(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.CollapseWindow = 500 * time.Millisecond
+	})
+	defer close(serverCh)
+	defer cancel()
+	client.Start()
+
+	cor := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}
+	client.Delete(cor, SuccessfulDeleteCB(func(_ *Correlation) {}))
+	client.Correlate(cor, CorrelateCB(func(_ *Correlation, _ error) {}))
+	require.Empty(t, waitForCors(serverCh, 1, 1))
+	require.Equal(t, int64(1), atomic.LoadInt64(&client.TotalCollapsedRequests))
+
+	// a delete that isn't followed by a correlate is sent once the window passes
+	client.Delete(cor, SuccessfulDeleteCB(func(_ *Correlation) {}))
+	cors := waitForCors(serverCh, 1, 3)
+	require.Len(t, cors, 1)
+	require.Equal(t, OperationDelete, cors[0].operation)
+}
+
+func TestCorrelationClientGetSync(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if getPathRegexp.FindStringSubmatch(r.URL.Path)[2] == "missing-box" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = rw.Write([]byte(`{"sf_services":["service-1"]}`))
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.MaxBuffered = 1
+	})
+	defer cancel()
+
+	// nothing is sent until the client is started
+	ctx, cancelCtx := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelCtx()
+	_, err := client.GetSync(ctx, "host", "test-box")
+	require.Equal(t, context.DeadlineExceeded, err)
+	// the request from the previous call is still queued
+	_, err = client.GetSync(context.Background(), "host", "test-box")
+	require.Equal(t, ErrChFull, err)
+
+	client.Start()
+	var correlations map[string][]string
+	// wait for the queued request to be taken off of the channel
+	require.Eventually(t, func() bool {
+		correlations, err = client.GetSync(context.Background(), "host", "test-box")
+		return err != ErrChFull
+	}, 3*time.Second, 10*time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{"sf_services": {"service-1"}}, correlations)
+
+	correlations, err = client.GetSync(context.Background(), "host", "missing-box")
+	require.NoError(t, err)
+	require.Empty(t, correlations)
+	require.NotNil(t, correlations)
+}
+
+func TestCorrelationClientGetSyncDropped(t *testing.T) {
+	client, _, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.MaxBuffered = 1
+		conf.DropPolicy = DropOldest
+		conf.AllowedTypes = []Type{Service}
+	})
+	defer cancel()
+
+	_, err := client.GetSync(context.Background(), "host", "")
+	require.Equal(t, errInvalidDimension, err)
+
+	// the waiting get is evicted by the next one since nothing is draining the queue
+	evicted := make(chan error, 1)
+	go func() {
+		_, err := client.GetSync(context.Background(), "host", "test-box")
+		evicted <- err
+	}()
+	require.Eventually(t, func() bool { return len(client.requestChan) == 1 }, 3*time.Second, 10*time.Millisecond)
+	client.Get("host", "other-box", func(map[string][]string) {})
+	select {
+	case err := <-evicted:
+		require.Equal(t, errEvicted, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("GetSync did not return when its request was evicted")
+	}
+
+	// a request that is dropped before it is queued still invokes its callback
+	dropped := make(chan error, 1)
+	client.Correlate(&Correlation{Type: Environment, DimName: "host", DimValue: "test-box", Value: "env"}, func(_ *Correlation, err error) {
+		dropped <- err
+	})
+	require.Equal(t, errFilteredType, <-dropped)
+}
+
+func TestCorrelationClientRecordsRequestAge(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, nil)
+	defer close(serverCh)
+	defer cancel()
+
+	var offset int64
+	start := time.Now()
+	client.now = func() time.Time { return start.Add(time.Duration(atomic.LoadInt64(&offset))) }
+
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+	// the request waits on the channel until the client is started
+	atomic.StoreInt64(&offset, int64(2*time.Second))
+	client.Start()
+	require.Len(t, waitForCors(serverCh, 1, 3), 1)
+
+	buckets := map[string]string{}
+	for _, dp := range client.requestAge.datapoints("age") {
+		if dp.Metric == "age_bucket" {
+			buckets[dp.Dimensions["upper_bound"]] = dp.Value.String()
+		}
+	}
+	require.Equal(t, "0", buckets["1.000000"])
+	require.Equal(t, "1", buckets["5.000000"])
+}
+
+func TestCorrelationClientResetStats(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, nil)
+	defer close(serverCh)
+	defer cancel()
+	client.Start()
+
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "", Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+	require.Len(t, waitForCors(serverCh, 1, 3), 1)
+	require.Equal(t, int64(1), atomic.LoadInt64(&client.TotalInvalidDimensions))
+	require.Equal(t, int64(1), client.TotalDropped(DropCauseInvalidDimension))
+
+	client.ResetStats()
+	require.Zero(t, atomic.LoadInt64(&client.TotalInvalidDimensions))
+	require.Zero(t, client.TotalDropped(DropCauseInvalidDimension))
+	require.Equal(t, "0", client.requestAge.datapoints("age")[0].Value.String())
+}
+
+func TestCorrelationClientCorrelateWithTTL(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, nil)
+	defer close(serverCh)
+	defer cancel()
+	client.Start()
+
+	cor := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}
+	client.CorrelateWithTTL(cor, 200*time.Millisecond, CorrelateCB(func(_ *Correlation, _ error) {}))
+	cors := waitForCors(serverCh, 2, 3)
+	require.Len(t, cors, 2)
+	require.Equal(t, OperationCorrelate, cors[0].operation)
+	require.Equal(t, OperationDelete, cors[1].operation)
+
+	// deleting the correlation cancels its expiry
+	client.CorrelateWithTTL(cor, 200*time.Millisecond, CorrelateCB(func(_ *Correlation, _ error) {}))
+	client.Delete(cor, SuccessfulDeleteCB(func(_ *Correlation) {}))
+	require.Len(t, waitForCors(serverCh, 3, 1), 2)
+	require.Zero(t, client.expiries.len())
+
+	// making the correlation again without a ttl cancels its expiry
+	client.CorrelateWithTTL(cor, 200*time.Millisecond, CorrelateCB(func(_ *Correlation, _ error) {}))
+	require.Len(t, waitForCors(serverCh, 1, 3), 1)
+	client.Correlate(cor, CorrelateCB(func(_ *Correlation, _ error) {}))
+	require.Zero(t, client.expiries.len())
+	for _, r := range waitForCors(serverCh, 2, 1) {
+		require.Equal(t, OperationCorrelate, r.operation)
+	}
+}
+
+func TestCorrelationClientTTLHeader(t *testing.T) {
+	ttls := make(chan string, 1)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ttls <- r.Header.Get("X-SF-TTL")
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.TTLHeader = "X-SF-TTL"
+	})
+	defer cancel()
+	client.Start()
+
+	client.CorrelateWithTTL(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, time.Minute, CorrelateCB(func(_ *Correlation, _ error) {}))
+	require.Equal(t, "60", <-ttls)
+	// the backend expires the correlation so the client doesn't
+	require.Zero(t, client.expiries.len())
+}
+
+func TestCorrelationClientShedsUnderPressure(t *testing.T) {
+	var pressure int64
+	client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.ShedThreshold = 1
+		conf.Pressure = func() float64 { return float64(atomic.LoadInt64(&pressure)) }
+	})
+	defer close(serverCh)
+	defer cancel()
+	client.Start()
+
+	atomic.StoreInt64(&pressure, 2)
+	noop := CorrelateCB(func(_ *Correlation, _ error) {})
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "shed"}, noop)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "critical"}, noop, RequestOptions{Priority: PriorityHigh})
+	cors := waitForCors(serverCh, 2, 1)
+	require.Len(t, cors, 1)
+	require.Equal(t, "critical", cors[0].Value)
+	require.Equal(t, int64(1), client.TotalDropped(DropCauseShed))
+
+	// a shed delete is reported as shed
+	results := make(chan map[*Correlation]error, 1)
+	shed := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "shed"}
+	client.DeleteMany(context.Background(), []*Correlation{shed}, func(r map[*Correlation]error) { results <- r })
+	require.Equal(t, ErrShed, (<-results)[shed])
+
+	atomic.StoreInt64(&pressure, 0)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "sent"}, noop)
+	require.Len(t, waitForCors(serverCh, 1, 3), 1)
+}
+
+func TestCorrelationClientAuthHeader(t *testing.T) {
+	auths := make(chan string, 1)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		require.Empty(t, r.Header.Get("X-SF-TOKEN"))
+		auths <- r.Header.Get("Authorization")
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.AccessToken = "abc123"
+		conf.AuthHeader = "Authorization"
+		conf.AuthScheme = "Bearer"
+	})
+	defer cancel()
+	client.Start()
+
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+	require.Equal(t, "Bearer abc123", <-auths)
+}
+
+func TestCorrelationClientSerializesByDimension(t *testing.T) {
+	unblock := make(chan struct{})
+	received := make(chan string, 3)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		match := putPathRegexp.FindStringSubmatch(r.URL.Path)
+		received <- match[2]
+		if match[2] == "test-box" {
+			<-unblock
+		}
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.SerializeByDimension = true
+	})
+	defer cancel()
+	client.Start()
+
+	noop := CorrelateCB(func(_ *Correlation, _ error) {})
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "first"}, noop)
+	require.Equal(t, "test-box", <-received)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "second"}, noop)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "other-box", Value: "first"}, noop)
+
+	// the other dimension is sent while the first request is still in flight
+	require.Equal(t, "other-box", <-received)
+	select {
+	case <-received:
+		t.Fatal("second request for the dimension should wait for the first")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(unblock)
+	require.Equal(t, "test-box", <-received)
+}
+
+func TestCorrelationClientOnDeduplicated(t *testing.T) {
+	deduplicated := make(chan *Correlation, 1)
+	client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.OnDeduplicated = func(cor *Correlation) {
+			deduplicated <- cor
+		}
+	})
+	defer close(serverCh)
+	defer cancel()
+
+	cor := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}
+	noop := CorrelateCB(func(_ *Correlation, _ error) {})
+	client.Correlate(cor, noop)
+	client.Correlate(cor, noop)
+	client.Start()
+
+	require.Len(t, waitForCors(serverCh, 2, 1), 1)
+	require.Equal(t, cor, <-deduplicated)
+
+	count, bytes := client.DedupSavings()
+	require.Equal(t, int64(1), count)
+	require.Equal(t, int64(len("host")+len("test-box")+len(Service)+len("service")), bytes)
+}
+
+type chanEmitter chan []*datapoint.Datapoint
+
+func (e chanEmitter) Emit(dps []*datapoint.Datapoint) {
+	select {
+	case e <- dps:
+	default:
+	}
+}
+
+func TestCorrelationClientEmitsMetrics(t *testing.T) {
+	emitted := make(chanEmitter, 1)
+	client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.Emitter = emitted
+		conf.EmitInterval = 50 * time.Millisecond
+	})
+	defer close(serverCh)
+	defer cancel()
+	client.Start()
+
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+	require.Len(t, waitForCors(serverCh, 1, 3), 1)
+
+	require.Eventually(t, func() bool {
+		for _, dp := range <-emitted {
+			if dp.Metric == "sfxagent.correlation_requests_completed" && dp.Dimensions["operation"] == "correlate" &&
+				dp.Dimensions["result"] == "success" && dp.Value.String() == "1" {
+				return true
+			}
+		}
+		return false
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestCorrelationClientCountsErrorClasses(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		// nothing listens on this port so connections are refused
+		conf.URL, _ = url.Parse("http://127.0.0.1:1")
+		conf.ConnectionBackoffMultiplier = 2
+	})
+	defer close(serverCh)
+	defer cancel()
+	client.Start()
+
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+	require.Eventually(t, func() bool {
+		return client.TotalErrors("connection") > 0
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Zero(t, client.TotalErrors("timeout"))
+	require.Equal(t, 2.0, client.backoffMultiplier(errorClassConnection))
+	require.Equal(t, 1.0, client.backoffMultiplier(errorClassTimeout))
+}
+
+func TestCorrelationClientOnMaxEntries(t *testing.T) {
+	type maxEntries struct {
+		cor *Correlation
+		max int64
+	}
+	hits := make(chan maxEntries, 1)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+		_, _ = rw.Write([]byte(`{"max":100}`))
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.OnMaxEntries = func(cor *Correlation, max int64) {
+			hits <- maxEntries{cor: cor, max: max}
+		}
+	})
+	defer cancel()
+	client.Start()
+
+	errs := make(chan error, 1)
+	cor := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}
+	client.Correlate(cor, CorrelateCB(func(_ *Correlation, err error) {
+		errs <- err
+	}))
+	require.Equal(t, maxEntries{cor: cor, max: 100}, <-hits)
+	require.Equal(t, &ErrMaxEntries{MaxEntries: 100}, <-errs)
+}
+
+func TestCorrelationClientTracksActiveRequests(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, nil)
+	defer close(serverCh)
+	defer cancel()
+
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+	require.Len(t, client.registry.active(), 1)
+
+	client.Start()
+	require.Len(t, waitForCors(serverCh, 1, 3), 1)
+	require.Eventually(t, func() bool {
+		return client.registry.len() == 0
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestCorrelationClientDecompressesGets(t *testing.T) {
+	payload := map[string][]string{"sf_services": make([]string, 0, 100)}
+	for i := 0; i < 100; i++ {
+		payload["sf_services"] = append(payload["sf_services"], fmt.Sprintf("service-%d", i))
+	}
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "gzip", r.Header.Get("Accept-Encoding"))
+		rw.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(rw)
+		require.NoError(t, json.NewEncoder(gz).Encode(payload))
+		require.NoError(t, gz.Close())
+	})
+	client, cancel := newTestClient(t, handler, nil)
+	defer cancel()
+	client.Start()
+
+	correlations, err := client.GetSync(context.Background(), "host", "test-box")
+	require.NoError(t, err)
+	require.Equal(t, payload, correlations)
+	require.Greater(t, atomic.LoadInt64(&client.TotalGetBytesSaved), int64(0))
+}
+
+func TestCorrelationClientOverflow(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.OverflowBuffered = 5
+		// send one request at a time so that the order they arrive in is deterministic
+		conf.MaxRequests = 1
+	})
+	defer close(serverCh)
+	defer cancel()
+
+	for i := 0; i < 15; i++ {
+		require.NoError(t, client.putRequestOnChan(&request{
+			Correlation: &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: fmt.Sprintf("service-%d", i)},
+			operation:   OperationCorrelate,
+			callback:    func(_ []byte, _ int, _ http.Header, _ error) {},
+		}))
+	}
+	require.Equal(t, 5, len(client.overflowChan))
+	require.Equal(t, ErrChFull, client.putRequestOnChan(&request{
+		Correlation: &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "dropped"},
+		operation:   OperationCorrelate,
+		callback:    func(_ []byte, _ int, _ http.Header, _ error) {},
+	}))
+
+	client.Start()
+	cors := waitForCors(serverCh, 15, 5)
+	require.Len(t, cors, 15)
+	for i, cor := range cors {
+		require.Equal(t, fmt.Sprintf("service-%d", i), cor.Value)
+	}
+}
+
+func TestCorrelationClientOverflowOnlyWhenFull(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.MaxBuffered = 2
+		conf.OverflowBuffered = 2
+	})
+	defer close(serverCh)
+	defer cancel()
+
+	put := func(value string) {
+		require.NoError(t, client.putRequestOnChan(&request{
+			Correlation: &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: value},
+			operation:   OperationCorrelate,
+			callback:    func(_ []byte, _ int, _ http.Header, _ error) {},
+		}))
+	}
+	for i := 0; i < 3; i++ {
+		put(fmt.Sprintf("service-%d", i))
+	}
+	require.Equal(t, 1, len(client.overflowChan))
+
+	// a request made once there is room on the request channel isn't put on the overflow
+	require.Equal(t, "service-0", (<-client.requestChan).Value)
+	put("service-3")
+	require.Equal(t, 2, len(client.requestChan))
+	require.Equal(t, 1, len(client.overflowChan))
+
+	// the overflowed request is handed back if it can't be moved to the full request channel
+	require.Equal(t, "service-2", client.refillFromOverflow().Value)
+
+	put("service-4")
+	require.Equal(t, "service-1", (<-client.requestChan).Value)
+	require.Nil(t, client.refillFromOverflow())
+	require.Equal(t, 0, len(client.overflowChan))
+	require.Equal(t, "service-3", (<-client.requestChan).Value)
+	require.Equal(t, "service-4", (<-client.requestChan).Value)
+}
+
+type signerFunc func(req *http.Request) error
+
+func (f signerFunc) Sign(req *http.Request) error {
+	return f(req)
+}
+
+func TestCorrelationClientRequestSigner(t *testing.T) {
+	signatures := make(chan string, 10)
+	var attempts int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		signatures <- r.Header.Get("X-Signature")
+		// fail the first attempt so that the retry is signed again
+		if atomic.AddInt64(&attempts, 1) == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	var signed int64
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.RequestSigner = signerFunc(func(req *http.Request) error {
+			req.Header.Set("X-Signature", fmt.Sprintf("%s-%d", req.Method, atomic.AddInt64(&signed, 1)))
+			return nil
+		})
+	})
+	defer cancel()
+	client.Start()
+
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+	require.Equal(t, "PUT-1", <-signatures)
+	require.Equal(t, "PUT-2", <-signatures)
+}
+
+func TestCorrelationClientRequestSignerError(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.RequestSigner = signerFunc(func(req *http.Request) error {
+			return errors.New("unable to sign")
+		})
+	})
+	defer close(serverCh)
+	defer cancel()
+	client.Start()
+
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+	require.Empty(t, waitForCors(serverCh, 1, 1))
+	require.Equal(t, int64(1), client.TotalDropped(DropCauseInvalidRequest))
+}
+
+func TestCorrelationClientRedirect(t *testing.T) {
+	tokens := make(chan string, 10)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/moved") {
+			http.Redirect(rw, r, "/moved"+r.URL.Path, http.StatusTemporaryRedirect)
+			return
+		}
+		tokens <- r.Header.Get("Authorization")
+	})
+
+	t.Run("follow", func(t *testing.T) {
+		client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+			conf.AccessToken = "abc123"
+			conf.AuthHeader = "Authorization"
+			conf.AuthScheme = "Bearer"
+		})
+		defer cancel()
+		client.Start()
+
+		errs := make(chan error, 1)
+		client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, err error) {
+			errs <- err
+		}))
+		require.Equal(t, "Bearer abc123", <-tokens)
+		require.NoError(t, <-errs)
+	})
+
+	t.Run("none", func(t *testing.T) {
+		client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+			conf.RedirectPolicy = RedirectNone
+		})
+		defer cancel()
+		client.Start()
+
+		errs := make(chan error, 1)
+		client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, err error) {
+			errs <- err
+		}))
+		var reqErr *RequestError
+		require.True(t, errors.As(<-errs, &reqErr))
+		require.Equal(t, http.StatusTemporaryRedirect, reqErr.StatusCode())
+		require.Empty(t, tokens)
+		require.Equal(t, int64(0), atomic.LoadInt64(&client.TotalRetriedUpdates))
+	})
+}
+
+func TestValidateRedirectPolicy(t *testing.T) {
+	require.NoError(t, validateRedirectPolicy(""))
+	require.NoError(t, validateRedirectPolicy(RedirectFollow))
+	require.NoError(t, validateRedirectPolicy(RedirectNone))
+	require.Error(t, validateRedirectPolicy("sometimes"))
+}
+
+func TestRedirectClientWrapsCheckRedirect(t *testing.T) {
+	signer := newHeaderSigner("Authorization", "Bearer", "abc123")
+	original, err := http.NewRequest(http.MethodPut, "http://api.example.com/v2/apm/correlate", nil)
+	require.NoError(t, err)
+	redirected, err := http.NewRequest(http.MethodPut, "http://api.example.com/moved", nil)
+	require.NoError(t, err)
+
+	var checked int
+	stop := errors.New("stop")
+	client := redirectClient(&http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		checked++
+		if len(via) > 1 {
+			return stop
+		}
+		return nil
+	}}, RedirectFollow, signer)
+
+	// the caller's check is consulted before the redirect is signed
+	require.NoError(t, client.CheckRedirect(redirected, []*http.Request{original}))
+	require.Equal(t, 1, checked)
+	require.Equal(t, "Bearer abc123", redirected.Header.Get("Authorization"))
+	require.Equal(t, stop, client.CheckRedirect(redirected, []*http.Request{original, original}))
+
+	// it isn't consulted when redirects aren't followed
+	client = redirectClient(&http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		checked++
+		return nil
+	}}, RedirectNone, signer)
+	require.Equal(t, http.ErrUseLastResponse, client.CheckRedirect(redirected, []*http.Request{original}))
+	require.Equal(t, 2, checked)
+}
+
+type debugLogger struct {
+	log.Logger
+	messages chan string
+}
+
+func (l debugLogger) Debug(msg string) {
+	l.messages <- msg
+}
+
+func (l debugLogger) WithFields(fields log.Fields) log.Logger {
+	return l
+}
+
+func (l debugLogger) WithError(err error) log.Logger {
+	return l
+}
+
+func TestCorrelationClientDebugLogRequests(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.DebugLogRequests = true
+	})
+	defer close(serverCh)
+	defer cancel()
+	messages := make(chan string, 10)
+	client.throttledLog = log.NewThrottledLogger(debugLogger{Logger: log.Nil, messages: messages}, time.Minute)
+	client.Start()
+
+	cor := &Correlation{Type: Service, DimName: "host", DimValue: "test/box", Value: "service a"}
+	client.Correlate(cor, CorrelateCB(func(_ *Correlation, _ error) {}))
+	require.Len(t, waitForCors(serverCh, 1, 3), 1)
+	require.Equal(t, fmt.Sprintf("Sending correlation request PUT %s/v2/apm/correlate/host/test%%2Fbox/service", client.APIURL), <-messages)
+
+	// repeats of the same endpoint are throttled
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test/box", Value: "service b"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+	require.Len(t, waitForCors(serverCh, 1, 3), 1)
+	require.Empty(t, messages)
+}
+
+func TestCorrelationClientInFlight(t *testing.T) {
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	client, cancel := newTestClient(t, handler, nil)
+	defer cancel()
+	client.Start()
+
+	for _, value := range []string{"a", "b", "c"} {
+		client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: value}, CorrelateCB(func(_ *Correlation, _ error) {}))
+	}
+	require.Eventually(t, func() bool { return client.InFlight() == 3 }, 3*time.Second, 10*time.Millisecond)
+
+	close(release)
+	require.Eventually(t, func() bool { return client.InFlight() == 0 }, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestCorrelationClientRetriedDeleteNotFound(t *testing.T) {
+	var attempts int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// the first attempt times out after deleting the correlation
+		if atomic.AddInt64(&attempts, 1) == 1 {
+			<-r.Context().Done()
+			return
+		}
+		rw.WriteHeader(http.StatusNotFound)
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.AttemptTimeout = 100 * time.Millisecond
+	})
+	defer cancel()
+	client.Start()
+
+	deleted := make(chan error, 1)
+	cor := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}
+	client.DeleteMany(context.Background(), []*Correlation{cor}, DeleteManyCB(func(results map[*Correlation]error) {
+		deleted <- results[cor]
+	}))
+	select {
+	case err := <-deleted:
+		require.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("delete did not complete")
+	}
+	require.Equal(t, int64(2), atomic.LoadInt64(&attempts))
+	require.Equal(t, int64(0), atomic.LoadInt64(&client.TotalFailedDeletes))
+
+	// a delete that finds nothing on its first attempt still fails
+	client.DeleteMany(context.Background(), []*Correlation{cor}, DeleteManyCB(func(results map[*Correlation]error) {
+		deleted <- results[cor]
+	}))
+	var reqErr *RequestError
+	require.True(t, errors.As(<-deleted, &reqErr))
+	require.Equal(t, http.StatusNotFound, reqErr.StatusCode())
+	require.Equal(t, int64(1), atomic.LoadInt64(&client.TotalFailedDeletes))
+}
+
+func TestCorrelationClientRetryHooks(t *testing.T) {
+	type event struct {
+		enqueued bool
+		cor      *Correlation
+		attempt  uint32
+	}
+	events := make(chan event, 10)
+	var attempts int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&attempts, 1) < 3 {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.OnRetryEnqueued = func(cor *Correlation, attempt uint32) {
+			events <- event{enqueued: true, cor: cor, attempt: attempt}
+		}
+		conf.OnRetryDequeued = func(cor *Correlation, attempt uint32) {
+			events <- event{cor: cor, attempt: attempt}
+		}
+	})
+	defer cancel()
+	client.Start()
+
+	done := make(chan error, 1)
+	cor := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}
+	client.Correlate(cor, CorrelateCB(func(_ *Correlation, err error) {
+		done <- err
+	}))
+	require.NoError(t, <-done)
+	close(events)
+
+	var got []event
+	for e := range events {
+		got = append(got, e)
+	}
+	require.Equal(t, []event{
+		{enqueued: true, cor: cor, attempt: 1},
+		{cor: cor, attempt: 1},
+		{enqueued: true, cor: cor, attempt: 2},
+		{cor: cor, attempt: 2},
+	}, got)
+}
+
+func TestCorrelationClientLimitsResponseBodies(t *testing.T) {
+	var attempts, bodySize int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&attempts, 1)
+		if r.Method == http.MethodPut {
+			rw.WriteHeader(http.StatusTeapot)
+		}
+		// flush before writing the body so that the response is chunked without a Content-Length
+		rw.(http.Flusher).Flush()
+		_, _ = rw.Write([]byte(`{"sf_services":["` + strings.Repeat("a", int(atomic.LoadInt64(&bodySize))-20) + `"]}`))
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.MaxResponseBodySize = 1024
+	})
+	defer cancel()
+	client.Start()
+
+	// a body of exactly the limit is read
+	atomic.StoreInt64(&bodySize, 1024)
+	correlations, err := client.GetSync(context.Background(), "host", "test-box")
+	require.NoError(t, err)
+	require.Len(t, correlations["sf_services"][0], 1024-20)
+
+	atomic.StoreInt64(&bodySize, 1025)
+	_, err = client.GetSync(context.Background(), "host", "test-box")
+	var tooLarge *requests.ErrResponseTooLarge
+	require.True(t, errors.As(err, &tooLarge))
+	require.Equal(t, int64(1024), tooLarge.Limit)
+	var reqErr *RequestError
+	require.True(t, errors.As(err, &reqErr))
+	require.Equal(t, http.StatusOK, reqErr.StatusCode())
+	require.False(t, reqErr.Retryable())
+	require.Equal(t, int64(2), atomic.LoadInt64(&attempts))
+
+	// the body of a max entries response isn't parsed
+	errs := make(chan error, 1)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, err error) {
+		errs <- err
+	}))
+	err = <-errs
+	require.True(t, errors.As(err, &tooLarge))
+	require.Equal(t, int64(3), atomic.LoadInt64(&attempts))
+}
+
+func TestCorrelationClientLimitsDecompressedBodies(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(rw)
+		_, _ = gz.Write([]byte(`{"sf_services":["` + strings.Repeat("a", 1<<20) + `"]}`))
+		require.NoError(t, gz.Close())
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.MaxResponseBodySize = 1 << 16
+	})
+	defer cancel()
+	client.Start()
+
+	_, err := client.GetSync(context.Background(), "host", "test-box")
+	var tooLarge *requests.ErrResponseTooLarge
+	require.True(t, errors.As(err, &tooLarge))
+}
+
+func TestCorrelationClientRetryCorrelateNotFound(t *testing.T) {
+	for _, retry := range []bool{false, true} {
+		var attempts int64
+		handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			// the route isn't ready for the first attempt
+			if atomic.AddInt64(&attempts, 1) == 1 {
+				rw.WriteHeader(http.StatusNotFound)
+			}
+		})
+		client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+			conf.RetryCorrelateNotFound = retry
+		})
+		client.Start()
+
+		errs := make(chan error, 1)
+		client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, err error) {
+			errs <- err
+		}))
+		err := <-errs
+		if retry {
+			require.NoError(t, err)
+			require.Equal(t, int64(2), atomic.LoadInt64(&attempts))
+		} else {
+			var reqErr *RequestError
+			require.True(t, errors.As(err, &reqErr))
+			require.Equal(t, http.StatusNotFound, reqErr.StatusCode())
+			require.Equal(t, int64(1), atomic.LoadInt64(&attempts))
+		}
+		cancel()
+	}
+}
+
+func TestCorrelationClientGetMaxRetries(t *testing.T) {
+	var gets, puts int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt64(&gets, 1)
+		} else {
+			atomic.AddInt64(&puts, 1)
+		}
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.MaxRetries = 1
+		conf.GetMaxRetries = 3
+	})
+	defer cancel()
+	client.Start()
+
+	// gets are retried until their own limit is exhausted
+	_, err := client.GetSync(context.Background(), "host", "test-box")
+	var reqErr *RequestError
+	require.True(t, errors.As(err, &reqErr))
+	require.Equal(t, http.StatusServiceUnavailable, reqErr.StatusCode())
+	require.Equal(t, int64(5), atomic.LoadInt64(&gets))
+	require.Equal(t, int64(1), client.TotalDropped(DropCauseMaxAttempts))
+
+	// while writes keep the global limit
+	errs := make(chan error, 1)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, err error) {
+		errs <- err
+	}))
+	require.Error(t, <-errs)
+	require.Equal(t, int64(3), atomic.LoadInt64(&puts))
+}
+
+func TestCorrelationClientInformationalResponses(t *testing.T) {
+	t.Run("interim 1xx before the final response", func(t *testing.T) {
+		var attempts int64
+		handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&attempts, 1)
+			rw.WriteHeader(http.StatusEarlyHints)
+			rw.WriteHeader(http.StatusOK)
+		})
+		client, cancel := newTestClient(t, handler, nil)
+		defer cancel()
+		client.Start()
+
+		errs := make(chan error, 1)
+		client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, err error) {
+			errs <- err
+		}))
+		require.NoError(t, <-errs)
+		require.Equal(t, int64(1), atomic.LoadInt64(&attempts))
+		require.Equal(t, int64(0), atomic.LoadInt64(&client.TotalRetriedUpdates))
+	})
+
+	t.Run("final 1xx is retried", func(t *testing.T) {
+		var attempts int64
+		handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt64(&attempts, 1) > 1 {
+				return
+			}
+			// switch protocols even though the request didn't ask to, as a misbehaving proxy might
+			conn, buf, err := rw.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			_, _ = buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+			_ = buf.Flush()
+			conn.Close()
+		})
+		client, cancel := newTestClient(t, handler, nil)
+		defer cancel()
+		client.Start()
+
+		errs := make(chan error, 1)
+		client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, err error) {
+			errs <- err
+		}))
+		require.NoError(t, <-errs)
+		require.Equal(t, int64(2), atomic.LoadInt64(&attempts))
+		require.Equal(t, int64(1), atomic.LoadInt64(&client.TotalRetriedUpdates))
+	})
+}
+
+func TestCorrelationClientMetricSnapshot(t *testing.T) {
+	var puts int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			// the first attempt is retried
+			if atomic.AddInt64(&puts, 1) == 1 {
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			}
+		case http.MethodDelete:
+			rw.WriteHeader(http.StatusBadRequest)
+		}
+	})
+	client, cancel := newTestClient(t, handler, nil)
+	defer cancel()
+	client.Start()
+
+	errs := make(chan error, 1)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, err error) {
+		errs <- err
+	}))
+	require.NoError(t, <-errs)
+	client.DeleteMany(context.Background(), []*Correlation{{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}}, DeleteManyCB(func(results map[*Correlation]error) {
+		for _, err := range results {
+			errs <- err
+		}
+	}))
+	require.Error(t, <-errs)
+	// a get without a dimension value is dropped
+	client.Get("host", "", func(map[string][]string) {})
+
+	snapshot := client.MetricSnapshot()
+	require.Len(t, snapshot, len(Operations()))
+	require.Equal(t, map[RequestResult]int64{
+		ResultSuccess: 1, ResultFailure: 0, ResultClientError: 0, ResultServerError: 0, ResultRetry: 1, ResultDrop: 0,
+	}, snapshot[OperationCorrelate])
+	require.Equal(t, int64(1), snapshot[OperationDelete][ResultClientError])
+	require.Equal(t, int64(1), snapshot[OperationGet][ResultDrop])
+
+	var found bool
+	for _, dp := range client.InternalMetrics() {
+		if dp.Metric == "sfxagent.correlation_requests_completed" && dp.Dimensions["operation"] == "correlate" && dp.Dimensions["result"] == "retry" {
+			require.Equal(t, "1", dp.Value.String())
+			found = true
+		}
+	}
+	require.True(t, found)
+}
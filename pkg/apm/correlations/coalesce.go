@@ -0,0 +1,85 @@
+package correlations
+
+import (
+	"sync"
+)
+
+// coalesceKey identifies the correlates that replace one another when coalescing
+type coalesceKey struct {
+	dimName  string
+	dimValue string
+	typ      Type
+}
+
+// coalescer tracks the correlate that is queued for each dimension and type so that a newer
+// correlate can replace it before it is sent.  Whether a queued correlate has been taken off the
+// queue is tracked under the same lock so that a correlate is never cancelled once it is being
+// sent.
+// this is threadsafe
+type coalescer struct {
+	sync.Mutex
+	pending map[coalesceKey]*request
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{pending: make(map[coalesceKey]*request)}
+}
+
+func coalesceKeyFor(r *request) coalesceKey {
+	return coalesceKey{dimName: r.DimName, dimValue: r.DimValue, typ: r.Type}
+}
+
+// replace records the correlate as the one queued for its dimension and type and returns the
+// correlate it replaces, if any
+func (c *coalescer) replace(r *request) *request {
+	c.Lock()
+	defer c.Unlock()
+	key := coalesceKeyFor(r)
+	stale := c.pending[key]
+	c.pending[key] = r
+	return stale
+}
+
+// restore undoes replace for a correlate that couldn't be queued
+func (c *coalescer) restore(r *request, stale *request) {
+	c.Lock()
+	defer c.Unlock()
+	key := coalesceKeyFor(r)
+	if c.pending[key] != r {
+		return
+	}
+	if stale != nil && !stale.taken {
+		c.pending[key] = stale
+	} else {
+		delete(c.pending, key)
+	}
+}
+
+// cancel cancels the replaced correlate unless it has already been taken off the queue.  It
+// returns whether it was cancelled.
+func (c *coalescer) cancel(stale *request) bool {
+	c.Lock()
+	defer c.Unlock()
+	if stale.taken || stale.ctx.Err() != nil {
+		return false
+	}
+	stale.cancel()
+	return true
+}
+
+// take marks the correlate as taken off the queue, either to be sent or dropped, so that it can no
+// longer be replaced
+func (c *coalescer) take(r *request) {
+	c.Lock()
+	defer c.Unlock()
+	r.taken = true
+	key := coalesceKeyFor(r)
+	if c.pending[key] == r {
+		delete(c.pending, key)
+	}
+}
+
+// coalescing returns whether the request takes part in coalescing.  Deletes are never coalesced.
+func (cc *Client) coalescing(r *request) bool {
+	return cc.coalescer != nil && r.operation == OperationCorrelate
+}
@@ -0,0 +1,47 @@
+package correlations
+
+import (
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelationClientCoalesceCorrelates(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.CoalesceCorrelates = true
+	})
+	defer close(serverCh)
+	defer cancel()
+
+	noop := CorrelateCB(func(_ *Correlation, _ error) {})
+	for _, value := range []string{"v1", "v2", "v3"} {
+		client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: value}, noop)
+	}
+	// other types and dimensions aren't replaced, and neither are deletes
+	client.Correlate(&Correlation{Type: Environment, DimName: "host", DimValue: "test-box", Value: "prod"}, noop)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "other-box", Value: "v1"}, noop)
+	client.Delete(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "old"}, SuccessfulDeleteCB(func(_ *Correlation) {}))
+	client.Start()
+
+	cors := waitForCors(serverCh, 4, 3)
+	var sent []string
+	for _, cor := range cors {
+		sent = append(sent, cor.operation.Method()+" "+cor.DimValue+" "+string(cor.Type)+" "+cor.Value)
+	}
+	sort.Strings(sent)
+	require.Equal(t, []string{
+		http.MethodDelete + " test-box service old",
+		http.MethodPut + " other-box service v1",
+		http.MethodPut + " test-box environment prod",
+		http.MethodPut + " test-box service v3",
+	}, sent)
+	require.Equal(t, int64(2), atomic.LoadInt64(&client.TotalCoalescedRequests))
+
+	// a correlate that has already been sent isn't replaced
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "v4"}, noop)
+	require.Len(t, waitForCors(serverCh, 1, 3), 1)
+	require.Equal(t, int64(2), atomic.LoadInt64(&client.TotalCoalescedRequests))
+}
@@ -0,0 +1,44 @@
+package correlations
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/signalfx/signalfx-agent/pkg/apm/requests"
+)
+
+// decompress returns the body decoded according to its Content-Encoding and counts the bytes
+// that compression saved.  Bodies that aren't encoded are returned as is.  The decoded body is
+// limited to MaxResponseBodySize like the encoded one.
+func (cc *Client) decompress(body []byte, header http.Header) ([]byte, error) {
+	if !strings.EqualFold(header.Get("Content-Encoding"), "gzip") {
+		return body, nil
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var decompressed []byte
+	if limit := cc.maxResponseBodySize; limit > 0 {
+		decompressed, err = ioutil.ReadAll(io.LimitReader(reader, limit+1))
+		if err == nil && int64(len(decompressed)) > limit {
+			err = &requests.ErrResponseTooLarge{Limit: limit}
+		}
+	} else {
+		decompressed, err = ioutil.ReadAll(reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if saved := len(decompressed) - len(body); saved > 0 {
+		atomic.AddInt64(&cc.TotalGetBytesSaved, int64(saved))
+	}
+	return decompressed, nil
+}
@@ -1,9 +1,42 @@
 package correlations
 
 import (
+	"fmt"
+	"net/url"
+	"unicode"
+	"unicode/utf8"
+
 	"github.com/signalfx/signalfx-agent/pkg/apm/log"
 )
 
+// maxPathSegmentLength is the maximum length of an escaped correlation path segment
+const maxPathSegmentLength = 1024
+
+// ErrInvalidCorrelationValue is returned when a correlation contains a value that can not
+// be safely encoded into the correlation endpoint
+type ErrInvalidCorrelationValue struct {
+	// Field is the name of the invalid correlation field
+	Field string
+	// Reason describes why the field is invalid
+	Reason string
+}
+
+func (e *ErrInvalidCorrelationValue) Error() string {
+	return fmt.Sprintf("invalid correlation %s: %s", e.Field, e.Reason)
+}
+
+// Retryable returns false because the value will be invalid every time
+func (e *ErrInvalidCorrelationValue) Retryable() bool {
+	return false
+}
+
+// StatusCode returns 0 because invalid values aren't sent
+func (e *ErrInvalidCorrelationValue) StatusCode() int {
+	return 0
+}
+
+var _ error = (*ErrInvalidCorrelationValue)(nil)
+
 // Type is the type of correlation
 type Type string
 
@@ -27,10 +60,57 @@ type Correlation struct {
 }
 
 func (c *Correlation) Logger(l log.Logger) log.Logger {
-	return l.WithFields(log.Fields{
+	return l.WithFields(c.logFields())
+}
+
+// ThrottledLogger is like Logger for throttled loggers
+func (c *Correlation) ThrottledLogger(l *log.ThrottledLogger) *log.ThrottledLogger {
+	return l.WithFields(c.logFields())
+}
+
+func (c *Correlation) logFields() log.Fields {
+	return log.Fields{
 		"correlation.type":     c.Type,
 		"correlation.dimName":  c.DimName,
 		"correlation.dimValue": c.DimValue,
 		"correlation.value":    c.Value,
-	})
+	}
+}
+
+// validate checks that each of the correlation's fields can be safely used as a path segment
+// of the correlation endpoint.  Empty fields are not considered invalid.
+func (c *Correlation) validate() error {
+	for _, field := range []struct {
+		name  string
+		value string
+	}{
+		{"type", string(c.Type)},
+		{"dimName", c.DimName},
+		{"dimValue", c.DimValue},
+		{"value", c.Value},
+	} {
+		if reason := invalidPathSegmentReason(field.value); reason != "" {
+			return &ErrInvalidCorrelationValue{Field: field.name, Reason: reason}
+		}
+	}
+	return nil
+}
+
+// invalidPathSegmentReason returns the reason the value can't be used as a path segment
+// or an empty string if it can be
+func invalidPathSegmentReason(value string) string {
+	switch {
+	case value == "." || value == "..":
+		return "relative path segments are not allowed"
+	case !utf8.ValidString(value):
+		return "value is not valid utf-8"
+	case len(url.PathEscape(value)) > maxPathSegmentLength:
+		return fmt.Sprintf("escaped value exceeds %d characters", maxPathSegmentLength)
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) {
+			return fmt.Sprintf("value contains control character %q", r)
+		}
+	}
+	return ""
 }
@@ -0,0 +1,53 @@
+package correlations
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelationValidate(t *testing.T) {
+	valid := func() *Correlation {
+		return &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "test-service"}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		modify  func(c *Correlation)
+		field   string
+		invalid bool
+	}{
+		{name: "plain values", modify: func(c *Correlation) {}},
+		{name: "slashes", modify: func(c *Correlation) { c.Value = "a/b/../c" }},
+		{name: "spaces and reserved characters", modify: func(c *Correlation) { c.DimValue = "my box?#%&=" }},
+		{name: "unicode", modify: func(c *Correlation) { c.Value = "サービス" }},
+		{name: "empty value", modify: func(c *Correlation) { c.Value = "" }},
+		{name: "newline", modify: func(c *Correlation) { c.Value = "test\nservice" }, field: "value", invalid: true},
+		{name: "carriage return", modify: func(c *Correlation) { c.DimValue = "test\rbox" }, field: "dimValue", invalid: true},
+		{name: "tab", modify: func(c *Correlation) { c.DimName = "ho\tst" }, field: "dimName", invalid: true},
+		{name: "nul", modify: func(c *Correlation) { c.Value = "test\x00" }, field: "value", invalid: true},
+		{name: "delete", modify: func(c *Correlation) { c.Value = "test\x7f" }, field: "value", invalid: true},
+		{name: "dot", modify: func(c *Correlation) { c.DimValue = "." }, field: "dimValue", invalid: true},
+		{name: "dot dot", modify: func(c *Correlation) { c.Value = ".." }, field: "value", invalid: true},
+		{name: "invalid utf-8", modify: func(c *Correlation) { c.Value = "\xff\xfe" }, field: "value", invalid: true},
+		{name: "invalid type", modify: func(c *Correlation) { c.Type = "serv\nice" }, field: "type", invalid: true},
+		{name: "max length", modify: func(c *Correlation) { c.Value = strings.Repeat("a", maxPathSegmentLength) }},
+		{name: "too long", modify: func(c *Correlation) { c.Value = strings.Repeat("a", maxPathSegmentLength+1) }, field: "value", invalid: true},
+		// each space is escaped to 3 characters which pushes the escaped value over the limit
+		{name: "too long after escaping", modify: func(c *Correlation) { c.Value = strings.Repeat(" ", maxPathSegmentLength/2) }, field: "value", invalid: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			cor := valid()
+			tc.modify(cor)
+			err := cor.validate()
+			if !tc.invalid {
+				require.NoError(t, err)
+				return
+			}
+			require.IsType(t, &ErrInvalidCorrelationValue{}, err)
+			require.Equal(t, tc.field, err.(*ErrInvalidCorrelationValue).Field)
+		})
+	}
+}
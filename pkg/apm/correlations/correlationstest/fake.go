@@ -0,0 +1,163 @@
+package correlationstest
+
+import (
+	"sync"
+
+	"github.com/signalfx/golib/v3/datapoint"
+
+	"github.com/signalfx/signalfx-agent/pkg/apm/correlations"
+)
+
+// Call is a request made against a FakeClient.  Its callback is invoked by completing it with
+// Succeed, Fail or Respond.
+type Call struct {
+	Operation   correlations.Operation
+	Correlation *correlations.Correlation
+	Options     []correlations.RequestOptions
+
+	correlateCB correlations.CorrelateCB
+	deleteCB    correlations.SuccessfulDeleteCB
+	getCB       correlations.SuccessfulGetCB
+}
+
+// Succeed invokes the callback of a Correlate or Delete call as if the request succeeded.  The
+// callback of a Get call is invoked with no correlations.
+func (c *Call) Succeed() {
+	switch c.Operation {
+	case correlations.OperationCorrelate:
+		c.correlateCB(c.Correlation, nil)
+	case correlations.OperationDelete:
+		c.deleteCB(c.Correlation)
+	case correlations.OperationGet:
+		c.getCB(map[string][]string{})
+	}
+}
+
+// Fail completes the call as if the request failed with the error.  Only Correlate callbacks are
+// invoked on failure, matching the real client.
+func (c *Call) Fail(err error) {
+	if c.Operation == correlations.OperationCorrelate {
+		c.correlateCB(c.Correlation, err)
+	}
+}
+
+// Respond invokes the callback of a Get call with the correlations
+func (c *Call) Respond(cors map[string][]string) {
+	if c.Operation == correlations.OperationGet {
+		c.getCB(cors)
+	}
+}
+
+// FakeClient is a CorrelationClient that records the requests made against it instead of sending
+// them.  By default callbacks are only invoked when the test completes a call, see AutoSucceed and
+// GetResponses to have them invoked as the calls are made.
+type FakeClient struct {
+	// AutoSucceed makes Correlate and Delete calls succeed as soon as they are made
+	AutoSucceed bool
+	// GetResponses are the correlations Get calls are immediately responded to with, keyed by
+	// dimension value.  Get calls for other dimension values are only recorded.
+	GetResponses map[string]map[string][]string
+
+	mu      sync.Mutex
+	calls   []*Call
+	started bool
+}
+
+var _ correlations.CorrelationClient = &FakeClient{}
+
+// NewFakeClient returns a FakeClient that doesn't invoke any callbacks until calls are completed
+func NewFakeClient() *FakeClient {
+	return &FakeClient{}
+}
+
+func (f *FakeClient) record(call *Call) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+}
+
+// Correlate records the call
+func (f *FakeClient) Correlate(cor *correlations.Correlation, cb correlations.CorrelateCB, opts ...correlations.RequestOptions) {
+	call := &Call{Operation: correlations.OperationCorrelate, Correlation: cor, Options: opts, correlateCB: cb}
+	f.record(call)
+	if f.AutoSucceed {
+		call.Succeed()
+	}
+}
+
+// Delete records the call
+func (f *FakeClient) Delete(cor *correlations.Correlation, cb correlations.SuccessfulDeleteCB, opts ...correlations.RequestOptions) {
+	call := &Call{Operation: correlations.OperationDelete, Correlation: cor, Options: opts, deleteCB: cb}
+	f.record(call)
+	if f.AutoSucceed {
+		call.Succeed()
+	}
+}
+
+// Get records the call
+func (f *FakeClient) Get(dimName string, dimValue string, cb correlations.SuccessfulGetCB) {
+	call := &Call{
+		Operation:   correlations.OperationGet,
+		Correlation: &correlations.Correlation{DimName: dimName, DimValue: dimValue},
+		getCB:       cb,
+	}
+	f.record(call)
+	if response, ok := f.GetResponses[dimValue]; ok {
+		call.Respond(response)
+	}
+}
+
+// InternalMetrics returns no datapoints
+func (f *FakeClient) InternalMetrics() []*datapoint.Datapoint {
+	return nil
+}
+
+// Start marks the client as started
+func (f *FakeClient) Start() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.started = true
+}
+
+// Started returns whether Start has been called
+func (f *FakeClient) Started() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.started
+}
+
+// Calls returns every call made in the order they were made
+func (f *FakeClient) Calls() []*Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*Call(nil), f.calls...)
+}
+
+// CallsFor returns the calls made for the operation in the order they were made
+func (f *FakeClient) CallsFor(op correlations.Operation) []*Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []*Call
+	for _, call := range f.calls {
+		if call.Operation == op {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Correlations returns the correlations sent for the operation in the order they were sent
+func (f *FakeClient) Correlations(op correlations.Operation) []*correlations.Correlation {
+	var cors []*correlations.Correlation
+	for _, call := range f.CallsFor(op) {
+		cors = append(cors, call.Correlation)
+	}
+	return cors
+}
+
+// Reset forgets every call made so far
+func (f *FakeClient) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = nil
+}
@@ -0,0 +1,37 @@
+package correlationstest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/signalfx/signalfx-agent/pkg/apm/correlations"
+)
+
+func TestFakeClient(t *testing.T) {
+	f := &FakeClient{GetResponses: map[string]map[string][]string{"test-box": {"sf_services": {"one"}}}}
+	f.Start()
+	require.True(t, f.Started())
+
+	cor := &correlations.Correlation{Type: correlations.Service, DimName: "host", DimValue: "test-box", Value: "one"}
+	var correlateErr error
+	f.Correlate(cor, func(_ *correlations.Correlation, err error) { correlateErr = err })
+	var deleted *correlations.Correlation
+	f.Delete(cor, func(cor *correlations.Correlation) { deleted = cor })
+	var got map[string][]string
+	f.Get("host", "test-box", func(cors map[string][]string) { got = cors })
+
+	require.Equal(t, map[string][]string{"sf_services": {"one"}}, got)
+	require.Nil(t, deleted)
+
+	f.CallsFor(correlations.OperationCorrelate)[0].Fail(errors.New("failed"))
+	require.EqualError(t, correlateErr, "failed")
+	f.CallsFor(correlations.OperationDelete)[0].Succeed()
+	require.Equal(t, cor, deleted)
+
+	require.Len(t, f.Calls(), 3)
+	require.Equal(t, []*correlations.Correlation{cor}, f.Correlations(correlations.OperationCorrelate))
+	f.Reset()
+	require.Empty(t, f.Calls())
+}
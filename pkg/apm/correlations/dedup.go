@@ -2,18 +2,69 @@ package correlations
 
 import (
 	"container/list"
-	"net/http"
+	"errors"
+	"sync"
+	"sync/atomic"
 )
 
+// dedupEntryOverhead is the approximate number of bytes used by a deduplicator entry excluding the
+// contents of the correlation's strings.  It accounts for the list element, the map entry and its
+// key, and the request.
+const dedupEntryOverhead = 256
+
 // deduplicator deduplicates requests and cancels pending conflicting requests and deduplicates
-// this is not threadsafe
+// this is threadsafe
+//
+// Requests are keyed by their operation and the whole correlation: its Type, DimName, DimValue and
+// Value.  Correlates and deletes are indexed separately, so a request is only ever a duplicate of
+// a pending request for the same operation and correlation.  A correlate and a delete for the same
+// correlation are opposites rather than duplicates: neither is deduplicated, instead the later
+// request cancels the earlier one if it is still pending.  Sending both could let them complete
+// out of order and leave the opposite of the latest request in effect.  Gets aren't deduplicated.
 type deduplicator struct {
+	// guards the lists and maps, which are otherwise only used by processChan, against forget
+	sync.Mutex
 	// maps for deduplicating requests
 	maxSize           int
 	pendingCreates    *list.List
 	pendingCreateKeys map[Correlation]*list.Element
 	pendingDeletes    *list.List
 	pendingDeleteKeys map[Correlation]*list.Element
+
+	// running totals of the entries and their approximate size so that reporting is cheap
+	entries     int64
+	approxBytes int64
+}
+
+// approxEntryBytes estimates the memory used by a deduplicator entry for the correlation
+func approxEntryBytes(cor *Correlation) int64 {
+	return int64(dedupEntryOverhead + len(cor.Type) + len(cor.DimName) + len(cor.DimValue) + len(cor.Value))
+}
+
+// insert adds the request to the front of the list and indexes it by its correlation
+func (d *deduplicator) insert(l *list.List, keys map[Correlation]*list.Element, r *request) {
+	// replace any existing entry so that the list and map stay in sync
+	if existing, ok := keys[*r.Correlation]; ok {
+		d.remove(l, keys, existing)
+	}
+	keys[*r.Correlation] = l.PushFront(r)
+	atomic.AddInt64(&d.entries, 1)
+	atomic.AddInt64(&d.approxBytes, approxEntryBytes(r.Correlation))
+}
+
+// remove removes the element from the list and its correlation from the index
+func (d *deduplicator) remove(l *list.List, keys map[Correlation]*list.Element, elem *list.Element) {
+	req := elem.Value.(*request)
+	l.Remove(elem)
+	delete(keys, *req.Correlation)
+	atomic.AddInt64(&d.entries, -1)
+	atomic.AddInt64(&d.approxBytes, -approxEntryBytes(req.Correlation))
+}
+
+// size returns the number of entries in the deduplicator and their approximate size in bytes.
+// It is safe to call concurrently with other methods.
+func (d *deduplicator) size() (entries int64, approxBytes int64) {
+	return atomic.LoadInt64(&d.entries), atomic.LoadInt64(&d.approxBytes)
 }
 
 func (d *deduplicator) purgeCreates() {
@@ -25,8 +76,7 @@ func (d *deduplicator) purgeCreates() {
 		if elem.Value.(*request).ctx.Err() != nil {
 			toDelete := elem
 			elem = elem.Next()
-			d.pendingCreates.Remove(toDelete)
-			delete(d.pendingCreateKeys, *toDelete.Value.(*request).Correlation)
+			d.remove(d.pendingCreates, d.pendingCreateKeys, toDelete)
 		} else {
 			elem = elem.Next()
 		}
@@ -42,8 +92,7 @@ func (d *deduplicator) purgeDeletes() {
 		if elem.Value.(*request).ctx.Err() != nil {
 			toDelete := elem
 			elem = elem.Next()
-			d.pendingDeletes.Remove(toDelete)
-			delete(d.pendingDeleteKeys, *toDelete.Value.(*request).Correlation)
+			d.remove(d.pendingDeletes, d.pendingDeleteKeys, toDelete)
 		} else {
 			elem = elem.Next()
 		}
@@ -51,6 +100,8 @@ func (d *deduplicator) purgeDeletes() {
 }
 
 func (d *deduplicator) purge() {
+	d.Lock()
+	defer d.Unlock()
 	d.purgeCreates()
 	d.purgeDeletes()
 }
@@ -61,8 +112,7 @@ func (d *deduplicator) evictPendingDelete() {
 		req, ok := elem.Value.(*request)
 		if ok {
 			req.cancel()
-			d.pendingDeletes.Remove(elem)
-			delete(d.pendingDeleteKeys, *req.Correlation)
+			d.remove(d.pendingDeletes, d.pendingDeleteKeys, elem)
 		}
 	}
 }
@@ -73,9 +123,7 @@ func (d *deduplicator) evictPendingCreate() {
 		req, ok := elem.Value.(*request)
 		if ok {
 			req.cancel()
-			d.pendingCreates.Remove(elem)
-			delete(d.pendingCreateKeys, *req.Correlation)
-
+			d.remove(d.pendingCreates, d.pendingCreateKeys, elem)
 		}
 	}
 }
@@ -94,15 +142,13 @@ func (d *deduplicator) dedupCorrelate(r *request) bool {
 	}
 
 	// insert the request into the pendingCreates
-	elem := d.pendingCreates.PushFront(r)
-	d.pendingCreateKeys[*r.Correlation] = elem
+	d.insert(d.pendingCreates, d.pendingCreateKeys, r)
 
 	// cancel any pending delete operations
 	deleteElem, pendindgDelete := d.pendingDeleteKeys[*r.Correlation]
 	if pendindgDelete {
 		deleteElem.Value.(*request).cancel()
-		d.pendingDeletes.Remove(deleteElem)
-		delete(d.pendingDeleteKeys, *deleteElem.Value.(*request).Correlation)
+		d.remove(d.pendingDeletes, d.pendingDeleteKeys, deleteElem)
 	}
 
 	return false
@@ -122,32 +168,95 @@ func (d *deduplicator) dedupDelete(r *request) bool {
 	}
 
 	// insert the request into the pendingDeletes
-	elem := d.pendingDeletes.PushFront(r)
-	d.pendingDeleteKeys[*r.Correlation] = elem
+	d.insert(d.pendingDeletes, d.pendingDeleteKeys, r)
 
 	// cancel any pending create operations
 	createElem, pendindgCreate := d.pendingCreateKeys[*r.Correlation]
 	if pendindgCreate {
 		createElem.Value.(*request).cancel()
-		d.pendingCreates.Remove(createElem)
-		delete(d.pendingCreateKeys, *createElem.Value.(*request).Correlation)
+		d.remove(d.pendingCreates, d.pendingCreateKeys, createElem)
 	}
 
 	return false
 }
 
+// collapse returns true if the request is a correlate that cancels out a held delete for the same
+// correlation.  The held delete is cancelled and the caller should drop the correlate.
+func (d *deduplicator) collapse(r *request) bool {
+	if r.operation != OperationCorrelate {
+		return false
+	}
+	d.Lock()
+	defer d.Unlock()
+	deleteElem, ok := d.pendingDeleteKeys[*r.Correlation]
+	if !ok {
+		return false
+	}
+	pendingDelete := deleteElem.Value.(*request)
+	if !pendingDelete.held || pendingDelete.ctx.Err() != nil {
+		return false
+	}
+	pendingDelete.cancel()
+	d.remove(d.pendingDeletes, d.pendingDeleteKeys, deleteElem)
+	return true
+}
+
 // isDup returns true if the request is a duplicate
 func (d *deduplicator) isDup(r *request) (isDup bool) {
+	d.Lock()
+	defer d.Unlock()
 	switch r.operation {
-	case http.MethodPut:
+	case OperationCorrelate:
 		return d.dedupCorrelate(r)
-	case http.MethodDelete:
+	case OperationDelete:
 		return d.dedupDelete(r)
 	default:
 		return
 	}
 }
 
+func validateDedupMaxEntries(entries int) error {
+	if entries < 0 {
+		return errors.New("correlation dedup max entries must be positive")
+	}
+	return nil
+}
+
+// dedupSize returns the capacity of the deduplicator, which defaults to the buffer size
+func dedupSize(conf Config) int {
+	if conf.DedupMaxEntries > 0 {
+		return conf.DedupMaxEntries
+	}
+	return int(conf.MaxBuffered)
+}
+
+// forget removes the pending correlate and delete of the correlation so that the next identical
+// request isn't a duplicate.  The pending requests themselves are still sent.  It returns whether
+// there was an entry to remove.
+func (d *deduplicator) forget(cor *Correlation) bool {
+	d.Lock()
+	defer d.Unlock()
+	var forgot bool
+	if elem, ok := d.pendingCreateKeys[*cor]; ok {
+		d.remove(d.pendingCreates, d.pendingCreateKeys, elem)
+		forgot = true
+	}
+	if elem, ok := d.pendingDeleteKeys[*cor]; ok {
+		d.remove(d.pendingDeletes, d.pendingDeleteKeys, elem)
+		forgot = true
+	}
+	return forgot
+}
+
+// ForgetDedup forgets the pending Correlate and Delete of the correlation so that the next
+// identical request is sent even if they are still pending or were recently sent according to the
+// dedup state, e.g. to reconcile a correlation that was changed out of band.  The pending requests aren't cancelled.  It is safe to call at any
+// time.
+func (cc *Client) ForgetDedup(cor *Correlation) {
+	cc.dedup.forget(cor)
+	cc.sentDedup.forget(cor)
+}
+
 // newDeduplicator returns a new instance
 func newDeduplicator(size int) *deduplicator {
 	return &deduplicator{
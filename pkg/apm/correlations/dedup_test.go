@@ -0,0 +1,201 @@
+package correlations
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRequest(op Operation, cor *Correlation) *request {
+	r := &request{Correlation: cor, operation: op}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	return r
+}
+
+func TestValidateDedupMaxEntries(t *testing.T) {
+	require.NoError(t, validateDedupMaxEntries(0))
+	require.NoError(t, validateDedupMaxEntries(100))
+	require.Error(t, validateDedupMaxEntries(-1))
+}
+
+func TestDeduplicatorSize(t *testing.T) {
+	d := newDeduplicator(10)
+	entries, approxBytes := d.size()
+	require.Zero(t, entries)
+	require.Zero(t, approxBytes)
+
+	service := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "test-service"}
+	environment := &Correlation{Type: Environment, DimName: "host", DimValue: "test-box", Value: "test-environment"}
+
+	createService := newTestRequest(OperationCorrelate, service)
+	require.False(t, d.isDup(createService))
+	require.False(t, d.isDup(newTestRequest(OperationCorrelate, environment)))
+	// duplicates are not tracked twice
+	require.True(t, d.isDup(newTestRequest(OperationCorrelate, service)))
+
+	entries, approxBytes = d.size()
+	require.Equal(t, int64(2), entries)
+	require.Equal(t, approxEntryBytes(service)+approxEntryBytes(environment), approxBytes)
+
+	// a delete replaces the conflicting create
+	require.False(t, d.isDup(newTestRequest(OperationDelete, service)))
+	entries, approxBytes = d.size()
+	require.Equal(t, int64(2), entries)
+	require.Equal(t, approxEntryBytes(service)+approxEntryBytes(environment), approxBytes)
+	require.Error(t, createService.ctx.Err(), "conflicting create should be cancelled")
+
+	// completed requests are released when purged
+	for elem := d.pendingCreates.Front(); elem != nil; elem = elem.Next() {
+		elem.Value.(*request).cancel()
+	}
+	for elem := d.pendingDeletes.Front(); elem != nil; elem = elem.Next() {
+		elem.Value.(*request).cancel()
+	}
+	d.purge()
+	entries, approxBytes = d.size()
+	require.Zero(t, entries)
+	require.Zero(t, approxBytes)
+}
+
+func TestDeduplicatorCollapse(t *testing.T) {
+	d := newDeduplicator(10)
+	service := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "test-service"}
+
+	// a delete that has already been sent can't be collapsed
+	sent := newTestRequest(OperationDelete, service)
+	require.False(t, d.isDup(sent))
+	require.False(t, d.collapse(newTestRequest(OperationCorrelate, service)))
+	sent.cancel()
+
+	held := newTestRequest(OperationDelete, service)
+	held.held = true
+	require.False(t, d.isDup(held))
+	// a correlate for a different value doesn't collapse the delete
+	require.False(t, d.collapse(newTestRequest(OperationCorrelate, &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "other-service"})))
+	require.NoError(t, held.ctx.Err())
+
+	require.True(t, d.collapse(newTestRequest(OperationCorrelate, service)))
+	require.Error(t, held.ctx.Err(), "held delete should be cancelled")
+	entries, _ := d.size()
+	require.Zero(t, entries)
+}
+
+func TestDeduplicatorKeyIncludesOperation(t *testing.T) {
+	d := newDeduplicator(10)
+	service := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "test-service"}
+
+	// identical correlates are duplicates
+	correlate := newTestRequest(OperationCorrelate, service)
+	require.False(t, d.isDup(correlate))
+	require.True(t, d.isDup(newTestRequest(OperationCorrelate, &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "test-service"})))
+
+	// a delete of the same correlation isn't a duplicate of the correlate, it supersedes it
+	del := newTestRequest(OperationDelete, service)
+	require.False(t, d.isDup(del))
+	require.Error(t, correlate.ctx.Err())
+	require.NoError(t, del.ctx.Err())
+
+	// and a later correlate isn't a duplicate of the delete
+	recorrelate := newTestRequest(OperationCorrelate, service)
+	require.False(t, d.isDup(recorrelate))
+	require.Error(t, del.ctx.Err())
+	require.NoError(t, recorrelate.ctx.Err())
+
+	// once a request completes an identical one proceeds
+	recorrelate.cancel()
+	require.False(t, d.isDup(newTestRequest(OperationCorrelate, service)))
+
+	// every field of the correlation is part of the key
+	for _, cor := range []*Correlation{
+		{Type: Environment, DimName: "host", DimValue: "test-box", Value: "test-service"},
+		{Type: Service, DimName: "container_id", DimValue: "test-box", Value: "test-service"},
+		{Type: Service, DimName: "host", DimValue: "other-box", Value: "test-service"},
+		{Type: Service, DimName: "host", DimValue: "test-box", Value: "other-service"},
+	} {
+		require.False(t, d.isDup(newTestRequest(OperationCorrelate, cor)))
+		require.False(t, d.isDup(newTestRequest(OperationDelete, cor)))
+	}
+
+	// gets are never duplicates
+	require.False(t, d.isDup(newTestRequest(OperationGet, &Correlation{DimName: "host", DimValue: "test-box"})))
+	require.False(t, d.isDup(newTestRequest(OperationGet, &Correlation{DimName: "host", DimValue: "test-box"})))
+}
+
+func TestDedupSize(t *testing.T) {
+	require.Equal(t, 10, dedupSize(Config{MaxBuffered: 10}))
+	require.Equal(t, 1000, dedupSize(Config{MaxBuffered: 10, DedupMaxEntries: 1000}))
+
+	// the deduplicator is sized independently of the buffers
+	client, cancel := newTestClient(t, http.NotFoundHandler(), func(conf *ClientConfig) {
+		conf.DedupMaxEntries = 2
+	})
+	defer cancel()
+	require.Equal(t, 2, client.dedup.maxSize)
+	require.Equal(t, 10, cap(client.requestChan))
+}
+
+func TestDeduplicatorForget(t *testing.T) {
+	d := newDeduplicator(10)
+	service := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "test-service"}
+
+	pending := newTestRequest(OperationCorrelate, service)
+	require.False(t, d.isDup(pending))
+	require.True(t, d.isDup(newTestRequest(OperationCorrelate, service)))
+
+	require.True(t, d.forget(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "test-service"}))
+	require.False(t, d.forget(service), "already forgotten")
+	require.NoError(t, pending.ctx.Err(), "the pending request isn't cancelled")
+	require.False(t, d.isDup(newTestRequest(OperationCorrelate, service)))
+
+	// it is safe to forget while requests are being deduplicated
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			d.isDup(newTestRequest(OperationCorrelate, service))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			d.forget(service)
+		}
+	}()
+	wg.Wait()
+}
+
+func TestCorrelationClientForgetDedup(t *testing.T) {
+	release := make(chan struct{})
+	var received int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// hold the first request so that it stays pending
+		if atomic.AddInt64(&received, 1) == 1 {
+			<-release
+		}
+	})
+	deduplicated := make(chan *Correlation, 1)
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.OnDeduplicated = func(cor *Correlation) { deduplicated <- cor }
+	})
+	defer cancel()
+	defer close(release)
+	client.Start()
+
+	service := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "test-service"}
+	client.Correlate(service, nil)
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&received) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	client.Correlate(service, nil)
+	require.Equal(t, service, <-deduplicated)
+
+	client.ForgetDedup(service)
+	client.Correlate(service, nil)
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&received) == 2 }, 5*time.Second, 10*time.Millisecond)
+	require.Empty(t, deduplicated)
+}
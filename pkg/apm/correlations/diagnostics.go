@@ -1,6 +1,8 @@
 package correlations
 
 import (
+	"sync/atomic"
+
 	"github.com/signalfx/golib/v3/datapoint"
 	"github.com/signalfx/golib/v3/sfxclient"
 )
@@ -10,9 +12,161 @@ import (
 func (cc *Client) InternalMetrics() []*datapoint.Datapoint {
 	dps := []*datapoint.Datapoint{
 		sfxclient.CumulativeP("sfxagent.correlation_updates_invalid", nil, &cc.TotalInvalidDimensions),
+		sfxclient.CumulativeP("sfxagent.correlation_updates_invalid_values", nil, &cc.TotalInvalidValues),
 		// All 4xx HTTP responses that are not retried except 404 (which is retried)
 		sfxclient.CumulativeP("sfxagent.correlation_updates_client_errors", nil, &cc.TotalClientError4xxResponses),
 		sfxclient.CumulativeP("sfxagent.correlation_updates_retries", nil, &cc.TotalRetriedUpdates),
+		sfxclient.CumulativeP("sfxagent.correlation_deletes_failed", nil, &cc.TotalFailedDeletes),
+		sfxclient.CumulativeP("sfxagent.correlation_updates_callback_panics", nil, &cc.TotalCallbackPanics),
+		sfxclient.CumulativeP("sfxagent.correlation_updates_evicted", nil, &cc.TotalEvictedRequests),
+		sfxclient.CumulativeP("sfxagent.correlation_updates_collapsed", nil, &cc.TotalCollapsedRequests),
+		sfxclient.CumulativeP("sfxagent.correlation_updates_coalesced", nil, &cc.TotalCoalescedRequests),
+		sfxclient.CumulativeP("sfxagent.correlation_updates_aged", nil, &cc.TotalAgedRequests),
+		sfxclient.CumulativeP("sfxagent.correlation_updates_url_too_long", nil, &cc.TotalURLTooLong),
+		sfxclient.CumulativeP("sfxagent.correlation_retries_flushed", nil, &cc.TotalFlushedRetries),
+		sfxclient.CumulativeP("sfxagent.correlation_retries_succeeded", nil, &cc.TotalRetrySucceeded),
+		sfxclient.CumulativeP("sfxagent.correlation_retries_exhausted", nil, &cc.TotalRetryExhausted),
+		sfxclient.CumulativeP("sfxagent.correlation_outcomes_dropped", nil, &cc.TotalDroppedOutcomes),
+		sfxclient.CumulativeP("sfxagent.correlation_get_bytes_saved", nil, &cc.TotalGetBytesSaved),
+		sfxclient.CumulativeP("sfxagent.correlation_request_bytes", nil, &cc.TotalRequestBytes),
+		sfxclient.CumulativeP("sfxagent.correlation_response_bytes", nil, &cc.TotalResponseBytes),
+		sfxclient.CumulativeP("sfxagent.correlation_negative_cache_hits", nil, &cc.TotalNegativeCacheHits),
+	}
+	dps = append(dps, cc.dropMetrics()...)
+	dps = append(dps, cc.completedMetrics()...)
+	dps = append(dps, cc.errorClassMetrics()...)
+	dps = append(dps, cc.requestAge.datapoints("sfxagent.correlation_request_age_seconds")...)
+	dps = append(dps, cc.sources.datapoints()...)
+	dedupEntries, dedupBytes := cc.dedup.size()
+	bodyPoolHits, bodyPoolMisses := cc.bodies.stats()
+	dps = append(dps,
+		sfxclient.Cumulative("sfxagent.correlation_body_pool_hits", nil, bodyPoolHits),
+		sfxclient.Cumulative("sfxagent.correlation_body_pool_misses", nil, bodyPoolMisses),
+		sfxclient.GaugeF("sfxagent.correlation_retry_queue_ema", nil, cc.RetryQueueEMA()),
+		sfxclient.GaugeF("sfxagent.correlation_retry_success_rate", nil, cc.RetrySuccessRate()),
+		sfxclient.GaugeF("sfxagent.correlation_request_latency_ema_seconds", nil, cc.LatencyEMA().Seconds()),
+		sfxclient.Gauge("sfxagent.correlation_queued_bytes", nil, cc.QueuedBytes()),
+		sfxclient.Gauge("sfxagent.correlation_requests_in_flight", nil, cc.InFlight()),
+		sfxclient.Gauge("sfxagent.correlation_retries_in_flight", nil, cc.RetriesInFlight()),
+		sfxclient.Gauge("sfxagent.correlation_negative_cache_entries", nil, int64(cc.NegativeCacheLen())),
+		sfxclient.Gauge("sfxagent.correlation_overflow_buffered", nil, int64(len(cc.overflowChan))),
+		sfxclient.Gauge("sfxagent.correlation_dedup_entries", nil, dedupEntries),
+		sfxclient.Gauge("sfxagent.correlation_dedup_approx_bytes", nil, dedupBytes),
+		sfxclient.CumulativeP("sfxagent.correlation_dedup_saved_requests", nil, &cc.totalDedupSaved),
+		sfxclient.CumulativeP("sfxagent.correlation_dedup_saved_bytes", nil, &cc.totalDedupSavedBytes),
+		sfxclient.Gauge("sfxagent.correlation_requests_active", nil, int64(cc.registry.len())),
+		sfxclient.CumulativeP("sfxagent.correlation_requests_untracked", nil, &cc.registry.totalUntracked),
+	)
+	if cc.hedger != nil {
+		dps = append(dps,
+			sfxclient.CumulativeP("sfxagent.correlation_gets_hedged", nil, &cc.TotalHedgedRequests),
+			sfxclient.CumulativeP("sfxagent.correlation_gets_hedge_wins", nil, &cc.TotalHedgeWins),
+		)
+	}
+	if cc.cappedDimensions != nil {
+		dps = append(dps, sfxclient.Gauge("sfxagent.correlation_dimensions_at_max_entries", nil, int64(cc.cappedDimensions.len())))
+	}
+	if cc.connTrace != nil {
+		dps = append(dps,
+			sfxclient.CumulativeP("sfxagent.correlation_connections_reused", nil, &cc.TotalConnReused),
+			sfxclient.CumulativeP("sfxagent.correlation_connections_new", nil, &cc.TotalConnNew),
+		)
+	}
+	if cc.dimensionCounts != nil {
+		counts, other := cc.dimensionCounts.snapshot()
+		for _, c := range counts {
+			dps = append(dps, sfxclient.Cumulative("sfxagent.correlation_updates_by_dimension", map[string]string{"dimName": c.Key}, c.Count))
+		}
+		dps = append(dps, sfxclient.Cumulative("sfxagent.correlation_updates_by_dimension", map[string]string{"dimName": otherKey}, other))
+	}
+	// a shared sender's metrics are reported by its owner
+	if cc.sharedSender {
+		return dps
 	}
 	return append(dps, cc.requestSender.InternalMetrics()...)
 }
+
+// ResetStats sets every counter of the client to zero so that a fresh window can be measured.  It
+// is safe to call while requests are being processed, but a request completing during the reset
+// may be counted in either window.  This is meant for tests and rare operational use; reported
+// metrics are cumulative and consumers should compute deltas rather than reset them.
+func (cc *Client) ResetStats() {
+	for _, counter := range []*int64{
+		&cc.TotalClientError4xxResponses,
+		&cc.TotalRetriedUpdates,
+		&cc.TotalInvalidDimensions,
+		&cc.TotalCallbackPanics,
+		&cc.TotalInvalidValues,
+		&cc.TotalConnReused,
+		&cc.TotalConnNew,
+		&cc.TotalFailedDeletes,
+		&cc.TotalEvictedRequests,
+		&cc.TotalHedgedRequests,
+		&cc.TotalHedgeWins,
+		&cc.TotalCollapsedRequests,
+		&cc.TotalGetBytesSaved,
+		&cc.TotalNegativeCacheHits,
+		&cc.TotalCoalescedRequests,
+		&cc.TotalURLTooLong,
+		&cc.TotalFlushedRetries,
+		&cc.TotalAgedRequests,
+		&cc.TotalRetrySucceeded,
+		&cc.TotalRetryExhausted,
+		&cc.TotalDroppedOutcomes,
+		&cc.TotalRequestBytes,
+		&cc.TotalResponseBytes,
+		&cc.totalDedupSaved,
+		&cc.totalDedupSavedBytes,
+	} {
+		atomic.StoreInt64(counter, 0)
+	}
+	for i := range cc.totalDropped {
+		atomic.StoreInt64(&cc.totalDropped[i], 0)
+	}
+	for i := range cc.totalErrorsByClass {
+		atomic.StoreInt64(&cc.totalErrorsByClass[i], 0)
+	}
+	for op := range cc.totalCompleted {
+		for result := range cc.totalCompleted[op] {
+			atomic.StoreInt64(&cc.totalCompleted[op][result], 0)
+		}
+	}
+	cc.requestAge.reset()
+	cc.sources.reset()
+	if cc.dimensionCounts != nil {
+		cc.dimensionCounts.reset()
+	}
+}
+
+// TopDimensions returns up to n dimension names with the most requests, ordered from most to
+// fewest.  It returns nil unless MaxTrackedDimensions is configured.
+func (cc *Client) TopDimensions(n int) []KeyCount {
+	if cc.dimensionCounts == nil {
+		return nil
+	}
+	counts, _ := cc.dimensionCounts.snapshot()
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// DedupEntries returns the number of requests currently tracked by the deduplicator
+func (cc *Client) DedupEntries() int64 {
+	entries, _ := cc.dedup.size()
+	return entries
+}
+
+// DedupSavings returns the number of requests that weren't sent because they were duplicates of
+// a pending request, and an estimate of the bytes that would have been sent for them based on the
+// length of their dimension, type and value.
+func (cc *Client) DedupSavings() (count int64, bytes int64) {
+	return atomic.LoadInt64(&cc.totalDedupSaved), atomic.LoadInt64(&cc.totalDedupSavedBytes)
+}
+
+// DedupApproxMemoryBytes returns an estimate of the memory used by the deduplicator in bytes.  The
+// estimate is maintained as entries are added and removed, so it is cheap to call.
+func (cc *Client) DedupApproxMemoryBytes() int64 {
+	_, approxBytes := cc.dedup.size()
+	return approxBytes
+}
@@ -0,0 +1,48 @@
+package correlations
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// dimensionFilter determines which dimension names may be correlated
+type dimensionFilter struct {
+	allowed         map[string]bool
+	caseInsensitive bool
+}
+
+// newDimensionFilter returns a filter that only permits the allowed dimension names.  It returns
+// nil if none are given so that every dimension name is permitted.
+func newDimensionFilter(allowed []string, caseInsensitive bool) (*dimensionFilter, error) {
+	if len(allowed) == 0 {
+		return nil, nil
+	}
+
+	f := dimensionFilter{allowed: make(map[string]bool, len(allowed)), caseInsensitive: caseInsensitive}
+	for _, name := range allowed {
+		if name == "" {
+			return nil, errors.New("allowed correlation dimension names may not be empty")
+		}
+		if reason := invalidPathSegmentReason(name); reason != "" {
+			return nil, fmt.Errorf("invalid allowed correlation dimension name %q: %s", name, reason)
+		}
+		f.allowed[f.key(name)] = true
+	}
+	return &f, nil
+}
+
+func (f *dimensionFilter) key(name string) string {
+	if f.caseInsensitive {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// permits returns whether the dimension name may be correlated.  A nil filter permits every name.
+func (f *dimensionFilter) permits(name string) bool {
+	if f == nil {
+		return true
+	}
+	return f.allowed[f.key(name)]
+}
@@ -0,0 +1,31 @@
+package correlations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDimensionFilter(t *testing.T) {
+	f, err := newDimensionFilter(nil, false)
+	require.NoError(t, err)
+	require.True(t, f.permits("anything"))
+
+	f, err = newDimensionFilter([]string{"host", "kubernetes_pod_uid"}, false)
+	require.NoError(t, err)
+	require.True(t, f.permits("host"))
+	require.True(t, f.permits("kubernetes_pod_uid"))
+	require.False(t, f.permits("Host"))
+	require.False(t, f.permits("container_id"))
+
+	f, err = newDimensionFilter([]string{"Host"}, true)
+	require.NoError(t, err)
+	require.True(t, f.permits("host"))
+	require.True(t, f.permits("HOST"))
+	require.False(t, f.permits("container_id"))
+
+	_, err = newDimensionFilter([]string{"host", ""}, false)
+	require.Error(t, err)
+	_, err = newDimensionFilter([]string{".."}, false)
+	require.Error(t, err)
+}
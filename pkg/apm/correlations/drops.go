@@ -0,0 +1,174 @@
+package correlations
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/signalfx/golib/v3/datapoint"
+	"github.com/signalfx/golib/v3/sfxclient"
+)
+
+// DropCause is the reason a request was dropped before it completed
+type DropCause uint8
+
+const (
+	// DropCauseChannelFull is a request rejected because the request channel was full
+	DropCauseChannelFull DropCause = iota
+	// DropCauseRetryChannelFull is a failed request that couldn't be retried because the retry
+	// channel was full
+	DropCauseRetryChannelFull
+	// DropCauseMaxAttempts is a failed request that has used up its retries
+	DropCauseMaxAttempts
+	// DropCauseCancelled is a request that was cancelled while waiting to be retried
+	DropCauseCancelled
+	// DropCauseEvicted is a queued request evicted to make room under the DropOldest policy
+	DropCauseEvicted
+	// DropCauseInvalidDimension is a request without a dimension name or value
+	DropCauseInvalidDimension
+	// DropCauseInvalidValue is a request with a value that can't be encoded into the endpoint
+	DropCauseInvalidValue
+	// DropCauseInvalidRequest is a request that an http request couldn't be built for
+	DropCauseInvalidRequest
+	// DropCauseShutdown is a request dropped because the client is shutting down
+	DropCauseShutdown
+	// DropCauseFilteredType is a request for a correlation type that isn't permitted
+	DropCauseFilteredType
+	// DropCauseMaxQueuedBytes is a request rejected because queued requests were using up the
+	// memory budget
+	DropCauseMaxQueuedBytes
+	// DropCauseShed is a normal priority update dropped because the agent was under pressure
+	DropCauseShed
+	// DropCauseBudgetExceeded is a failed request that couldn't be retried within its budget
+	DropCauseBudgetExceeded
+	// DropCauseFilteredDimension is a request for a dimension name that isn't allowed
+	DropCauseFilteredDimension
+	// DropCauseURLTooLong is a request with an endpoint url longer than the maximum
+	DropCauseURLTooLong
+	// DropCausePaused is a request rejected because the client was paused
+	DropCausePaused
+
+	numDropCauses
+)
+
+// DropCauses returns every cause a request can be dropped for
+func DropCauses() []DropCause {
+	causes := make([]DropCause, 0, numDropCauses)
+	for c := DropCause(0); c < numDropCauses; c++ {
+		causes = append(causes, c)
+	}
+	return causes
+}
+
+func (c DropCause) String() string {
+	switch c {
+	case DropCauseChannelFull:
+		return "channel_full"
+	case DropCauseRetryChannelFull:
+		return "retry_channel_full"
+	case DropCauseMaxAttempts:
+		return "max_attempts"
+	case DropCauseCancelled:
+		return "cancelled"
+	case DropCauseEvicted:
+		return "evicted"
+	case DropCauseInvalidDimension:
+		return "invalid_dimension"
+	case DropCauseInvalidValue:
+		return "invalid_value"
+	case DropCauseInvalidRequest:
+		return "invalid_request"
+	case DropCauseShutdown:
+		return "shutdown"
+	case DropCauseFilteredType:
+		return "filtered_type"
+	case DropCauseMaxQueuedBytes:
+		return "max_queued_bytes"
+	case DropCauseShed:
+		return "shed"
+	case DropCauseBudgetExceeded:
+		return "budget_exceeded"
+	case DropCauseFilteredDimension:
+		return "filtered_dimension"
+	case DropCauseURLTooLong:
+		return "url_too_long"
+	case DropCausePaused:
+		return "paused"
+	default:
+		return "unknown"
+	}
+}
+
+// dropCauseForErr returns the cause for an error returned when enqueuing or retrying a request
+func dropCauseForErr(err error) (DropCause, bool) {
+	var dropped *DroppedError
+	if errors.As(err, &dropped) {
+		return dropped.Cause, true
+	}
+	return 0, false
+}
+
+// droppedErrorFor returns the error a request rejected with err is failed with, a DroppedError
+// wrapping err for rejections that aren't reported as one
+func droppedErrorFor(err error) error {
+	var (
+		invalid *ErrInvalidCorrelationValue
+		tooLong *ErrURLTooLong
+	)
+	switch {
+	case isDropped(err):
+		return err
+	case errors.As(err, &invalid):
+		return &DroppedError{Cause: DropCauseInvalidValue, msg: "request dropped: " + err.Error(), err: err}
+	case errors.As(err, &tooLong):
+		return &DroppedError{Cause: DropCauseURLTooLong, msg: "request dropped: " + err.Error(), err: err}
+	default:
+		return err
+	}
+}
+
+// isDropped returns whether the error is for a request that was dropped rather than one that the
+// endpoint failed
+func isDropped(err error) bool {
+	_, ok := dropCauseForErr(err)
+	return ok
+}
+
+// recordDrop counts a request dropped for the cause and reports it as the request's outcome
+func (cc *Client) recordDrop(r *request, cause DropCause) {
+	cc.countDrop(r, cause)
+	cc.emitOutcome(r, 0, &DroppedError{Cause: cause, msg: "request dropped: " + cause.String()}, attemptsMade(r))
+}
+
+// countDrop counts a request dropped for the cause without reporting an outcome, for a drop that
+// is followed by the request failing
+func (cc *Client) countDrop(r *request, cause DropCause) {
+	if cause < numDropCauses {
+		atomic.AddInt64(&cc.totalDropped[cause], int64(1))
+	}
+	cc.recordResult(r.operation, ResultDrop)
+	cc.observer.Dropped(r.Correlation, r.operation, cause)
+}
+
+// recordDropForErr counts a request dropped with the error, if the error is a known drop cause
+func (cc *Client) recordDropForErr(r *request, err error) {
+	if cause, ok := dropCauseForErr(err); ok {
+		cc.recordDrop(r, cause)
+	}
+}
+
+// TotalDropped returns the number of requests dropped for the cause
+func (cc *Client) TotalDropped(cause DropCause) int64 {
+	if cause >= numDropCauses {
+		return 0
+	}
+	return atomic.LoadInt64(&cc.totalDropped[cause])
+}
+
+// dropMetrics returns a cumulative counter of dropped requests for each cause
+func (cc *Client) dropMetrics() []*datapoint.Datapoint {
+	dps := make([]*datapoint.Datapoint, 0, numDropCauses)
+	for _, cause := range DropCauses() {
+		dps = append(dps, sfxclient.CumulativeP("sfxagent.correlation_requests_dropped", map[string]string{"cause": cause.String()}, &cc.totalDropped[cause]))
+	}
+	return dps
+}
@@ -0,0 +1,107 @@
+package correlations
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelationClientCorrelateReportsDrops(t *testing.T) {
+	valid := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}
+	for _, tc := range []struct {
+		name      string
+		configure func(conf *ClientConfig)
+		// prepare is called with the client before the correlation is made
+		prepare func(client *Client, cancel func())
+		cor     *Correlation
+		cause   DropCause
+	}{
+		{
+			name:  "invalid dimension",
+			cor:   &Correlation{Type: Service, DimName: "host", Value: "service"},
+			cause: DropCauseInvalidDimension,
+		},
+		{
+			name:  "invalid value",
+			cor:   &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "test\nservice"},
+			cause: DropCauseInvalidValue,
+		},
+		{
+			name:      "url too long",
+			configure: func(conf *ClientConfig) { conf.MaxURLLength = 10 },
+			cause:     DropCauseURLTooLong,
+		},
+		{
+			name:      "filtered dimension",
+			configure: func(conf *ClientConfig) { conf.AllowedDimensions = []string{"container_id"} },
+			cause:     DropCauseFilteredDimension,
+		},
+		{
+			name:      "filtered type",
+			configure: func(conf *ClientConfig) { conf.AllowedTypes = []Type{Environment} },
+			cause:     DropCauseFilteredType,
+		},
+		{
+			name: "shed",
+			configure: func(conf *ClientConfig) {
+				conf.ShedThreshold = 1
+				conf.Pressure = func() float64 { return 2 }
+			},
+			cause: DropCauseShed,
+		},
+		{
+			name:    "paused",
+			prepare: func(client *Client, _ func()) { client.Pause(true) },
+			cause:   DropCausePaused,
+		},
+		{
+			name:      "max queued bytes",
+			configure: func(conf *ClientConfig) { conf.MaxQueuedBytes = 1 },
+			cause:     DropCauseMaxQueuedBytes,
+		},
+		{
+			name:      "channel full",
+			configure: func(conf *ClientConfig) { conf.MaxBuffered = 1 },
+			prepare: func(client *Client, _ func()) {
+				client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "queued"}, func(*Correlation, error) {})
+			},
+			cause: DropCauseChannelFull,
+		},
+		{
+			name:      "shutdown",
+			configure: func(conf *ClientConfig) { conf.MaxBuffered = 1 },
+			prepare: func(client *Client, cancel func()) {
+				client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "queued"}, func(*Correlation, error) {})
+				cancel()
+			},
+			cause: DropCauseShutdown,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {})
+			// the client isn't started so that queued requests stay queued
+			client, cancel := newTestClient(t, handler, tc.configure)
+			defer cancel()
+			if tc.prepare != nil {
+				tc.prepare(client, cancel)
+			}
+			cor := tc.cor
+			if cor == nil {
+				cor = valid
+			}
+
+			errs := make(chan error, 1)
+			client.Correlate(cor, func(_ *Correlation, err error) { errs <- err })
+			var err error
+			select {
+			case err = <-errs:
+			default:
+				t.Fatal("the callback wasn't invoked")
+			}
+			var dropped *DroppedError
+			require.ErrorAs(t, err, &dropped)
+			require.Equal(t, tc.cause, dropped.Cause)
+		})
+	}
+}
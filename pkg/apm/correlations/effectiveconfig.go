@@ -0,0 +1,115 @@
+package correlations
+
+import (
+	"reflect"
+	"strings"
+)
+
+// redacted replaces secrets in the effective config
+const redacted = "<redacted>"
+
+// EffectiveConfig returns the configuration the client is using, keyed by the same names as the
+// Config fields are decoded from.  Defaults are filled in for fields that were left unset and
+// fields changed by Reconfigure have their current values.  It also includes the url, the
+// maximum number of attempts of each request and whether an access token is set, but never the
+// token itself.  It is safe to call at any time and changes to the returned map don't affect the
+// client.
+func (cc *Client) EffectiveConfig() map[string]interface{} {
+	cc.RLock()
+	conf := cc.conf
+	maxAttempts := cc.maxAttempts
+	cc.RUnlock()
+
+	effective := configMap(conf)
+
+	// the defaults that are applied to unset fields
+	effective["put_content_type"] = cc.putContentType
+	effective["drop_policy"] = DropNewest
+	if cc.dropOldest {
+		effective["drop_policy"] = DropOldest
+	}
+	if conf.BackoffStrategy == "" {
+		effective["backoff_strategy"] = BackoffConstant
+	}
+	if conf.RetryLogVerbosity == "" {
+		effective["retry_log_verbosity"] = RetryLogAll
+	}
+	if conf.RedirectPolicy == "" {
+		effective["redirect_policy"] = RedirectFollow
+	}
+	if conf.AuthHeader == "" {
+		effective["auth_header"] = defaultAuthHeader
+	}
+	if conf.HealthFailureThreshold == 0 {
+		effective["health_failure_threshold"] = uint(defaultHealthFailureThreshold)
+	}
+	effective["dedup_max_entries"] = cc.dedup.maxSize
+	if conf.DedupStateWindow == 0 {
+		effective["dedup_state_window"] = defaultDedupStateWindow
+	}
+	if conf.DedupStateMaxEntries == 0 {
+		effective["dedup_state_max_entries"] = defaultDedupStateMaxEntries
+	}
+	effective["max_entries_status_code"] = cc.maxEntriesStatus
+	if conf.GetMaxRetries == 0 {
+		effective["get_max_retries"] = conf.MaxRetries
+	}
+	if conf.GetRetryDelay == 0 {
+		effective["get_retry_delay"] = conf.RetryDelay
+	}
+	effective["retry"] = effectiveRetry(conf)
+	effective["ttl_fallback"] = cc.ttlFallback
+	effective["emit_interval"] = cc.emitInterval
+	effective["enqueue_retry_delay"] = cc.enqueueRetryDelay
+	effective["retry_queue_ema_decay"] = cc.retryQueueEMA.decay
+	effective["latency_ema_decay"] = cc.latencyEMA.decay
+	effective["max_pooled_body_size"] = uint(cc.bodies.maxSize)
+	effective["max_tracked_sources"] = uint(cc.sources.requests.limit)
+
+	// settings from outside of the Config
+	effective["url"] = ""
+	if cc.APIURL != nil {
+		effective["url"] = cc.APIURL.String()
+	}
+	effective["access_token"] = ""
+	if cc.Token != "" {
+		effective["access_token"] = redacted
+	}
+	effective["max_attempts"] = maxAttempts
+	return effective
+}
+
+// configMap returns the fields of the config keyed by their mapstructure names.  Slices and maps
+// are copied so that the config can't be changed through them.
+func configMap(conf Config) map[string]interface{} {
+	v := reflect.ValueOf(conf)
+	t := v.Type()
+	m := make(map[string]interface{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("mapstructure"), ",")[0]
+		if name == "" {
+			continue
+		}
+		m[name] = copyValue(v.Field(i)).Interface()
+	}
+	return m
+}
+
+// copyValue returns a shallow copy of slices and maps, and other values as is
+func copyValue(v reflect.Value) reflect.Value {
+	switch {
+	case v.Kind() == reflect.Slice && !v.IsNil():
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(c, v)
+		return c
+	case v.Kind() == reflect.Map && !v.IsNil():
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			c.SetMapIndex(iter.Key(), iter.Value())
+		}
+		return c
+	default:
+		return v
+	}
+}
@@ -0,0 +1,59 @@
+package correlations
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelationClientEffectiveConfig(t *testing.T) {
+	client, cancel := newTestClient(t, http.NotFoundHandler(), func(conf *ClientConfig) {
+		conf.AccessToken = "secret"
+		conf.AllowedDimensions = []string{"host"}
+		conf.DropPolicy = DropOldest
+	})
+	defer cancel()
+
+	effective := client.EffectiveConfig()
+	// every config field is included
+	for i := 0; i < reflect.TypeOf(Config{}).NumField(); i++ {
+		require.Contains(t, effective, reflect.TypeOf(Config{}).Field(i).Tag.Get("mapstructure"))
+	}
+	require.Equal(t, uint(10), effective["max_buffered"])
+	require.Equal(t, uint32(5), effective["max_attempts"])
+	require.Equal(t, DropOldest, effective["drop_policy"])
+	require.Equal(t, []string{"host"}, effective["allowed_dimensions"])
+	require.Equal(t, client.APIURL.String(), effective["url"])
+
+	// defaults are filled in
+	require.Equal(t, defaultPutContentType, effective["put_content_type"])
+	require.Equal(t, BackoffConstant, effective["backoff_strategy"])
+	require.Equal(t, RetryLogAll, effective["retry_log_verbosity"])
+	require.Equal(t, 10, effective["dedup_max_entries"])
+	require.Equal(t, http.StatusTeapot, effective["max_entries_status_code"])
+	require.Equal(t, RedirectFollow, effective["redirect_policy"])
+	require.Equal(t, TTLFallbackDelete, effective["ttl_fallback"])
+	require.Equal(t, defaultEmitInterval, effective["emit_interval"])
+	require.Equal(t, defaultRetryQueueEMADecay, effective["retry_queue_ema_decay"])
+	require.Equal(t, uint(defaultMaxTrackedSources), effective["max_tracked_sources"])
+	require.Equal(t, defaultAuthHeader, effective["auth_header"])
+
+	// the token is never exposed
+	require.Equal(t, redacted, effective["access_token"])
+	for _, v := range effective {
+		require.NotEqual(t, "secret", v)
+	}
+
+	// the client's config can't be changed through the map
+	effective["allowed_dimensions"].([]string)[0] = "container_id"
+	require.Equal(t, []string{"host"}, client.EffectiveConfig()["allowed_dimensions"])
+
+	// reconfigured values are reported
+	conf := client.conf
+	conf.RetryDelay = time.Minute
+	require.NoError(t, client.Reconfigure(conf))
+	require.Equal(t, time.Minute, client.EffectiveConfig()["retry_delay"])
+}
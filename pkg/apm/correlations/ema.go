@@ -0,0 +1,58 @@
+package correlations
+
+import (
+	"fmt"
+	"sync"
+)
+
+// defaultRetryQueueEMADecay is the weight given to each new retry queue occupancy sample when no
+// decay is configured
+const defaultRetryQueueEMADecay = 0.1
+
+// movingAverage is an exponentially weighted moving average
+// this is threadsafe
+type movingAverage struct {
+	sync.Mutex
+	// decay is the weight given to each new sample, between 0 and 1
+	decay float64
+	value float64
+	// startFromFirst makes the first sample the initial average rather than averaging it with 0
+	startFromFirst bool
+	samples        int64
+}
+
+func newMovingAverage(decay float64) (*movingAverage, error) {
+	if decay == 0 {
+		decay = defaultRetryQueueEMADecay
+	}
+	if decay < 0 || decay > 1 {
+		return nil, fmt.Errorf("moving average decay must be between 0 and 1, got %v", decay)
+	}
+	return &movingAverage{decay: decay}, nil
+}
+
+// update adds a sample to the average
+func (m *movingAverage) update(sample float64) {
+	m.Lock()
+	defer m.Unlock()
+	if m.startFromFirst && m.samples == 0 {
+		m.value = sample
+	} else {
+		m.value = m.decay*sample + (1-m.decay)*m.value
+	}
+	m.samples++
+}
+
+// get returns the current average
+func (m *movingAverage) get() float64 {
+	m.Lock()
+	defer m.Unlock()
+	return m.value
+}
+
+// sampled returns the current average and whether there have been any samples
+func (m *movingAverage) sampled() (float64, bool) {
+	m.Lock()
+	defer m.Unlock()
+	return m.value, m.samples > 0
+}
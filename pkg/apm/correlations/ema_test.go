@@ -0,0 +1,38 @@
+package correlations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMovingAverage(t *testing.T) {
+	m, err := newMovingAverage(0.5)
+	require.NoError(t, err)
+	m.update(4)
+	require.Equal(t, 2.0, m.get())
+	m.update(4)
+	require.Equal(t, 3.0, m.get())
+	m.update(0)
+	require.Equal(t, 1.5, m.get())
+
+	m, err = newMovingAverage(0)
+	require.NoError(t, err)
+	require.Equal(t, defaultRetryQueueEMADecay, m.decay)
+
+	_, err = newMovingAverage(1.5)
+	require.Error(t, err)
+}
+
+func TestMovingAverageStartFromFirst(t *testing.T) {
+	m, err := newLatencyEMA(0.5)
+	require.NoError(t, err)
+	_, sampled := m.sampled()
+	require.False(t, sampled)
+	m.update(4)
+	value, sampled := m.sampled()
+	require.True(t, sampled)
+	require.Equal(t, 4.0, value)
+	m.update(2)
+	require.Equal(t, 3.0, m.get())
+}
@@ -0,0 +1,132 @@
+package correlations
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/signalfx/golib/v3/datapoint"
+	"github.com/signalfx/golib/v3/sfxclient"
+)
+
+// defaultEmitInterval is how often internal metrics are emitted when an Emitter is configured
+// without an interval
+const defaultEmitInterval = 10 * time.Second
+
+// Emitter receives the client's internal metrics, e.g. to send them through the agent's datapoint
+// pipeline
+type Emitter interface {
+	Emit(dps []*datapoint.Datapoint)
+}
+
+// RequestResult is an outcome of a request that is counted for each operation
+type RequestResult uint8
+
+const (
+	// ResultSuccess is a request that succeeded
+	ResultSuccess RequestResult = iota
+	// ResultFailure is a request that failed without a 4xx or 5xx response, e.g. because the
+	// endpoint couldn't be reached
+	ResultFailure
+	// ResultClientError is a request that failed with a 4xx response
+	ResultClientError
+	// ResultServerError is a request that failed with a 5xx response
+	ResultServerError
+	// ResultRetry is a failed attempt of a request that was queued to be retried.  Each retry is
+	// counted, so a request can have several.
+	ResultRetry
+	// ResultDrop is a request that was dropped before it completed.  A request that failed and
+	// then couldn't be retried is counted both for its last response and as a drop.
+	ResultDrop
+
+	numRequestResults
+)
+
+// RequestResults returns every result that requests are counted for
+func RequestResults() []RequestResult {
+	results := make([]RequestResult, 0, numRequestResults)
+	for r := RequestResult(0); r < numRequestResults; r++ {
+		results = append(results, r)
+	}
+	return results
+}
+
+func (r RequestResult) String() string {
+	switch r {
+	case ResultSuccess:
+		return "success"
+	case ResultFailure:
+		return "failure"
+	case ResultClientError:
+		return "4xx"
+	case ResultServerError:
+		return "5xx"
+	case ResultRetry:
+		return "retry"
+	case ResultDrop:
+		return "drop"
+	default:
+		return "unknown"
+	}
+}
+
+// failureResult returns the result for a request that failed with the status code
+func failureResult(statusCode int) RequestResult {
+	switch {
+	case statusCode >= 400 && statusCode < 500:
+		return ResultClientError
+	case statusCode >= 500:
+		return ResultServerError
+	default:
+		return ResultFailure
+	}
+}
+
+// recordResult counts a request with the result
+func (cc *Client) recordResult(op Operation, result RequestResult) {
+	if int(op) < len(cc.totalCompleted) && result < numRequestResults {
+		atomic.AddInt64(&cc.totalCompleted[op][result], int64(1))
+	}
+}
+
+// MetricSnapshot returns the number of requests of each operation that had each result.  Every
+// operation and result is included, even if no requests had it.
+func (cc *Client) MetricSnapshot() map[Operation]map[RequestResult]int64 {
+	snapshot := make(map[Operation]map[RequestResult]int64, len(Operations()))
+	for _, op := range Operations() {
+		results := make(map[RequestResult]int64, numRequestResults)
+		for _, result := range RequestResults() {
+			results[result] = atomic.LoadInt64(&cc.totalCompleted[op][result])
+		}
+		snapshot[op] = results
+	}
+	return snapshot
+}
+
+// completedMetrics returns a cumulative counter of requests for each operation and result
+func (cc *Client) completedMetrics() []*datapoint.Datapoint {
+	dps := make([]*datapoint.Datapoint, 0, len(Operations())*int(numRequestResults))
+	for _, op := range Operations() {
+		for _, result := range RequestResults() {
+			dps = append(dps, sfxclient.CumulativeP("sfxagent.correlation_requests_completed", map[string]string{
+				"operation": op.String(),
+				"result":    result.String(),
+			}, &cc.totalCompleted[op][result]))
+		}
+	}
+	return dps
+}
+
+// emitMetrics is a routine that periodically sends the client's internal metrics to the emitter
+func (cc *Client) emitMetrics() {
+	defer cc.wg.Done()
+	ticker := time.NewTicker(cc.emitInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cc.ctx.Done():
+			return
+		case <-ticker.C:
+			cc.emitter.Emit(cc.InternalMetrics())
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package correlations
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/signalfx/golib/v3/datapoint"
+	"github.com/signalfx/golib/v3/sfxclient"
+)
+
+// errorClass is the kind of failure a request that didn't receive a response failed with
+type errorClass uint8
+
+const (
+	// errorClassOther is any failure that isn't classified more specifically
+	errorClassOther errorClass = iota
+	// errorClassTimeout is a request that timed out
+	errorClassTimeout
+	// errorClassConnection is a connection that was refused or reset, which usually means the
+	// endpoint is down
+	errorClassConnection
+	// errorClassDNS is a failure to resolve the endpoint's host
+	errorClassDNS
+
+	numErrorClasses
+)
+
+func (c errorClass) String() string {
+	switch c {
+	case errorClassTimeout:
+		return "timeout"
+	case errorClassConnection:
+		return "connection"
+	case errorClassDNS:
+		return "dns"
+	default:
+		return "other"
+	}
+}
+
+// classifyError returns the class of the error a request failed with
+func classifyError(err error) errorClass {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return errorClassDNS
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return errorClassTimeout
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return errorClassConnection
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return errorClassConnection
+	}
+	return errorClassOther
+}
+
+// backoffMultiplier returns how much the retry delay is multiplied by for the class
+func (cc *Client) backoffMultiplier(class errorClass) float64 {
+	cc.RLock()
+	defer cc.RUnlock()
+	var multiplier float64
+	switch class {
+	case errorClassTimeout:
+		multiplier = cc.conf.TimeoutBackoffMultiplier
+	case errorClassConnection:
+		multiplier = cc.conf.ConnectionBackoffMultiplier
+	case errorClassDNS:
+		multiplier = cc.conf.DNSBackoffMultiplier
+	}
+	if multiplier <= 0 {
+		return 1
+	}
+	return multiplier
+}
+
+// recordErrorClass counts a request that failed without a response
+func (cc *Client) recordErrorClass(class errorClass) {
+	atomic.AddInt64(&cc.totalErrorsByClass[class], int64(1))
+}
+
+// TotalErrors returns the number of requests that failed without a response with an error of the
+// class, which is one of "timeout", "connection", "dns" or "other"
+func (cc *Client) TotalErrors(class string) int64 {
+	for c := errorClass(0); c < numErrorClasses; c++ {
+		if c.String() == class {
+			return atomic.LoadInt64(&cc.totalErrorsByClass[c])
+		}
+	}
+	return 0
+}
+
+// errorClassMetrics returns a cumulative counter of requests that failed without a response for
+// each class of error
+func (cc *Client) errorClassMetrics() []*datapoint.Datapoint {
+	dps := make([]*datapoint.Datapoint, 0, numErrorClasses)
+	for c := errorClass(0); c < numErrorClasses; c++ {
+		dps = append(dps, sfxclient.CumulativeP("sfxagent.correlation_request_errors", map[string]string{"class": c.String()}, &cc.totalErrorsByClass[c]))
+	}
+	return dps
+}
@@ -0,0 +1,32 @@
+package correlations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}}
+	for err, class := range map[error]errorClass{
+		fmt.Errorf("error making HTTP request: %w", &net.DNSError{Err: "no such host", Name: "ingest.example.com"}): errorClassDNS,
+		fmt.Errorf("error making HTTP request: %w", timeoutError{}):                                                 errorClassTimeout,
+		fmt.Errorf("error making HTTP request: %w", refused):                                                        errorClassConnection,
+		fmt.Errorf("error making HTTP request: %w", context.Canceled):                                               errorClassOther,
+		errors.New("unexpected status code 503"):                                                                    errorClassOther,
+	} {
+		require.Equal(t, class, classifyError(err), err.Error())
+	}
+}
@@ -0,0 +1,132 @@
+package correlations
+
+import (
+	"context"
+	"errors"
+
+	"github.com/signalfx/signalfx-agent/pkg/apm/requests"
+)
+
+// CorrelationError is implemented by the errors returned by the client and passed to its callbacks
+// so that callers can handle failures without matching on error strings
+type CorrelationError interface {
+	error
+	// Retryable returns whether making the same request again could succeed
+	Retryable() bool
+	// StatusCode returns the http status code of the response the error is for, or 0 if there
+	// was no response
+	StatusCode() int
+}
+
+var (
+	_ CorrelationError = (*DroppedError)(nil)
+	_ CorrelationError = (*RequestError)(nil)
+	_ CorrelationError = (*ErrMaxEntries)(nil)
+	_ CorrelationError = (*ErrInvalidCorrelationValue)(nil)
+	_ CorrelationError = (*ErrURLTooLong)(nil)
+)
+
+// The errors for requests that are dropped.  They are kept as sentinels so that they can still be
+// compared against directly.
+var (
+	ErrChFull            error = &DroppedError{Cause: DropCauseChannelFull, msg: "request channel full"}
+	errRetryChFull       error = &DroppedError{Cause: DropCauseRetryChannelFull, msg: "retry channel full"}
+	errMaxAttempts       error = &DroppedError{Cause: DropCauseMaxAttempts, msg: "maximum attempts exceeded"}
+	errRequestCancelled  error = &DroppedError{Cause: DropCauseCancelled, msg: "request cancelled"}
+	errCoalesced         error = &DroppedError{Cause: DropCauseCancelled, msg: "request replaced by a newer correlate"}
+	errEvicted           error = &DroppedError{Cause: DropCauseEvicted, msg: "request evicted to make room"}
+	errInvalidDimension  error = &DroppedError{Cause: DropCauseInvalidDimension, msg: "no dimension key or value"}
+	errFilteredType      error = &DroppedError{Cause: DropCauseFilteredType, msg: "correlation type is filtered"}
+	errShutdown          error = &DroppedError{Cause: DropCauseShutdown, msg: "client is shutting down", err: context.DeadlineExceeded}
+	errMaxQueuedBytes    error = &DroppedError{Cause: DropCauseMaxQueuedBytes, msg: "maximum queued bytes exceeded"}
+	errBudgetExceeded    error = &DroppedError{Cause: DropCauseBudgetExceeded, msg: "request budget exceeded", err: context.DeadlineExceeded}
+	errFilteredDimension error = &DroppedError{Cause: DropCauseFilteredDimension, msg: "dimension name is not allowed"}
+	// ErrPaused is the error for a request rejected because the client was paused with rejectNew
+	ErrPaused error = &DroppedError{Cause: DropCausePaused, msg: "client is paused"}
+	// ErrShed is the error for a normal priority update dropped because the agent was under
+	// pressure
+	ErrShed error = &DroppedError{Cause: DropCauseShed, msg: "request shed under pressure"}
+)
+
+// DroppedError is the error for a request that was dropped before it completed
+type DroppedError struct {
+	Cause DropCause
+	msg   string
+	err   error
+}
+
+func (e *DroppedError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the underlying error, if any
+func (e *DroppedError) Unwrap() error {
+	return e.err
+}
+
+// Retryable returns true if the request was only dropped because the client was too busy
+func (e *DroppedError) Retryable() bool {
+	return e.Cause == DropCauseChannelFull || e.Cause == DropCauseRetryChannelFull || e.Cause == DropCauseMaxQueuedBytes || e.Cause == DropCausePaused
+}
+
+// StatusCode returns 0 because dropped requests have no response
+func (e *DroppedError) StatusCode() int {
+	return 0
+}
+
+// RequestError is the error for a request that couldn't reach the correlation endpoint or that
+// the endpoint responded to with an error
+type RequestError struct {
+	Operation Operation
+	// Status is the http status code of the response, 0 if there was no response
+	Status int
+	Err    error
+	// decided is true if the client set retryable from its retry settings when it made the error
+	decided   bool
+	retryable bool
+}
+
+// requestError returns the error for a request that failed with the status code, retryable if the
+// client would have retried it
+func (cc *Client) requestError(op Operation, statusCode int, err error) *RequestError {
+	return &RequestError{
+		Operation: op,
+		Status:    statusCode,
+		Err:       err,
+		decided:   true,
+		retryable: !isResponseTooLarge(err) && cc.shouldRetry(op, statusCode),
+	}
+}
+
+func (e *RequestError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable returns whether the client retries a request that fails like this one, which depends on
+// its retry settings such as RetryCorrelateNotFound.  Otherwise it returns false for 3xx and 4xx
+// responses and responses that are too large, which won't be remedied by retrying.
+func (e *RequestError) Retryable() bool {
+	if e.decided {
+		return e.retryable
+	}
+	if isResponseTooLarge(e.Err) {
+		return false
+	}
+	return retryableStatus(e.Status)
+}
+
+// StatusCode returns the http status code of the response, 0 if there was no response
+func (e *RequestError) StatusCode() int {
+	return e.Status
+}
+
+// isResponseTooLarge returns whether the error is for a response with a body over the limit
+func isResponseTooLarge(err error) bool {
+	var tooLarge *requests.ErrResponseTooLarge
+	return errors.As(err, &tooLarge)
+}
@@ -0,0 +1,50 @@
+package correlations
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/signalfx/signalfx-agent/pkg/apm/requests"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelationErrors(t *testing.T) {
+	for _, tc := range []struct {
+		err        error
+		retryable  bool
+		statusCode int
+	}{
+		{err: ErrChFull, retryable: true},
+		{err: errMaxAttempts},
+		{err: errBudgetExceeded},
+		{err: &RequestError{Status: http.StatusServiceUnavailable, Err: errors.New("unavailable")}, retryable: true, statusCode: http.StatusServiceUnavailable},
+		{err: &RequestError{Status: http.StatusBadRequest, Err: errors.New("bad request")}, statusCode: http.StatusBadRequest},
+		{err: &RequestError{Status: http.StatusTemporaryRedirect, Err: errors.New("redirected")}, statusCode: http.StatusTemporaryRedirect},
+		{err: &RequestError{Err: errors.New("connection refused")}, retryable: true},
+		{err: &ErrMaxEntries{MaxEntries: 10}, statusCode: http.StatusTeapot},
+		{err: &ErrInvalidCorrelationValue{Field: "value", Reason: "empty"}},
+	} {
+		var corErr CorrelationError
+		require.True(t, errors.As(tc.err, &corErr), tc.err.Error())
+		require.Equal(t, tc.retryable, corErr.Retryable(), tc.err.Error())
+		require.Equal(t, tc.statusCode, corErr.StatusCode(), tc.err.Error())
+	}
+
+	// the shutdown error still matches the error it replaced
+	require.True(t, errors.Is(errShutdown, context.DeadlineExceeded))
+}
+
+func TestRequestErrorRetryableFollowsRetrySettings(t *testing.T) {
+	cc := &Client{retryNotFound: true, maxEntriesStatus: http.StatusServiceUnavailable}
+	require.True(t, cc.requestError(OperationCorrelate, http.StatusNotFound, errors.New("not found")).Retryable())
+	require.False(t, cc.requestError(OperationDelete, http.StatusNotFound, errors.New("not found")).Retryable())
+	// the maximum entries status isn't retried for correlates
+	require.False(t, cc.requestError(OperationCorrelate, http.StatusServiceUnavailable, errors.New("full")).Retryable())
+	require.True(t, cc.requestError(OperationDelete, http.StatusServiceUnavailable, errors.New("unavailable")).Retryable())
+	require.False(t, cc.requestError(OperationGet, http.StatusOK, &requests.ErrResponseTooLarge{Limit: 1}).Retryable())
+
+	cc.conf.Retry.Delete.Disabled = true
+	require.False(t, cc.requestError(OperationDelete, http.StatusServiceUnavailable, errors.New("unavailable")).Retryable())
+}
@@ -0,0 +1,30 @@
+package correlations
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// PathEscaper escapes a value so that it can be used as a segment of the correlation endpoint's
+// path
+type PathEscaper func(segment string) string
+
+// endpoint returns the url the request is sent to
+func (cc *Client) endpoint(r *request) string {
+	endpoint := fmt.Sprintf("%s/v2/apm/correlate/%s/%s", cc.APIURL, cc.escape(r.DimName), cc.escape(r.DimValue))
+	switch r.operation {
+	case OperationCorrelate:
+		endpoint = fmt.Sprintf("%s/%s", endpoint, cc.escape(string(r.Type)))
+	case OperationDelete:
+		endpoint = fmt.Sprintf("%s/%s/%s", endpoint, cc.escape(string(r.Type)), cc.escape(r.Value))
+	}
+	return endpoint
+}
+
+// escape escapes a path segment with the configured escaper, or url.PathEscape if there isn't one
+func (cc *Client) escape(segment string) string {
+	if cc.pathEscaper == nil {
+		return url.PathEscape(segment)
+	}
+	return cc.pathEscaper(segment)
+}
@@ -0,0 +1,55 @@
+package correlations
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// escapeAll percent encodes every byte that isn't a letter or digit
+func escapeAll(segment string) string {
+	var b strings.Builder
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func TestEndpointEscaping(t *testing.T) {
+	apiURL, err := url.Parse("https://api.signalfx.com")
+	require.NoError(t, err)
+	r := &request{
+		Correlation: &Correlation{Type: Service, DimName: "k8s.pod", DimValue: "test-box", Value: "my_service"},
+		operation:   OperationDelete,
+	}
+
+	cc := &Client{APIURL: apiURL}
+	require.Equal(t, "https://api.signalfx.com/v2/apm/correlate/k8s.pod/test-box/service/my_service", cc.endpoint(r))
+
+	cc.pathEscaper = escapeAll
+	require.Equal(t, "https://api.signalfx.com/v2/apm/correlate/k8s%2Epod/test%2Dbox/service/my%5Fservice", cc.endpoint(r))
+}
+
+func TestCorrelationClientPathEscaper(t *testing.T) {
+	uris := make(chan string, 1)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		uris <- r.RequestURI
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.PathEscaper = escapeAll
+	})
+	defer cancel()
+	client.Start()
+
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+	require.Equal(t, "/v2/apm/correlate/host/test%2Dbox/service", <-uris)
+}
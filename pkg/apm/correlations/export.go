@@ -0,0 +1,59 @@
+package correlations
+
+import (
+	"encoding/json"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/signalfx/signalfx-agent/pkg/apm/requests/requestcounter"
+)
+
+// PendingRequest describes a request that hasn't completed, as exported by ExportPending
+type PendingRequest struct {
+	Type      Type   `json:"type"`
+	DimName   string `json:"dimName"`
+	DimValue  string `json:"dimValue"`
+	Value     string `json:"value,omitempty"`
+	Operation string `json:"operation"`
+	// Attempt is the number of attempts that have been made before the next one
+	Attempt uint32 `json:"attempt"`
+	// SendAt is when the request is scheduled to be retried, or for a held delete sent, nil if it
+	// is sent as soon as it is taken off the queue
+	SendAt *time.Time `json:"sendAt,omitempty"`
+}
+
+// setSendAt sets when the request is sent and records it for ExportPending, which can't read
+// sendAt itself while another goroutine owns the request
+func (r *request) setSendAt(t time.Time) {
+	r.sendAt = t
+	atomic.StoreInt64(&r.scheduledAt, t.UnixNano())
+}
+
+// ExportPending returns the requests that are queued, in flight or waiting to be retried as a
+// JSON array of PendingRequest in the order they were made, e.g. to inspect them or to replay
+// them after migrating the agent.  It only reads a snapshot of the outstanding requests, so
+// processing carries on undisturbed and requests may complete while they are being exported.
+// Requests that weren't tracked because too many were outstanding aren't included.
+func (cc *Client) ExportPending() ([]byte, error) {
+	active := cc.registry.active()
+	sort.Slice(active, func(i, j int) bool { return active[i].id < active[j].id })
+
+	pending := make([]PendingRequest, 0, len(active))
+	for _, r := range active {
+		p := PendingRequest{
+			Type:      r.Type,
+			DimName:   r.DimName,
+			DimValue:  r.DimValue,
+			Value:     r.Value,
+			Operation: r.operation.String(),
+			Attempt:   requestcounter.GetRequestCount(r.ctx),
+		}
+		if scheduledAt := atomic.LoadInt64(&r.scheduledAt); scheduledAt != 0 {
+			sendAt := time.Unix(0, scheduledAt)
+			p.SendAt = &sendAt
+		}
+		pending = append(pending, p)
+	}
+	return json.Marshal(pending)
+}
@@ -0,0 +1,55 @@
+package correlations
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelationClientExportPending(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.RetryDelay = time.Hour
+	})
+	defer cancel()
+
+	exported, err := client.ExportPending()
+	require.NoError(t, err)
+	require.JSONEq(t, "[]", string(exported))
+
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+	client.Delete(&Correlation{Type: Environment, DimName: "host", DimValue: "test-box", Value: "env"}, SuccessfulDeleteCB(func(*Correlation) {}))
+
+	// queued requests haven't been attempted or scheduled
+	var pending []PendingRequest
+	exported, err = client.ExportPending()
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(exported, &pending))
+	require.Equal(t, []PendingRequest{
+		{Type: Service, DimName: "host", DimValue: "test-box", Value: "service", Operation: "correlate"},
+		{Type: Environment, DimName: "host", DimValue: "test-box", Value: "env", Operation: "delete"},
+	}, pending)
+
+	start := time.Now()
+	client.Start()
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&client.retryQueueLen) == 2 }, 3*time.Second, 10*time.Millisecond)
+
+	exported, err = client.ExportPending()
+	require.NoError(t, err)
+	pending = nil
+	require.NoError(t, json.Unmarshal(exported, &pending))
+	require.Len(t, pending, 2)
+	for _, p := range pending {
+		require.Equal(t, uint32(1), p.Attempt)
+		require.NotNil(t, p.SendAt)
+		require.True(t, p.SendAt.After(start.Add(time.Hour-time.Minute)))
+	}
+	require.Equal(t, "correlate", pending[0].Operation)
+	require.Equal(t, "delete", pending[1].Operation)
+}
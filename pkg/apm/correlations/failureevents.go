@@ -0,0 +1,84 @@
+package correlations
+
+import (
+	"errors"
+	"net/http"
+)
+
+// FailureCategory is the kind of terminal failure a FailureEvent reports
+type FailureCategory string
+
+const (
+	// FailureAuth is a request the endpoint refused with a 401 or 403, usually because the access
+	// token is invalid or lacks permission
+	FailureAuth FailureCategory = "auth"
+	// FailureRetriesExhausted is a request that failed on every attempt
+	FailureRetriesExhausted FailureCategory = "retries_exhausted"
+	// FailureNotRetried is a request that failed with a retryable error but couldn't be retried,
+	// e.g. because the retry channel was full or its budget was used up
+	FailureNotRetried FailureCategory = "not_retried"
+	// FailureClientError is a request that failed with any other 4xx response
+	FailureClientError FailureCategory = "client_error"
+	// FailureRedirect is a request that was redirected when redirects aren't followed
+	FailureRedirect FailureCategory = "redirect"
+	// FailureResponseTooLarge is a request whose response body exceeded the maximum size
+	FailureResponseTooLarge FailureCategory = "response_too_large"
+	// FailureOther is any other failure
+	FailureOther FailureCategory = "other"
+)
+
+// FailureEvent describes a request that failed and won't be retried
+type FailureEvent struct {
+	Correlation *Correlation
+	Operation   Operation
+	Category    FailureCategory
+	// StatusCode is the status code of the last response, 0 if no response was received
+	StatusCode int
+	Err        error
+	// Attempts is the number of times the request was sent
+	Attempts uint32
+}
+
+// EventEmitter receives an event for each request that fails terminally, e.g. to send it through
+// the agent's event pipeline.  It is invoked synchronously on the client's routines, so it must
+// not block for long.
+type EventEmitter interface {
+	EmitFailure(event FailureEvent)
+}
+
+// failureCategory returns the category of a request that failed with the status code and error,
+// where retryErr is the error it couldn't be retried with, if a retry was attempted
+func failureCategory(statusCode int, err error, retryErr error) FailureCategory {
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return FailureAuth
+	case isResponseTooLarge(err):
+		return FailureResponseTooLarge
+	case statusCode >= 300 && statusCode < 400:
+		return FailureRedirect
+	case errors.Is(retryErr, errMaxAttempts):
+		return FailureRetriesExhausted
+	case retryErr != nil:
+		return FailureNotRetried
+	case statusCode >= 400 && statusCode < 500:
+		return FailureClientError
+	default:
+		return FailureOther
+	}
+}
+
+// emitFailureEvent sends an event for the failed request to the event emitter, if one is set
+func (cc *Client) emitFailureEvent(r *request, statusCode int, err error, retryErr error, attempts uint32) {
+	if cc.eventEmitter == nil {
+		return
+	}
+	event := FailureEvent{
+		Correlation: r.Correlation,
+		Operation:   r.operation,
+		Category:    failureCategory(statusCode, err, retryErr),
+		StatusCode:  statusCode,
+		Err:         err,
+		Attempts:    attempts,
+	}
+	cc.invokeCallback(r.Correlation, r.operation, func() { cc.eventEmitter.EmitFailure(event) })
+}
@@ -0,0 +1,92 @@
+package correlations
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/signalfx/signalfx-agent/pkg/apm/requests"
+)
+
+type eventRecorder chan FailureEvent
+
+func (e eventRecorder) EmitFailure(event FailureEvent) {
+	e <- event
+}
+
+func TestFailureCategory(t *testing.T) {
+	for _, tc := range []struct {
+		statusCode int
+		err        error
+		retryErr   error
+		expected   FailureCategory
+	}{
+		{statusCode: http.StatusUnauthorized, expected: FailureAuth},
+		{statusCode: http.StatusForbidden, expected: FailureAuth},
+		{statusCode: http.StatusBadRequest, expected: FailureClientError},
+		{statusCode: http.StatusFound, expected: FailureRedirect},
+		{statusCode: http.StatusOK, err: fmt.Errorf("reading body: %w", &requests.ErrResponseTooLarge{Limit: 1}), expected: FailureResponseTooLarge},
+		{err: errors.New("connection refused"), expected: FailureOther},
+		{statusCode: http.StatusServiceUnavailable, retryErr: errMaxAttempts, expected: FailureRetriesExhausted},
+		{retryErr: errMaxAttempts, expected: FailureRetriesExhausted},
+		{statusCode: http.StatusServiceUnavailable, retryErr: errRetryChFull, expected: FailureNotRetried},
+		{retryErr: errBudgetExceeded, expected: FailureNotRetried},
+	} {
+		require.Equal(t, tc.expected, failureCategory(tc.statusCode, tc.err, tc.retryErr), "%d %v", tc.statusCode, tc.retryErr)
+	}
+}
+
+func TestCorrelationClientEventEmitter(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "/denied/"):
+			rw.WriteHeader(http.StatusUnauthorized)
+		case strings.Contains(r.URL.Path, "/down/"):
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	events := make(eventRecorder, 10)
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.MaxRetries = 1
+		conf.EventEmitter = events
+	})
+	defer cancel()
+	client.Start()
+
+	next := func() FailureEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "no failure event")
+			return FailureEvent{}
+		}
+	}
+
+	// successes aren't reported
+	done := make(chan error, 1)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "up", Value: "a"}, func(_ *Correlation, err error) { done <- err })
+	require.NoError(t, <-done)
+
+	denied := &Correlation{Type: Service, DimName: "host", DimValue: "denied", Value: "a"}
+	client.Correlate(denied, nil)
+	event := next()
+	require.Equal(t, denied, event.Correlation)
+	require.Equal(t, OperationCorrelate, event.Operation)
+	require.Equal(t, FailureAuth, event.Category)
+	require.Equal(t, http.StatusUnauthorized, event.StatusCode)
+	require.Equal(t, uint32(1), event.Attempts)
+
+	client.Delete(&Correlation{Type: Service, DimName: "host", DimValue: "down", Value: "a"}, nil)
+	event = next()
+	require.Equal(t, OperationDelete, event.Operation)
+	require.Equal(t, FailureRetriesExhausted, event.Category)
+	require.Equal(t, uint32(3), event.Attempts)
+
+	require.Len(t, events, 0)
+}
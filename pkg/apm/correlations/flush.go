@@ -0,0 +1,50 @@
+package correlations
+
+import (
+	"container/heap"
+	"sync/atomic"
+)
+
+// FlushRetries makes every request that is waiting to be retried due immediately instead of
+// waiting out its backoff, e.g. once the endpoint has recovered from an outage.  It returns without
+// waiting for the retries to be sent.  The retries are still limited by MaxRetriesInFlight and
+// cancelled requests are dropped as usual; retrying doesn't count as an extra attempt because each
+// request's attempt was counted when it was queued to be retried.  The number of retries that were
+// brought forward is counted in TotalFlushedRetries.
+func (cc *Client) FlushRetries() {
+	select {
+	case cc.flushRetriesCh <- struct{}{}:
+	default:
+		// a flush is already pending
+	}
+}
+
+// flushRetries makes each pending retry and each retry still on the retry channel due now.  It is
+// only used by processRetryChan.
+func (cc *Client) flushRetries(pending *retryQueue) {
+	cc.drainRetryChan(pending)
+
+	now := cc.now()
+	var flushed int64
+	for _, r := range *pending {
+		if r.sendAt.After(now) {
+			r.setSendAt(now)
+			flushed++
+		}
+	}
+	heap.Init(pending)
+	atomic.AddInt64(&cc.TotalFlushedRetries, flushed)
+}
+
+// drainRetryChan moves the retries still on the retry channel to the pending retries so that they
+// can be rescheduled together
+func (cc *Client) drainRetryChan(pending *retryQueue) {
+	for pending.Len() < cap(cc.retryChan) {
+		select {
+		case r := <-cc.retryChan:
+			pending.push(r)
+		default:
+			return
+		}
+	}
+}
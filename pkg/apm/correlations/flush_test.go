@@ -0,0 +1,63 @@
+package correlations
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelationClientFlushRetries(t *testing.T) {
+	var attempts int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&attempts, 1) == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.RetryDelay = time.Hour
+	})
+	defer cancel()
+
+	// flushing without any retries does nothing, even before the client is started
+	client.FlushRetries()
+	client.Start()
+
+	done := make(chan error, 1)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, err error) {
+		done <- err
+	}))
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&client.retryQueueLen) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Equal(t, int64(0), atomic.LoadInt64(&client.TotalFlushedRetries))
+
+	client.FlushRetries()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("retry was not flushed")
+	}
+	require.Equal(t, int64(2), atomic.LoadInt64(&attempts))
+	require.Equal(t, int64(1), atomic.LoadInt64(&client.TotalFlushedRetries))
+}
+
+func TestFlushRetriesSkipsDueRequests(t *testing.T) {
+	now := time.Now()
+	cc := &Client{now: func() time.Time { return now }, retryChan: make(chan *request, 3)}
+	pending := &retryQueue{}
+	pending.push(&request{sendAt: now.Add(-time.Second)})
+	pending.push(&request{sendAt: now.Add(time.Hour)})
+	cc.retryChan <- &request{sendAt: now.Add(time.Minute)}
+
+	cc.flushRetries(pending)
+	require.Equal(t, 3, pending.Len())
+	require.Empty(t, cc.retryChan)
+	require.Equal(t, int64(2), cc.TotalFlushedRetries)
+	for i := 0; i < 3; i++ {
+		require.NotNil(t, pending.popDue(now))
+	}
+}
@@ -0,0 +1,94 @@
+package correlations
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultHealthFailureThreshold is the number of consecutive failures after which the client is
+// considered unhealthy when no threshold is configured
+const defaultHealthFailureThreshold = 5
+
+// reason reported when the client becomes healthy again
+const healthRecoveredReason = "requests are succeeding"
+
+// healthTracker aggregates request outcomes into a single healthy or unhealthy state.  The client
+// is unhealthy once the number of consecutive failures reaches the threshold and healthy again
+// after a success.  A change of state is only reported once it has held for the debounce period
+// so that the reported state doesn't flap.  The state is evaluated as outcomes are recorded.
+// A nil healthTracker ignores all outcomes.
+// this is threadsafe
+type healthTracker struct {
+	sync.Mutex
+	onChange  func(healthy bool, reason string)
+	threshold int
+	debounce  time.Duration
+
+	consecutiveFailures int
+	lastFailure         string
+	healthy             bool
+	// when the state first differed from the reported state, zero if it doesn't differ
+	changedAt time.Time
+}
+
+func newHealthTracker(onChange func(healthy bool, reason string), threshold uint, debounce time.Duration) *healthTracker {
+	if onChange == nil {
+		return nil
+	}
+	if threshold == 0 {
+		threshold = defaultHealthFailureThreshold
+	}
+	return &healthTracker{
+		onChange:  onChange,
+		threshold: int(threshold),
+		debounce:  debounce,
+		healthy:   true,
+	}
+}
+
+// recordSuccess records a successful request
+func (h *healthTracker) recordSuccess(now time.Time) {
+	if h == nil {
+		return
+	}
+	h.Lock()
+	defer h.Unlock()
+	h.consecutiveFailures = 0
+	h.update(now)
+}
+
+// recordFailure records a failure that indicates the correlation subsystem is degraded
+func (h *healthTracker) recordFailure(reason string, now time.Time) {
+	if h == nil {
+		return
+	}
+	h.Lock()
+	defer h.Unlock()
+	h.consecutiveFailures++
+	h.lastFailure = reason
+	h.update(now)
+}
+
+// update reports the current state if it differs from the reported state and has held for the
+// debounce period.  The lock must be held.
+func (h *healthTracker) update(now time.Time) {
+	healthy := h.consecutiveFailures < h.threshold
+	if healthy == h.healthy {
+		h.changedAt = time.Time{}
+		return
+	}
+	if h.changedAt.IsZero() {
+		h.changedAt = now
+	}
+	if now.Sub(h.changedAt) < h.debounce {
+		return
+	}
+
+	h.healthy = healthy
+	h.changedAt = time.Time{}
+	reason := healthRecoveredReason
+	if !healthy {
+		reason = h.lastFailure
+	}
+	h.onChange(healthy, reason)
+}
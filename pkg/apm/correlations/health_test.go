@@ -0,0 +1,50 @@
+package correlations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type healthChange struct {
+	healthy bool
+	reason  string
+}
+
+func TestHealthTrackerDebouncesChanges(t *testing.T) {
+	var changes []healthChange
+	h := newHealthTracker(func(healthy bool, reason string) {
+		changes = append(changes, healthChange{healthy: healthy, reason: reason})
+	}, 2, 10*time.Second)
+	now := time.Unix(1000, 0)
+
+	// a single failure is below the threshold
+	h.recordFailure("server error", now)
+	require.Empty(t, changes)
+
+	// reaching the threshold isn't reported until it has persisted for the debounce period
+	h.recordFailure("server error", now.Add(time.Second))
+	require.Empty(t, changes)
+	h.recordFailure("channel full", now.Add(5*time.Second))
+	require.Empty(t, changes)
+	h.recordFailure("channel full", now.Add(11*time.Second))
+	require.Equal(t, []healthChange{{healthy: false, reason: "channel full"}}, changes)
+
+	// a brief recovery doesn't flap the reported state
+	h.recordSuccess(now.Add(12 * time.Second))
+	h.recordFailure("server error", now.Add(13*time.Second))
+	h.recordFailure("server error", now.Add(14*time.Second))
+	h.recordSuccess(now.Add(30 * time.Second))
+	require.Len(t, changes, 1)
+
+	h.recordSuccess(now.Add(41 * time.Second))
+	require.Equal(t, healthChange{healthy: true, reason: healthRecoveredReason}, changes[1])
+}
+
+func TestNilHealthTracker(t *testing.T) {
+	h := newHealthTracker(nil, 0, 0)
+	require.Nil(t, h)
+	h.recordFailure("server error", time.Now())
+	h.recordSuccess(time.Now())
+}
@@ -0,0 +1,126 @@
+package correlations
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/signalfx/signalfx-agent/pkg/apm/requests"
+)
+
+// hedgeLatencySamples is the number of recent Get latencies the hedge delay is computed from
+const hedgeLatencySamples = 100
+
+// hedgeMinSamples is the number of latencies needed before the percentile is used instead of
+// the configured hedge delay
+const hedgeMinSamples = 10
+
+// hedger decides how long to wait for a Get response before sending a duplicate request.  The
+// delay is the configured percentile of recent Get latencies, and is never less than the
+// configured minimum delay.
+// this is threadsafe
+type hedger struct {
+	sync.Mutex
+	percentile float64
+	minDelay   time.Duration
+	latencies  []time.Duration
+	next       int
+}
+
+func newHedger(percentile float64, minDelay time.Duration) *hedger {
+	if percentile <= 0 {
+		return nil
+	}
+	if percentile > 1 {
+		percentile = 1
+	}
+	return &hedger{
+		percentile: percentile,
+		minDelay:   minDelay,
+		latencies:  make([]time.Duration, 0, hedgeLatencySamples),
+	}
+}
+
+// record adds the latency of a completed Get
+func (h *hedger) record(latency time.Duration) {
+	h.Lock()
+	defer h.Unlock()
+	if len(h.latencies) < hedgeLatencySamples {
+		h.latencies = append(h.latencies, latency)
+		return
+	}
+	h.latencies[h.next] = latency
+	h.next = (h.next + 1) % hedgeLatencySamples
+}
+
+// delay returns how long to wait for a response before hedging
+func (h *hedger) delay() time.Duration {
+	h.Lock()
+	if len(h.latencies) < hedgeMinSamples {
+		h.Unlock()
+		return h.minDelay
+	}
+	sorted := append([]time.Duration(nil), h.latencies...)
+	h.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	delay := sorted[int(h.percentile*float64(len(sorted)-1))]
+	if delay < h.minDelay {
+		return h.minDelay
+	}
+	return delay
+}
+
+// sendHedged sends the request and, if it hasn't completed within the hedge delay, a duplicate of
+// it.  Whichever completes first is passed to the callbacks and the other is cancelled.  The
+// duplicate is only sent if there is a free slot for it so that hedging doesn't exceed the
+// concurrency limit.
+func (cc *Client) sendHedged(r *request, req *http.Request, onFailure requests.RequestFailedHeaderCallback, onSuccess requests.RequestSuccessHeaderCallback) {
+	var once sync.Once
+	var timer *time.Timer
+	primaryCtx, cancelPrimary := context.WithCancel(req.Context())
+	hedgeCtx, cancelHedge := context.WithCancel(req.Context())
+
+	// attempt returns a copy of the request that reports to the callbacks only if it completes
+	// first.  Each attempt holds its own slot, so the one that loses releases its slot here.
+	attempt := func(ctx context.Context, isHedge bool) *http.Request {
+		start := cc.now()
+		complete := func(cb func()) {
+			won := false
+			once.Do(func() {
+				won = true
+				timer.Stop()
+				cancelPrimary()
+				cancelHedge()
+			})
+			if !won {
+				cc.releaseSlot(r)
+				return
+			}
+			if isHedge {
+				atomic.AddInt64(&cc.TotalHedgeWins, int64(1))
+			}
+			cb()
+		}
+		return cc.withCallbacks(req.WithContext(ctx),
+			func(body []byte, statusCode int, header http.Header, err error) {
+				complete(func() { onFailure(body, statusCode, header, err) })
+			},
+			func(body []byte, statusCode int, header http.Header) {
+				cc.hedger.record(cc.now().Sub(start))
+				complete(func() { onSuccess(body, statusCode, header) })
+			})
+	}
+
+	timer = time.AfterFunc(cc.hedger.delay(), func() {
+		if primaryCtx.Err() != nil || !cc.acquireSlot(r) {
+			return
+		}
+		atomic.AddInt64(&cc.TotalHedgedRequests, int64(1))
+		cc.sendInFlight(attempt(hedgeCtx, true))
+	})
+	cc.send(r, attempt(primaryCtx, false))
+}
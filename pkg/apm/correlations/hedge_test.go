@@ -0,0 +1,26 @@
+package correlations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHedgerDelay(t *testing.T) {
+	require.Nil(t, newHedger(0, time.Second))
+
+	h := newHedger(0.9, 5*time.Millisecond)
+	// the minimum delay is used until there are enough samples
+	h.record(time.Second)
+	require.Equal(t, 5*time.Millisecond, h.delay())
+
+	for i := 1; i <= hedgeLatencySamples; i++ {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+	require.Equal(t, 90*time.Millisecond, h.delay())
+
+	// the delay is never less than the minimum
+	h.minDelay = time.Second
+	require.Equal(t, time.Second, h.delay())
+}
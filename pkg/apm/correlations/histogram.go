@@ -0,0 +1,79 @@
+package correlations
+
+import (
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/signalfx/golib/v3/datapoint"
+	"github.com/signalfx/golib/v3/sfxclient"
+)
+
+// defaultRequestAgeBuckets are the upper bounds of the buckets request ages are counted in
+var defaultRequestAgeBuckets = []time.Duration{
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+}
+
+// durationHistogram counts durations in buckets with fixed upper bounds.  Durations longer than the
+// largest bound are only counted in the total.
+// this is threadsafe
+type durationHistogram struct {
+	bounds   []time.Duration
+	buckets  []int64
+	count    int64
+	sumNanos int64
+}
+
+// newDurationHistogram returns a histogram with the bounds, which must be sorted in increasing order
+func newDurationHistogram(bounds []time.Duration) *durationHistogram {
+	return &durationHistogram{
+		bounds:  bounds,
+		buckets: make([]int64, len(bounds)),
+	}
+}
+
+// observe counts the duration
+func (h *durationHistogram) observe(d time.Duration) {
+	if i := sort.Search(len(h.bounds), func(i int) bool { return d <= h.bounds[i] }); i < len(h.bounds) {
+		atomic.AddInt64(&h.buckets[i], 1)
+	}
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sumNanos, int64(d))
+}
+
+// reset sets every count to zero
+func (h *durationHistogram) reset() {
+	for i := range h.buckets {
+		atomic.StoreInt64(&h.buckets[i], 0)
+	}
+	atomic.StoreInt64(&h.count, 0)
+	atomic.StoreInt64(&h.sumNanos, 0)
+}
+
+// datapoints returns the count, sum in seconds, and cumulative count of each bucket in the same
+// form as histograms converted from prometheus
+func (h *durationHistogram) datapoints(name string) []*datapoint.Datapoint {
+	dps := make([]*datapoint.Datapoint, 0, len(h.bounds)+2)
+	dps = append(dps,
+		sfxclient.Cumulative(name+"_count", nil, atomic.LoadInt64(&h.count)),
+		sfxclient.CumulativeF(name, nil, time.Duration(atomic.LoadInt64(&h.sumNanos)).Seconds()),
+	)
+	var cumulative int64
+	for i, bound := range h.bounds {
+		cumulative += atomic.LoadInt64(&h.buckets[i])
+		dps = append(dps, sfxclient.Cumulative(name+"_bucket", map[string]string{
+			"upper_bound": strconv.FormatFloat(bound.Seconds(), 'f', 6, 64),
+		}, cumulative))
+	}
+	return dps
+}
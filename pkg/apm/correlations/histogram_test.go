@@ -0,0 +1,30 @@
+package correlations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDurationHistogram(t *testing.T) {
+	h := newDurationHistogram([]time.Duration{time.Second, 10 * time.Second})
+	h.observe(500 * time.Millisecond)
+	h.observe(time.Second)
+	h.observe(5 * time.Second)
+	h.observe(time.Minute)
+
+	dps := h.datapoints("age")
+	require.Len(t, dps, 4)
+	require.Equal(t, "age_count", dps[0].Metric)
+	require.Equal(t, "4", dps[0].Value.String())
+	require.Equal(t, "age", dps[1].Metric)
+	require.Equal(t, "66.5", dps[1].Value.String())
+
+	// bucket counts are cumulative and the longest duration is above every bound
+	require.Equal(t, "age_bucket", dps[2].Metric)
+	require.Equal(t, "1.000000", dps[2].Dimensions["upper_bound"])
+	require.Equal(t, "2", dps[2].Value.String())
+	require.Equal(t, "10.000000", dps[3].Dimensions["upper_bound"])
+	require.Equal(t, "3", dps[3].Value.String())
+}
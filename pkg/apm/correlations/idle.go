@@ -0,0 +1,27 @@
+package correlations
+
+import "context"
+
+// isIdle returns whether no requests are queued, waiting to be retried or in flight.  Every request
+// is counted as pending from when it is queued until it completes, which also covers requests that
+// are held, waiting for a slot or serialized behind another request.
+func (cc *Client) isIdle() bool {
+	return cc.registry.pendingCount() == 0
+}
+
+// WaitIdle blocks until the client has no pending work, including requests scheduled to be
+// retried in the future, without stopping it.  It returns the context's error if the context is
+// done first.  New requests may be made while waiting, so the client may not be idle by the time
+// WaitIdle returns.
+func (cc *Client) WaitIdle(ctx context.Context) error {
+	for !cc.isIdle() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-cc.ctx.Done():
+			return errShutdown
+		case <-cc.registry.idleCh():
+		}
+	}
+	return nil
+}
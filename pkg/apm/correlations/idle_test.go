@@ -0,0 +1,39 @@
+package correlations
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelationClientWaitIdle(t *testing.T) {
+	var attempts int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// fail the first attempt so that the request waits to be retried
+		if atomic.AddInt64(&attempts, 1) == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.RetryDelay = 300 * time.Millisecond
+	})
+	defer cancel()
+	client.Start()
+
+	require.NoError(t, client.WaitIdle(context.Background()))
+
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&attempts) == 1 }, time.Second, time.Millisecond)
+
+	// the client isn't idle while the retry is scheduled
+	ctx, cancelWait := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelWait()
+	require.Equal(t, context.DeadlineExceeded, client.WaitIdle(ctx))
+
+	require.NoError(t, client.WaitIdle(context.Background()))
+	require.Equal(t, int64(2), atomic.LoadInt64(&attempts))
+}
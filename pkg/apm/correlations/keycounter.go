@@ -0,0 +1,70 @@
+package correlations
+
+import (
+	"sort"
+	"sync"
+)
+
+// otherKey is the key reported for counts that didn't fit in a keyCounter
+const otherKey = "other"
+
+// KeyCount is the number of requests counted for a key
+type KeyCount struct {
+	Key   string
+	Count int64
+}
+
+// keyCounter counts requests per key for a bounded number of keys.  Once the limit is reached,
+// requests for keys that aren't already tracked are counted in a single "other" bucket so that
+// high cardinality keys can't grow it without bound.
+// this is threadsafe
+type keyCounter struct {
+	sync.Mutex
+	limit  int
+	counts map[string]int64
+	other  int64
+}
+
+func newKeyCounter(limit int) *keyCounter {
+	return &keyCounter{
+		limit:  limit,
+		counts: make(map[string]int64, limit),
+	}
+}
+
+// increment counts a request for the key
+func (k *keyCounter) increment(key string) {
+	k.Lock()
+	defer k.Unlock()
+	if _, ok := k.counts[key]; ok || len(k.counts) < k.limit {
+		k.counts[key]++
+		return
+	}
+	k.other++
+}
+
+// snapshot returns the tracked counts ordered from highest to lowest and the count of the
+// "other" bucket
+func (k *keyCounter) snapshot() (counts []KeyCount, other int64) {
+	k.Lock()
+	defer k.Unlock()
+	out := make([]KeyCount, 0, len(k.counts))
+	for key, count := range k.counts {
+		out = append(out, KeyCount{Key: key, Count: count})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count == out[j].Count {
+			return out[i].Key < out[j].Key
+		}
+		return out[i].Count > out[j].Count
+	})
+	return out, k.other
+}
+
+// reset clears every count
+func (k *keyCounter) reset() {
+	k.Lock()
+	defer k.Unlock()
+	k.counts = make(map[string]int64, k.limit)
+	k.other = 0
+}
@@ -0,0 +1,18 @@
+package correlations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyCounterBoundsKeys(t *testing.T) {
+	k := newKeyCounter(2)
+	for _, key := range []string{"host", "container_id", "host", "kubernetes_pod_uid", "host", "container_id", "AWSUniqueId"} {
+		k.increment(key)
+	}
+
+	counts, other := k.snapshot()
+	require.Equal(t, []KeyCount{{Key: "host", Count: 3}, {Key: "container_id", Count: 2}}, counts)
+	require.Equal(t, int64(2), other)
+}
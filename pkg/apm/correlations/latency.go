@@ -0,0 +1,72 @@
+package correlations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// newLatencyEMA returns the moving average of request latencies in seconds
+func newLatencyEMA(decay float64) (*movingAverage, error) {
+	m, err := newMovingAverage(decay)
+	if err != nil {
+		return nil, err
+	}
+	// the first latency is a far better estimate than 0, which would make adaptive timeouts
+	// start out at their minimum
+	m.startFromFirst = true
+	return m, nil
+}
+
+func validateAdaptiveTimeout(conf Config) error {
+	if conf.AdaptiveTimeoutMultiplier < 0 {
+		return fmt.Errorf("invalid correlation adaptive timeout multiplier %v", conf.AdaptiveTimeoutMultiplier)
+	}
+	if conf.AdaptiveTimeoutMin < 0 || conf.AdaptiveTimeoutMax < 0 {
+		return errors.New("correlation adaptive timeout bounds must not be negative")
+	}
+	if conf.AdaptiveTimeoutMax > 0 && conf.AdaptiveTimeoutMin > conf.AdaptiveTimeoutMax {
+		return fmt.Errorf("correlation adaptive timeout min %v is greater than the max %v", conf.AdaptiveTimeoutMin, conf.AdaptiveTimeoutMax)
+	}
+	return nil
+}
+
+// recordLatency adds the latency of an attempt that completed or timed out to the moving average.
+// Attempts that failed without a response for any other reason say nothing about how quickly the
+// endpoint responds.
+func (cc *Client) recordLatency(start time.Time, statusCode int, err error) {
+	if statusCode == 0 && !errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	cc.latencyEMA.update(cc.now().Sub(start).Seconds())
+}
+
+// LatencyEMA returns the exponentially weighted moving average of the latency of requests
+func (cc *Client) LatencyEMA() time.Duration {
+	return time.Duration(cc.latencyEMA.get() * float64(time.Second))
+}
+
+// adaptiveTimeout returns the attempt timeout derived from the latency moving average.  ok is
+// false if adaptive timeouts are disabled or no latencies have been recorded yet.
+func (cc *Client) adaptiveTimeout() (timeout time.Duration, ok bool) {
+	cc.RLock()
+	multiplier, min, max := cc.conf.AdaptiveTimeoutMultiplier, cc.conf.AdaptiveTimeoutMin, cc.conf.AdaptiveTimeoutMax
+	cc.RUnlock()
+	if multiplier <= 0 {
+		return 0, false
+	}
+	latency, sampled := cc.latencyEMA.sampled()
+	if !sampled {
+		return 0, false
+	}
+
+	timeout = time.Duration(latency * multiplier * float64(time.Second))
+	if timeout < min {
+		timeout = min
+	}
+	if max > 0 && timeout > max {
+		timeout = max
+	}
+	return timeout, timeout > 0
+}
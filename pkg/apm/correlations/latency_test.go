@@ -0,0 +1,96 @@
+package correlations
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAdaptiveTimeout(t *testing.T) {
+	require.NoError(t, validateAdaptiveTimeout(Config{}))
+	require.NoError(t, validateAdaptiveTimeout(Config{AdaptiveTimeoutMultiplier: 3, AdaptiveTimeoutMin: time.Second, AdaptiveTimeoutMax: 2 * time.Second}))
+	require.Error(t, validateAdaptiveTimeout(Config{AdaptiveTimeoutMultiplier: -1}))
+	require.Error(t, validateAdaptiveTimeout(Config{AdaptiveTimeoutMin: -time.Second}))
+	require.Error(t, validateAdaptiveTimeout(Config{AdaptiveTimeoutMin: 2 * time.Second, AdaptiveTimeoutMax: time.Second}))
+
+	_, err := newLatencyEMA(2)
+	require.Error(t, err)
+}
+
+func TestAdaptiveTimeout(t *testing.T) {
+	latencyEMA, err := newLatencyEMA(0.5)
+	require.NoError(t, err)
+	now := time.Now()
+	cc := &Client{now: func() time.Time { return now }, latencyEMA: latencyEMA}
+	cc.conf.AttemptTimeout = 10 * time.Second
+	r := &request{enqueuedAt: now}
+
+	// disabled by default
+	cc.recordLatency(now.Add(-time.Second), 200, nil)
+	require.Equal(t, time.Second, cc.LatencyEMA())
+	timeout, ok := cc.attemptTimeout(r)
+	require.True(t, ok)
+	require.Equal(t, 10*time.Second, timeout)
+
+	cc.conf.AdaptiveTimeoutMultiplier = 3
+	timeout, ok = cc.attemptTimeout(r)
+	require.True(t, ok)
+	require.Equal(t, 3*time.Second, timeout)
+
+	// timeouts loosen as the endpoint slows down
+	cc.recordLatency(now.Add(-3*time.Second), 200, nil)
+	require.Equal(t, 2*time.Second, cc.LatencyEMA())
+	timeout, _ = cc.attemptTimeout(r)
+	require.Equal(t, 6*time.Second, timeout)
+
+	// and are bounded
+	cc.conf.AdaptiveTimeoutMax = 5 * time.Second
+	timeout, _ = cc.attemptTimeout(r)
+	require.Equal(t, 5*time.Second, timeout)
+	cc.conf.AdaptiveTimeoutMin = 8 * time.Second
+	cc.conf.AdaptiveTimeoutMax = 0
+	timeout, _ = cc.attemptTimeout(r)
+	require.Equal(t, 8*time.Second, timeout)
+
+	// the budget still limits the attempt
+	cc.conf.RequestBudget = 4 * time.Second
+	timeout, _ = cc.attemptTimeout(r)
+	require.Equal(t, 4*time.Second, timeout)
+}
+
+func TestAdaptiveTimeoutWithoutLatencies(t *testing.T) {
+	latencyEMA, err := newLatencyEMA(0)
+	require.NoError(t, err)
+	cc := &Client{now: time.Now, latencyEMA: latencyEMA}
+	cc.conf.AdaptiveTimeoutMultiplier = 3
+	cc.conf.AdaptiveTimeoutMin = time.Second
+
+	// the static timeout is used until there is a latency to adapt to
+	_, ok := cc.attemptTimeout(&request{enqueuedAt: time.Now()})
+	require.False(t, ok)
+	cc.conf.AttemptTimeout = 10 * time.Second
+	timeout, ok := cc.attemptTimeout(&request{enqueuedAt: time.Now()})
+	require.True(t, ok)
+	require.Equal(t, 10*time.Second, timeout)
+}
+
+func TestRecordLatency(t *testing.T) {
+	latencyEMA, err := newLatencyEMA(0.5)
+	require.NoError(t, err)
+	now := time.Now()
+	cc := &Client{now: func() time.Time { return now }, latencyEMA: latencyEMA}
+
+	// failures without a response don't say how fast the endpoint is
+	cc.recordLatency(now.Add(-time.Second), 0, errors.New("connection refused"))
+	_, sampled := latencyEMA.sampled()
+	require.False(t, sampled)
+
+	// but timeouts do
+	cc.recordLatency(now.Add(-time.Second), 0, context.DeadlineExceeded)
+	require.Equal(t, time.Second, cc.LatencyEMA())
+	cc.recordLatency(now.Add(-3*time.Second), 503, errors.New("unavailable"))
+	require.Equal(t, 2*time.Second, cc.LatencyEMA())
+}
@@ -0,0 +1,41 @@
+package correlations
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// logSampler picks which successful updates are logged so that 1 in every rate updates is logged
+// on average.  A nil sampler picks every update.
+// this is threadsafe
+type logSampler struct {
+	sync.Mutex
+	rate int64
+	rng  *rand.Rand
+}
+
+// newLogSampler returns a sampler that picks 1 in rate updates.  The same seed always picks the
+// same updates; a seed of 0 picks a random seed.  It returns nil if rate doesn't skip any updates.
+func newLogSampler(rate uint, seed int64) *logSampler {
+	if rate <= 1 {
+		return nil
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &logSampler{
+		rate: int64(rate),
+		rng:  rand.New(rand.NewSource(seed)), // nolint: gosec
+	}
+}
+
+// sample returns whether the next update should be logged
+func (s *logSampler) sample() bool {
+	if s == nil {
+		return true
+	}
+	s.Lock()
+	defer s.Unlock()
+	return s.rng.Int63n(s.rate) == 0
+}
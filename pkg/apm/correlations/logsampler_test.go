@@ -0,0 +1,33 @@
+package correlations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogSampler(t *testing.T) {
+	require.Nil(t, newLogSampler(1, 0))
+	var s *logSampler
+	require.True(t, s.sample())
+
+	picks := func(seed int64) []bool {
+		s := newLogSampler(10, seed)
+		var picked []bool
+		for i := 0; i < 1000; i++ {
+			picked = append(picked, s.sample())
+		}
+		return picked
+	}
+	first := picks(42)
+	// the same seed picks the same updates
+	require.Equal(t, first, picks(42))
+
+	count := 0
+	for _, p := range first {
+		if p {
+			count++
+		}
+	}
+	require.InDelta(t, 100, count, 50)
+}
@@ -0,0 +1,87 @@
+package correlations
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+func validateMaxEntriesStatusCode(statusCode int) error {
+	if statusCode != 0 && (statusCode < 300 || statusCode > 599) {
+		return fmt.Errorf("correlation max entries status code %d must be a 3xx, 4xx or 5xx code", statusCode)
+	}
+	return nil
+}
+
+// maxEntriesStatusCode returns the status code that signals a correlation was rejected because its
+// dimension has the maximum number of values
+func maxEntriesStatusCode(conf Config) int {
+	if conf.MaxEntriesStatusCode > 0 {
+		return conf.MaxEntriesStatusCode
+	}
+	return http.StatusTeapot
+}
+
+// maxCappedDimensions bounds the number of dimensions whose maximum entries state is tracked.  Once
+// it is full, further dimensions that reach their maximum aren't tracked, so their recovery isn't
+// reported.
+const maxCappedDimensions = 10000
+
+// cappedKey identifies a dimension's values of one correlation type, which have their own maximum
+type cappedKey struct {
+	dimensionKey
+	typ Type
+}
+
+func cappedKeyFor(cor *Correlation) cappedKey {
+	return cappedKey{dimensionKey: dimensionKey{name: cor.DimName, value: cor.DimValue}, typ: cor.Type}
+}
+
+// cappedDimensions tracks the dimensions that have reached the maximum number of values of a type
+// so that OnMaxEntriesResolved can be called once one of them accepts a value again.
+// this is threadsafe
+type cappedDimensions struct {
+	sync.Mutex
+	keys map[cappedKey]struct{}
+}
+
+func newCappedDimensions() *cappedDimensions {
+	return &cappedDimensions{keys: make(map[cappedKey]struct{})}
+}
+
+// add records that the correlation's dimension has reached the maximum for its type
+func (c *cappedDimensions) add(cor *Correlation) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if len(c.keys) < maxCappedDimensions {
+		c.keys[cappedKeyFor(cor)] = struct{}{}
+	}
+}
+
+// resolve forgets the correlation's dimension and returns whether it had reached the maximum
+func (c *cappedDimensions) resolve(cor *Correlation) bool {
+	if c == nil {
+		return false
+	}
+	c.Lock()
+	defer c.Unlock()
+	key := cappedKeyFor(cor)
+	if _, ok := c.keys[key]; !ok {
+		return false
+	}
+	delete(c.keys, key)
+	return true
+}
+
+// len returns the number of dimensions that are at their maximum
+func (c *cappedDimensions) len() int {
+	if c == nil {
+		return 0
+	}
+	c.Lock()
+	defer c.Unlock()
+	return len(c.keys)
+}
@@ -0,0 +1,118 @@
+package correlations
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateMaxEntriesStatusCode(t *testing.T) {
+	require.NoError(t, validateMaxEntriesStatusCode(0))
+	require.NoError(t, validateMaxEntriesStatusCode(http.StatusTooManyRequests))
+	require.Error(t, validateMaxEntriesStatusCode(http.StatusOK))
+	require.Error(t, validateMaxEntriesStatusCode(600))
+}
+
+func TestCappedDimensions(t *testing.T) {
+	var nilDims *cappedDimensions
+	nilDims.add(&Correlation{})
+	require.False(t, nilDims.resolve(&Correlation{}))
+	require.Zero(t, nilDims.len())
+
+	c := newCappedDimensions()
+	service := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}
+	c.add(service)
+	require.Equal(t, 1, c.len())
+
+	// environments have their own maximum
+	require.False(t, c.resolve(&Correlation{Type: Environment, DimName: "host", DimValue: "test-box", Value: "env"}))
+	// any value of the type resolves it, once
+	require.True(t, c.resolve(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "other"}))
+	require.False(t, c.resolve(service))
+
+	for i := 0; i < maxCappedDimensions+10; i++ {
+		c.add(&Correlation{Type: Service, DimName: "host", DimValue: fmt.Sprintf("box-%d", i)})
+	}
+	require.Equal(t, maxCappedDimensions, c.len())
+}
+
+func TestCorrelationClientOnMaxEntriesResolved(t *testing.T) {
+	var capped int32 = 1
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&capped) == 1 {
+			rw.WriteHeader(http.StatusTeapot)
+			_, _ = rw.Write([]byte(`{"max":100}`))
+		}
+	})
+	resolved := make(chan *Correlation, 2)
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.OnMaxEntriesResolved = func(cor *Correlation) {
+			resolved <- cor
+		}
+	})
+	defer cancel()
+	client.Start()
+
+	errs := make(chan error, 1)
+	correlate := func(cor *Correlation) error {
+		client.Correlate(cor, CorrelateCB(func(_ *Correlation, err error) {
+			errs <- err
+		}))
+		return <-errs
+	}
+
+	require.Equal(t, &ErrMaxEntries{MaxEntries: 100}, correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}))
+	require.Empty(t, resolved)
+
+	atomic.StoreInt32(&capped, 0)
+	recovered := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service-2"}
+	require.NoError(t, correlate(recovered))
+	require.Equal(t, recovered, <-resolved)
+
+	// later successes aren't recoveries
+	require.NoError(t, correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service-3"}))
+	require.Empty(t, resolved)
+}
+
+func TestCorrelationClientMaxEntriesStatusCode(t *testing.T) {
+	var attempts, status int64 = 0, http.StatusServiceUnavailable
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&attempts, 1)
+		rw.WriteHeader(int(atomic.LoadInt64(&status)))
+		_, _ = rw.Write([]byte(`{"max":50}`))
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.MaxEntriesStatusCode = http.StatusServiceUnavailable
+	})
+	defer cancel()
+	client.Start()
+
+	errs := make(chan error, 1)
+	correlate := func(value string) error {
+		client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: value}, CorrelateCB(func(_ *Correlation, err error) {
+			errs <- err
+		}))
+		return <-errs
+	}
+
+	// the configured status is parsed and isn't retried even though it is a 5xx
+	err := correlate("rewritten")
+	max, ok := err.(*ErrMaxEntries)
+	require.True(t, ok, "%v", err)
+	require.Equal(t, int64(50), max.MaxEntries)
+	require.Equal(t, http.StatusServiceUnavailable, max.StatusCode())
+	require.Equal(t, int64(1), atomic.LoadInt64(&attempts))
+
+	// a 418 is no longer the max entries status
+	atomic.StoreInt64(&status, http.StatusTeapot)
+	err = correlate("teapot")
+	_, ok = err.(*ErrMaxEntries)
+	require.False(t, ok)
+	var reqErr *RequestError
+	require.True(t, errors.As(err, &reqErr))
+	require.Equal(t, http.StatusTeapot, reqErr.Status)
+}
@@ -0,0 +1,126 @@
+package correlations
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxNegativeCacheEntries bounds the number of dimensions the negative cache remembers.  Once it
+// is full, further dimensions that aren't found aren't cached until entries expire.
+const maxNegativeCacheEntries = 10000
+
+var errNegativeCached = errors.New("dimension was recently not found")
+
+// negativeCache remembers dimensions that recently weren't found so that repeated Gets for them
+// can be answered without a request.
+// this is threadsafe
+type negativeCache struct {
+	sync.Mutex
+	ttl     time.Duration
+	entries map[dimensionKey]time.Time
+}
+
+func newNegativeCache(ttl time.Duration) *negativeCache {
+	return &negativeCache{
+		ttl:     ttl,
+		entries: make(map[dimensionKey]time.Time),
+	}
+}
+
+// add remembers that the dimension wasn't found
+func (c *negativeCache) add(key dimensionKey, now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxNegativeCacheEntries {
+		c.expireLocked(now)
+		if len(c.entries) >= maxNegativeCacheEntries {
+			return
+		}
+	}
+	c.entries[key] = now.Add(c.ttl)
+}
+
+// contains returns whether the dimension wasn't found within the ttl
+func (c *negativeCache) contains(key dimensionKey, now time.Time) bool {
+	c.Lock()
+	defer c.Unlock()
+	expiresAt, ok := c.entries[key]
+	if ok && !now.Before(expiresAt) {
+		delete(c.entries, key)
+		return false
+	}
+	return ok
+}
+
+// invalidate forgets the dimension
+func (c *negativeCache) invalidate(key dimensionKey) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.entries, key)
+}
+
+// clear forgets every dimension
+func (c *negativeCache) clear() {
+	c.Lock()
+	defer c.Unlock()
+	c.entries = make(map[dimensionKey]time.Time)
+}
+
+// len returns the number of dimensions that haven't expired
+func (c *negativeCache) len(now time.Time) int {
+	c.Lock()
+	defer c.Unlock()
+	c.expireLocked(now)
+	return len(c.entries)
+}
+
+func (c *negativeCache) expireLocked(now time.Time) {
+	for key, expiresAt := range c.entries {
+		if !now.Before(expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// answerFromNegativeCache invokes the callback with a not found result without making a request
+// if the dimension was recently not found.  The callback is invoked on its own goroutine, as it
+// would be for a request.
+func (cc *Client) answerFromNegativeCache(cor *Correlation, callback GetDetailedCB) bool {
+	if cc.negativeCache == nil || !cc.negativeCache.contains(dimensionKey{name: cor.DimName, value: cor.DimValue}, cc.now()) {
+		return false
+	}
+	atomic.AddInt64(&cc.TotalNegativeCacheHits, int64(1))
+	result := GetResult{
+		StatusCode: http.StatusNotFound,
+		Err:        cc.requestError(OperationGet, http.StatusNotFound, errNegativeCached),
+	}
+	go cc.invokeCallback(cor, OperationGet, func() { callback(result) })
+	return true
+}
+
+// InvalidateNegativeCache forgets that the dimension wasn't found so that the next Get for it
+// makes a request, e.g. after its correlations were created elsewhere.
+func (cc *Client) InvalidateNegativeCache(dimName string, dimValue string) {
+	if cc.negativeCache != nil {
+		cc.negativeCache.invalidate(dimensionKey{name: dimName, value: dimValue})
+	}
+}
+
+// ClearNegativeCache forgets every dimension that wasn't found
+func (cc *Client) ClearNegativeCache() {
+	if cc.negativeCache != nil {
+		cc.negativeCache.clear()
+	}
+}
+
+// NegativeCacheLen returns the number of dimensions remembered as not found.  It is 0 if the
+// negative cache is disabled.
+func (cc *Client) NegativeCacheLen() int {
+	if cc.negativeCache == nil {
+		return 0
+	}
+	return cc.negativeCache.len(cc.now())
+}
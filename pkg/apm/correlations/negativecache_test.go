@@ -0,0 +1,91 @@
+package correlations
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegativeCache(t *testing.T) {
+	c := newNegativeCache(time.Minute)
+	now := time.Now()
+	key := dimensionKey{name: "host", value: "test-box"}
+
+	require.False(t, c.contains(key, now))
+	c.add(key, now)
+	require.True(t, c.contains(key, now.Add(30*time.Second)))
+	require.Equal(t, 1, c.len(now))
+
+	// entries expire after the ttl
+	require.False(t, c.contains(key, now.Add(time.Minute)))
+	require.Equal(t, 0, c.len(now))
+
+	c.add(key, now)
+	c.add(dimensionKey{name: "host", value: "other-box"}, now)
+	c.invalidate(key)
+	require.False(t, c.contains(key, now))
+	require.Equal(t, 1, c.len(now))
+	c.clear()
+	require.Equal(t, 0, c.len(now))
+}
+
+func TestCorrelationClientNegativeCache(t *testing.T) {
+	var gets int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt64(&gets, 1)
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.NegativeCacheTTL = time.Minute
+	})
+	defer cancel()
+	client.Start()
+
+	get := func() {
+		cors, err := client.GetSync(context.Background(), "host", "test-box")
+		require.NoError(t, err)
+		require.Empty(t, cors)
+	}
+
+	get()
+	get()
+	require.Equal(t, int64(1), atomic.LoadInt64(&gets))
+	require.Equal(t, int64(1), atomic.LoadInt64(&client.TotalNegativeCacheHits))
+	require.Equal(t, 1, client.NegativeCacheLen())
+
+	client.InvalidateNegativeCache("host", "test-box")
+	require.Equal(t, 0, client.NegativeCacheLen())
+	get()
+	require.Equal(t, int64(2), atomic.LoadInt64(&gets))
+
+	// the cache is safe to clear while gets are being answered from it
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, _ = client.GetSync(context.Background(), "host", "test-box")
+		}()
+		go func() {
+			defer wg.Done()
+			client.ClearNegativeCache()
+		}()
+	}
+	wg.Wait()
+
+	// a successful correlate means the dimension exists now
+	client.negativeCache.add(dimensionKey{name: "host", value: "test-box"}, time.Now())
+	done := make(chan struct{})
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {
+		close(done)
+	}))
+	<-done
+	require.Equal(t, 0, client.NegativeCacheLen())
+}
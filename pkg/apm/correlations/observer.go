@@ -0,0 +1,91 @@
+package correlations
+
+import (
+	"time"
+)
+
+// Observer is notified as requests move through the client, for detailed debugging.  Its methods
+// are invoked synchronously on the client's routines, outside of any locks, so they must be cheap
+// and must not block.  A panic in one of them is recovered and counted like one in a callback.  Gets are observed with a correlation that only has a dimension.  Embed
+// NopObserver to only implement some of the methods.
+type Observer interface {
+	// Enqueued is invoked when a request is accepted onto a queue
+	Enqueued(cor *Correlation, op Operation)
+	// Deduplicated is invoked when a queued request isn't sent because another request made it
+	// redundant
+	Deduplicated(cor *Correlation, op Operation)
+	// Sending is invoked when an attempt of a request is about to be sent.  attempt is 0 for
+	// the first attempt.
+	Sending(cor *Correlation, op Operation, attempt uint32)
+	// Succeeded is invoked when a request completes successfully
+	Succeeded(cor *Correlation, op Operation, statusCode int)
+	// Failed is invoked when a request fails and won't be retried
+	Failed(cor *Correlation, op Operation, statusCode int, err error)
+	// RetryScheduled is invoked when a failed attempt will be retried after the delay
+	RetryScheduled(cor *Correlation, op Operation, delay time.Duration)
+	// Dropped is invoked when a request is dropped before it completes
+	Dropped(cor *Correlation, op Operation, cause DropCause)
+}
+
+// NopObserver is an Observer that does nothing
+type NopObserver struct{}
+
+var _ Observer = NopObserver{}
+
+// Enqueued does nothing
+func (NopObserver) Enqueued(*Correlation, Operation) {}
+
+// Deduplicated does nothing
+func (NopObserver) Deduplicated(*Correlation, Operation) {}
+
+// Sending does nothing
+func (NopObserver) Sending(*Correlation, Operation, uint32) {}
+
+// Succeeded does nothing
+func (NopObserver) Succeeded(*Correlation, Operation, int) {}
+
+// Failed does nothing
+func (NopObserver) Failed(*Correlation, Operation, int, error) {}
+
+// RetryScheduled does nothing
+func (NopObserver) RetryScheduled(*Correlation, Operation, time.Duration) {}
+
+// Dropped does nothing
+func (NopObserver) Dropped(*Correlation, Operation, DropCause) {}
+
+// recoveringObserver invokes an Observer through invokeCallback so that a panicking Observer
+// can't take down the client's routines
+type recoveringObserver struct {
+	cc       *Client
+	observer Observer
+}
+
+var _ Observer = (*recoveringObserver)(nil)
+
+func (o *recoveringObserver) Enqueued(cor *Correlation, op Operation) {
+	o.cc.invokeCallback(cor, op, func() { o.observer.Enqueued(cor, op) })
+}
+
+func (o *recoveringObserver) Deduplicated(cor *Correlation, op Operation) {
+	o.cc.invokeCallback(cor, op, func() { o.observer.Deduplicated(cor, op) })
+}
+
+func (o *recoveringObserver) Sending(cor *Correlation, op Operation, attempt uint32) {
+	o.cc.invokeCallback(cor, op, func() { o.observer.Sending(cor, op, attempt) })
+}
+
+func (o *recoveringObserver) Succeeded(cor *Correlation, op Operation, statusCode int) {
+	o.cc.invokeCallback(cor, op, func() { o.observer.Succeeded(cor, op, statusCode) })
+}
+
+func (o *recoveringObserver) Failed(cor *Correlation, op Operation, statusCode int, err error) {
+	o.cc.invokeCallback(cor, op, func() { o.observer.Failed(cor, op, statusCode, err) })
+}
+
+func (o *recoveringObserver) RetryScheduled(cor *Correlation, op Operation, delay time.Duration) {
+	o.cc.invokeCallback(cor, op, func() { o.observer.RetryScheduled(cor, op, delay) })
+}
+
+func (o *recoveringObserver) Dropped(cor *Correlation, op Operation, cause DropCause) {
+	o.cc.invokeCallback(cor, op, func() { o.observer.Dropped(cor, op, cause) })
+}
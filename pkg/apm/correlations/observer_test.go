@@ -0,0 +1,124 @@
+package correlations
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordingObserver records the stages requests go through
+type recordingObserver struct {
+	NopObserver
+	lock   sync.Mutex
+	stages []string
+}
+
+func (o *recordingObserver) record(stage string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.stages = append(o.stages, stage)
+}
+
+func (o *recordingObserver) recorded() []string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return append([]string(nil), o.stages...)
+}
+
+func (o *recordingObserver) Enqueued(cor *Correlation, op Operation) {
+	o.record("enqueued " + cor.Value)
+}
+
+func (o *recordingObserver) Deduplicated(cor *Correlation, op Operation) {
+	o.record("deduplicated " + cor.Value)
+}
+
+func (o *recordingObserver) Sending(cor *Correlation, op Operation, attempt uint32) {
+	o.record(fmt.Sprintf("sending %s %d", cor.Value, attempt))
+}
+
+func (o *recordingObserver) Succeeded(cor *Correlation, op Operation, statusCode int) {
+	o.record(fmt.Sprintf("succeeded %s %d", cor.Value, statusCode))
+}
+
+func (o *recordingObserver) RetryScheduled(cor *Correlation, op Operation, delay time.Duration) {
+	o.record("retry " + cor.Value)
+}
+
+func (o *recordingObserver) Dropped(cor *Correlation, op Operation, cause DropCause) {
+	o.record(fmt.Sprintf("dropped %s %s", cor.Value, cause))
+}
+
+func TestCorrelationClientObserver(t *testing.T) {
+	var attempts int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// fail the first attempt so that the request is retried
+		if atomic.AddInt64(&attempts, 1) == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	observer := &recordingObserver{}
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.Observer = observer
+		conf.DeniedTypes = []Type{Environment}
+	})
+	defer cancel()
+
+	noop := CorrelateCB(func(_ *Correlation, _ error) {})
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, noop)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, noop)
+	client.Correlate(&Correlation{Type: Environment, DimName: "host", DimValue: "test-box", Value: "prod"}, noop)
+	client.Start()
+
+	// the duplicate is deduplicated while the first request is being sent
+	require.Eventually(t, func() bool { return len(observer.recorded()) == 8 }, 3*time.Second, time.Millisecond)
+	var sent []string
+	for _, stage := range observer.recorded() {
+		if stage != "deduplicated service" {
+			sent = append(sent, stage)
+		}
+	}
+	require.Equal(t, []string{
+		"enqueued service",
+		"enqueued service",
+		"dropped prod filtered_type",
+		"sending service 0",
+		"retry service",
+		"sending service 1",
+		"succeeded service 200",
+	}, sent)
+}
+
+// panickingObserver panics whenever a request is about to be sent
+type panickingObserver struct {
+	NopObserver
+}
+
+func (panickingObserver) Sending(*Correlation, Operation, uint32) {
+	panic("observer failed")
+}
+
+func TestCorrelationClientRecoversObserverPanics(t *testing.T) {
+	client, cancel := newTestClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}), func(conf *ClientConfig) {
+		conf.Observer = panickingObserver{}
+	})
+	defer cancel()
+	client.Start()
+
+	done := make(chan error, 1)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, err error) {
+		done <- err
+	}))
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("request was not sent after the observer panicked")
+	}
+	require.Equal(t, int64(1), atomic.LoadInt64(&client.TotalCallbackPanics))
+}
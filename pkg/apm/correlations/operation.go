@@ -0,0 +1,50 @@
+package correlations
+
+import (
+	"net/http"
+)
+
+// Operation is a type of request made against the correlation endpoint
+type Operation uint8
+
+const (
+	// OperationCorrelate creates a correlation
+	OperationCorrelate Operation = iota + 1
+	// OperationDelete removes a correlation
+	OperationDelete
+	// OperationGet retrieves the correlations for a dimension
+	OperationGet
+)
+
+// Operations returns every supported operation
+func Operations() []Operation {
+	return []Operation{OperationCorrelate, OperationDelete, OperationGet}
+}
+
+// Method returns the http method used to make requests for the operation or an empty string if the
+// operation is not supported
+func (o Operation) Method() string {
+	switch o {
+	case OperationCorrelate:
+		return http.MethodPut
+	case OperationDelete:
+		return http.MethodDelete
+	case OperationGet:
+		return http.MethodGet
+	default:
+		return ""
+	}
+}
+
+func (o Operation) String() string {
+	switch o {
+	case OperationCorrelate:
+		return "correlate"
+	case OperationDelete:
+		return "delete"
+	case OperationGet:
+		return "get"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,51 @@
+package correlations
+
+import (
+	"time"
+)
+
+// Priority determines the order that queued requests are sent in
+type Priority uint8
+
+const (
+	// PriorityNormal requests are sent in the order they are made
+	PriorityNormal Priority = iota
+	// PriorityHigh requests are sent before any queued normal priority requests
+	PriorityHigh
+)
+
+// RequestOptions are optional settings that override the client's defaults for a single request.
+// The zero value of each field keeps the client's default.
+type RequestOptions struct {
+	// RetryDelay is how long to wait before retrying the request
+	RetryDelay time.Duration
+	// Priority of the request relative to other queued requests
+	Priority Priority
+	// TTL is how long the backend should keep the correlation for, it is only sent when the
+	// client has a TTLHeader
+	TTL time.Duration
+	// Source identifies the part of the agent that made the request.  Requests are counted per
+	// source and the source is included in logs about the request.
+	Source string
+}
+
+// mergeRequestOptions merges request options into a single set of options.  Set fields in
+// later options take precedence over earlier ones.
+func mergeRequestOptions(opts []RequestOptions) RequestOptions {
+	var merged RequestOptions
+	for _, o := range opts {
+		if o.RetryDelay > 0 {
+			merged.RetryDelay = o.RetryDelay
+		}
+		if o.Priority != PriorityNormal {
+			merged.Priority = o.Priority
+		}
+		if o.TTL > 0 {
+			merged.TTL = o.TTL
+		}
+		if o.Source != "" {
+			merged.Source = o.Source
+		}
+	}
+	return merged
+}
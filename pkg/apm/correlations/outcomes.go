@@ -0,0 +1,72 @@
+package correlations
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/signalfx/signalfx-agent/pkg/apm/requests/requestcounter"
+)
+
+// RequestOutcome is the terminal outcome of a request, streamed on the channel returned by Results
+type RequestOutcome struct {
+	Correlation *Correlation
+	Operation   Operation
+	// StatusCode is the status code of the last response, 0 if no response was received
+	StatusCode int
+	// Err is nil if the request succeeded.  It is a *DroppedError if the request was dropped and
+	// a *RequestError if it failed.
+	Err error
+	// Attempts is the number of times the request was sent
+	Attempts uint32
+}
+
+func validateResults(conf Config) error {
+	if conf.ResultsBlock && conf.ResultsBuffered == 0 {
+		return errors.New("correlation results_block requires results_buffered to be set")
+	}
+	return nil
+}
+
+// Results returns the channel the outcome of every request that succeeded, failed or was dropped
+// is sent on, in addition to invoking its callback.  Requests that were deduplicated or collapsed
+// don't have an outcome.  It is nil unless ResultsBuffered is set, and it is never closed.
+func (cc *Client) Results() <-chan RequestOutcome {
+	return cc.results
+}
+
+// emitOutcome sends the outcome of the request on the results channel if it is enabled.  When the
+// channel is full the outcome is dropped and counted, unless ResultsBlock is set, in which case
+// it waits for the consumer or for the client to be stopped.
+func (cc *Client) emitOutcome(r *request, statusCode int, err error, attempts uint32) {
+	if cc.results == nil {
+		return
+	}
+	outcome := RequestOutcome{
+		Correlation: r.Correlation,
+		Operation:   r.operation,
+		StatusCode:  statusCode,
+		Err:         err,
+		Attempts:    attempts,
+	}
+	if cc.resultsBlock {
+		select {
+		case cc.results <- outcome:
+		case <-cc.ctx.Done():
+			atomic.AddInt64(&cc.TotalDroppedOutcomes, int64(1))
+		}
+		return
+	}
+	select {
+	case cc.results <- outcome:
+	default:
+		atomic.AddInt64(&cc.TotalDroppedOutcomes, int64(1))
+	}
+}
+
+// attemptsMade returns the number of times a request that is waiting to be sent was already sent
+func attemptsMade(r *request) uint32 {
+	if r.ctx == nil {
+		return 0
+	}
+	return requestcounter.GetRequestCount(r.ctx)
+}
@@ -0,0 +1,138 @@
+package correlations
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateResults(t *testing.T) {
+	require.NoError(t, validateResults(Config{}))
+	require.NoError(t, validateResults(Config{ResultsBuffered: 10, ResultsBlock: true}))
+	require.Error(t, validateResults(Config{ResultsBlock: true}))
+}
+
+func TestCorrelationClientResults(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "/bad/"):
+			rw.WriteHeader(http.StatusBadRequest)
+		case strings.Contains(r.URL.Path, "/down/"):
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.MaxRetries = 1
+		conf.ResultsBuffered = 10
+	})
+	defer cancel()
+	client.Start()
+
+	next := func() RequestOutcome {
+		select {
+		case outcome := <-client.Results():
+			return outcome
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "no outcome")
+			return RequestOutcome{}
+		}
+	}
+
+	cor := &Correlation{Type: Service, DimName: "host", DimValue: "good", Value: "a"}
+	client.Correlate(cor, nil)
+	outcome := next()
+	require.Equal(t, RequestOutcome{Correlation: cor, Operation: OperationCorrelate, StatusCode: http.StatusOK, Attempts: 1}, outcome)
+
+	client.Delete(&Correlation{Type: Service, DimName: "host", DimValue: "bad", Value: "a"}, nil)
+	outcome = next()
+	require.Equal(t, OperationDelete, outcome.Operation)
+	require.Equal(t, http.StatusBadRequest, outcome.StatusCode)
+	require.Equal(t, uint32(1), outcome.Attempts)
+	var reqErr *RequestError
+	require.True(t, errors.As(outcome.Err, &reqErr))
+	require.Equal(t, http.StatusBadRequest, reqErr.Status)
+
+	// a request that used up its retries is reported once, as a failure
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "down", Value: "a"}, nil)
+	outcome = next()
+	require.Equal(t, http.StatusServiceUnavailable, outcome.StatusCode)
+	require.Equal(t, uint32(3), outcome.Attempts)
+	require.True(t, errors.As(outcome.Err, &reqErr))
+
+	client.Correlate(&Correlation{Type: Service, DimName: "host", Value: "a"}, nil)
+	outcome = next()
+	require.Equal(t, uint32(0), outcome.Attempts)
+	cause, ok := dropCauseForErr(outcome.Err)
+	require.True(t, ok)
+	require.Equal(t, DropCauseInvalidDimension, cause)
+
+	require.Len(t, client.Results(), 0)
+}
+
+func TestCorrelationClientResultsFull(t *testing.T) {
+	client, cancel := newTestClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}), func(conf *ClientConfig) {
+		conf.ResultsBuffered = 1
+	})
+	defer cancel()
+	client.Start()
+
+	done := make(chan struct{}, 2)
+	cb := CorrelateCB(func(_ *Correlation, err error) { done <- struct{}{} })
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "a"}, cb)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "b"}, cb)
+	<-done
+	<-done
+
+	// the outcome is sent after the callback is invoked
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&client.TotalDroppedOutcomes) == 1 }, 5*time.Second, 10*time.Millisecond)
+	require.Len(t, client.Results(), 1)
+}
+
+func TestCorrelationClientResultsBlock(t *testing.T) {
+	client, cancel := newTestClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}), func(conf *ClientConfig) {
+		conf.ResultsBuffered = 1
+		conf.ResultsBlock = true
+	})
+	defer cancel()
+	client.Start()
+
+	for _, value := range []string{"a", "b", "c"} {
+		client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: value}, nil)
+	}
+
+	for range []string{"a", "b", "c"} {
+		select {
+		case outcome := <-client.Results():
+			require.NoError(t, outcome.Err)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "outcome was dropped")
+		}
+	}
+	require.Equal(t, int64(0), atomic.LoadInt64(&client.TotalDroppedOutcomes))
+
+	// a blocked client gives up on the consumer when it is stopped
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "d"}, nil)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "e"}, nil)
+	require.Eventually(t, func() bool { return len(client.Results()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	stopped := make(chan struct{})
+	go func() {
+		client.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "client didn't stop")
+	}
+}
+
+func TestResultsDisabled(t *testing.T) {
+	client, cancel := newTestClient(t, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}), nil)
+	defer cancel()
+	require.Nil(t, client.Results())
+}
@@ -0,0 +1,86 @@
+package correlations
+
+import (
+	"sync"
+)
+
+// pauser tracks whether the client is paused
+// this is threadsafe
+type pauser struct {
+	sync.Mutex
+	rejectNew bool
+	// resumed is closed when the client is resumed, it is nil while the client isn't paused
+	resumed chan struct{}
+}
+
+func (p *pauser) pause(rejectNew bool) {
+	p.Lock()
+	defer p.Unlock()
+	if p.resumed == nil {
+		p.resumed = make(chan struct{})
+	}
+	p.rejectNew = rejectNew
+}
+
+func (p *pauser) resume() {
+	p.Lock()
+	defer p.Unlock()
+	if p.resumed != nil {
+		close(p.resumed)
+		p.resumed = nil
+	}
+	p.rejectNew = false
+}
+
+// waitCh returns a channel that is closed once the client is resumed, or nil if it isn't paused
+func (p *pauser) waitCh() <-chan struct{} {
+	p.Lock()
+	defer p.Unlock()
+	if p.resumed == nil {
+		return nil
+	}
+	return p.resumed
+}
+
+// rejecting returns whether new requests are rejected
+func (p *pauser) rejecting() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.rejectNew
+}
+
+// Pause stops the client from sending requests until Resume is called, e.g. during maintenance of
+// the correlation endpoint.  Requests that are already in flight complete and requests waiting to
+// be retried stay queued.  If rejectNew is true, new requests fail immediately with ErrPaused,
+// which their callback is invoked with and which the methods that return an error return.
+// Otherwise they are queued as usual, so once the buffers are full further requests are handled
+// according to the DropPolicy and OverflowBuffered, and are dropped with ErrChFull if there's no
+// room for them.  Pausing an already paused client only changes whether new requests are rejected.
+func (cc *Client) Pause(rejectNew bool) {
+	cc.pauser.pause(rejectNew)
+}
+
+// Resume resumes sending requests after Pause.  Queued requests are sent in the order they would
+// have been, and retries that became due while paused are sent straight away.
+func (cc *Client) Resume() {
+	cc.pauser.resume()
+}
+
+// Paused returns whether the client is paused
+func (cc *Client) Paused() bool {
+	return cc.pauser.waitCh() != nil
+}
+
+// waitWhilePaused blocks while the client is paused.  It returns false if the client is shutdown.
+func (cc *Client) waitWhilePaused() bool {
+	resumed := cc.pauser.waitCh()
+	if resumed == nil {
+		return true
+	}
+	select {
+	case <-resumed:
+		return true
+	case <-cc.ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,96 @@
+package correlations
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelationClientPause(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, nil)
+	defer close(serverCh)
+	defer cancel()
+	client.Start()
+
+	client.Pause(false)
+	require.True(t, client.Paused())
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {}))
+	require.Len(t, waitForCors(serverCh, 1, 1), 0)
+
+	client.Resume()
+	require.False(t, client.Paused())
+	require.Len(t, waitForCors(serverCh, 1, 3), 1)
+}
+
+func TestCorrelationClientPauseRejectsNew(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, nil)
+	defer close(serverCh)
+	defer cancel()
+	client.Start()
+
+	client.Pause(true)
+	cor := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}
+	results := make(chan map[*Correlation]error, 1)
+	client.DeleteMany(context.Background(), []*Correlation{cor}, DeleteManyCB(func(r map[*Correlation]error) { results <- r }))
+	err := (<-results)[cor]
+	require.Equal(t, ErrPaused, err)
+	require.True(t, err.(CorrelationError).Retryable())
+	require.Equal(t, int64(1), client.TotalDropped(DropCausePaused))
+	_, err = client.GetSync(context.Background(), "host", "test-box")
+	require.True(t, errors.Is(err, ErrPaused))
+
+	// a plain correlate reports it through its callback
+	errs := make(chan error, 1)
+	client.Correlate(cor, func(_ *Correlation, err error) { errs <- err })
+	select {
+	case err = <-errs:
+		require.Equal(t, ErrPaused, err)
+	default:
+		t.Fatal("the correlate callback wasn't invoked")
+	}
+	require.Equal(t, int64(3), client.TotalDropped(DropCausePaused))
+
+	// requests are accepted again once resumed
+	client.Resume()
+	client.DeleteMany(context.Background(), []*Correlation{cor}, DeleteManyCB(func(r map[*Correlation]error) { results <- r }))
+	require.Len(t, waitForCors(serverCh, 1, 3), 1)
+	require.NoError(t, (<-results)[cor])
+}
+
+func TestCorrelationClientPauseHoldsRetries(t *testing.T) {
+	var attempts int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&attempts, 1) == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	var client *Client
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		// pause as soon as the first attempt fails
+		conf.OnRetryEnqueued = func(*Correlation, uint32) {
+			client.Pause(false)
+		}
+	})
+	defer cancel()
+	client.Start()
+
+	done := make(chan error, 1)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, err error) {
+		done <- err
+	}))
+	select {
+	case <-done:
+		t.Fatal("retry should wait until resumed")
+	case <-time.After(500 * time.Millisecond):
+	}
+	require.Equal(t, int64(1), atomic.LoadInt64(&attempts))
+
+	client.Resume()
+	require.NoError(t, <-done)
+	require.Equal(t, int64(2), atomic.LoadInt64(&attempts))
+}
@@ -0,0 +1,27 @@
+package correlations
+
+import (
+	"sync/atomic"
+)
+
+// reserveQueuedBytes accounts for the approximate memory used by the request while it is queued.
+// It returns false without reserving anything if that would exceed the maximum.
+func (cc *Client) reserveQueuedBytes(r *request) bool {
+	size := approxEntryBytes(r.Correlation)
+	if total := atomic.AddInt64(&cc.queuedBytes, size); cc.maxQueuedBytes > 0 && total > cc.maxQueuedBytes {
+		atomic.AddInt64(&cc.queuedBytes, -size)
+		return false
+	}
+	return true
+}
+
+// releaseQueuedBytes releases the memory reserved for the request once it is no longer queued
+func (cc *Client) releaseQueuedBytes(r *request) {
+	atomic.AddInt64(&cc.queuedBytes, -approxEntryBytes(r.Correlation))
+}
+
+// QueuedBytes returns the approximate memory in bytes used by requests waiting to be sent or
+// retried
+func (cc *Client) QueuedBytes() int64 {
+	return atomic.LoadInt64(&cc.queuedBytes)
+}
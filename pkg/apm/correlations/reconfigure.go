@@ -0,0 +1,156 @@
+package correlations
+
+import (
+	"container/heap"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/signalfx/signalfx-agent/pkg/apm/requests/requestcounter"
+)
+
+// hotFields are the Config fields that Reconfigure can change on a running client
+var hotFields = []string{
+	"RetryDelay",
+	"EnvironmentRetryDelays",
+	"InitialRetryDelay",
+	"MaxRetries",
+	"GetRetryDelay",
+	"GetMaxRetries",
+	"Retry",
+	"BackoffStrategy",
+	"MaxRetryDelay",
+	"TimeoutBackoffMultiplier",
+	"ConnectionBackoffMultiplier",
+	"DNSBackoffMultiplier",
+	"LogUpdates",
+	"DebugLogRequests",
+	"RetryLogVerbosity",
+}
+
+// retryScheduleFields are the hot fields that determine when a request is retried
+var retryScheduleFields = []string{
+	"RetryDelay",
+	"EnvironmentRetryDelays",
+	"InitialRetryDelay",
+	"GetRetryDelay",
+	"Retry",
+	"BackoffStrategy",
+	"MaxRetryDelay",
+	"TimeoutBackoffMultiplier",
+	"ConnectionBackoffMultiplier",
+	"DNSBackoffMultiplier",
+}
+
+var errRestartRequired = fmt.Errorf("only %s can be changed without recreating the correlation client", strings.Join(hotFields, ", "))
+
+// HotReloadableFields returns the names of the Config fields that Reconfigure can change on a
+// running client
+func HotReloadableFields() []string {
+	return append([]string(nil), hotFields...)
+}
+
+// Reconfigure applies configuration changes to a running client without losing queued requests.
+// Only the fields returned by HotReloadableFields, which are the retry delays and limits, the
+// backoff settings and the logging of updates, can be changed.  Changes to any other field, such
+// as buffer sizes, require recreating the client; if any are present an error is returned and
+// nothing is applied.  Requests that are already waiting to be retried are rescheduled from when
+// they failed if the retry delays change, unless the server said when to retry them.  The agent's
+// writer config can be converted with config.ClientConfigFromWriterConfig.
+func (cc *Client) Reconfigure(conf Config) error {
+	if err := validateBackoffStrategy(conf.BackoffStrategy); err != nil {
+		return err
+	}
+	if err := validateEnvironmentRetryDelays(conf.EnvironmentRetryDelays); err != nil {
+		return err
+	}
+	if err := validateRetryLogVerbosity(conf.RetryLogVerbosity); err != nil {
+		return err
+	}
+	if err := validateRetryConfig(conf.Retry); err != nil {
+		return err
+	}
+
+	cc.Lock()
+	defer cc.Unlock()
+
+	// everything except the hot fields must match the running configuration
+	cold := conf
+	copyFields(&cold, cc.conf, hotFields)
+	if !reflect.DeepEqual(cold, cc.conf) {
+		return errRestartRequired
+	}
+
+	reschedule := !fieldsEqual(conf, cc.conf, retryScheduleFields)
+	cc.conf = conf
+	cc.retryDelay = conf.RetryDelay
+	cc.initialRetryDelay = conf.InitialRetryDelay
+	cc.maxAttempts = uint32(conf.MaxRetries) + 1
+	cc.backoffStrategy = conf.BackoffStrategy
+	cc.maxRetryDelay = conf.MaxRetryDelay
+	cc.logUpdates = conf.LogUpdates
+	if reschedule {
+		select {
+		case cc.rescheduleRetriesCh <- struct{}{}:
+		default:
+			// a reschedule is already pending
+		}
+	}
+	return nil
+}
+
+// copyFields sets the named fields of dst to their values in src
+func copyFields(dst *Config, src Config, names []string) {
+	d, s := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src)
+	for _, name := range names {
+		d.FieldByName(name).Set(s.FieldByName(name))
+	}
+}
+
+// fieldsEqual returns whether the named fields are the same in both configs
+func fieldsEqual(a, b Config, names []string) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for _, name := range names {
+		if !reflect.DeepEqual(va.FieldByName(name).Interface(), vb.FieldByName(name).Interface()) {
+			return false
+		}
+	}
+	return true
+}
+
+// rescheduleRetries recomputes when each pending retry and each retry still on the retry channel
+// is sent with the current retry delays.  It is only used by processRetryChan.
+func (cc *Client) rescheduleRetries(pending *retryQueue) {
+	cc.drainRetryChan(pending)
+
+	for _, r := range *pending {
+		if r.retryHinted {
+			continue
+		}
+		// the attempt was counted when the request was queued to be retried
+		delay := cc.retryDelayAfter(r, requestcounter.GetRequestCount(r.ctx)-1)
+		delay = time.Duration(float64(delay) * cc.backoffMultiplier(r.retryClass))
+		sendAt := r.retryFrom.Add(delay)
+		if sendAt.Before(r.retryNotBefore) {
+			sendAt = r.retryNotBefore
+		}
+		r.setSendAt(sendAt)
+	}
+	heap.Init(pending)
+}
+
+// shouldLogUpdates returns whether a successful update should be logged
+func (cc *Client) shouldLogUpdates() bool {
+	cc.RLock()
+	logUpdates := cc.logUpdates
+	cc.RUnlock()
+	return logUpdates && cc.logSampler.sample()
+}
+
+// shouldDebugLogRequests returns whether the endpoint of each request should be logged
+func (cc *Client) shouldDebugLogRequests() bool {
+	cc.RLock()
+	defer cc.RUnlock()
+	return cc.conf.DebugLogRequests
+}
@@ -0,0 +1,62 @@
+package correlations
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// RedirectPolicy determines how redirects from the correlation endpoint are handled
+type RedirectPolicy string
+
+const (
+	// RedirectFollow follows redirects, signing the redirected request again if it is to the same
+	// host so that the access token isn't lost
+	RedirectFollow RedirectPolicy = "follow"
+	// RedirectNone doesn't follow redirects.  The redirect response fails the request without
+	// retrying it.
+	RedirectNone RedirectPolicy = "none"
+)
+
+// maxRedirects is the number of redirects followed before giving up, the same as the http
+// client's default
+const maxRedirects = 10
+
+func validateRedirectPolicy(p RedirectPolicy) error {
+	switch p {
+	case "", RedirectFollow, RedirectNone:
+		return nil
+	default:
+		return fmt.Errorf("invalid correlation redirect policy %q", p)
+	}
+}
+
+// redirectClient returns a copy of the client that handles redirects according to the policy.
+// The http client drops sensitive headers when redirected, so same host redirects are signed
+// again with the signer.  Redirects to other hosts are followed without the access token.  A
+// CheckRedirect already set on the client still decides whether a redirect is followed in place
+// of the default limit of 10 redirects, unless redirects aren't followed at all.
+func redirectClient(client *http.Client, policy RedirectPolicy, signer RequestSigner) *http.Client {
+	var c http.Client
+	if client != nil {
+		c = *client
+	}
+	checkRedirect := c.CheckRedirect
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if policy == RedirectNone {
+			return http.ErrUseLastResponse
+		}
+		if checkRedirect != nil {
+			if err := checkRedirect(req, via); err != nil {
+				return err
+			}
+		} else if len(via) >= maxRedirects {
+			return errors.New("stopped after 10 redirects")
+		}
+		if req.URL.Host == via[0].URL.Host {
+			return signer.Sign(req)
+		}
+		return nil
+	}
+	return &c
+}
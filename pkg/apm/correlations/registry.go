@@ -0,0 +1,168 @@
+package correlations
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// registry tracks the requests that have been accepted and haven't completed yet.  Requests are
+// removed when they are cancelled, which happens once they complete, and any that are missed are
+// removed by sweep once their context is done.  It holds at most max requests; requests made while
+// it is full aren't tracked, but are still counted as pending until they complete.
+// this is threadsafe
+type registry struct {
+	sync.Mutex
+	max     int
+	nextID  uint64
+	entries map[uint64]*request
+
+	// pending is the number of requests that have been queued and haven't completed
+	pending int64
+	// idle is closed while no requests are pending
+	idle chan struct{}
+
+	// totalUntracked is the number of requests that weren't tracked because the registry was full
+	totalUntracked int64
+}
+
+func newRegistry(max int) *registry {
+	idle := make(chan struct{})
+	close(idle)
+	return &registry{
+		max:     max,
+		entries: make(map[uint64]*request),
+		idle:    idle,
+	}
+}
+
+// track assigns the request an id and arranges for it to be removed from the registry when it is
+// cancelled.  It must be called before the request is shared with other goroutines; the request
+// isn't tracked until add is called.
+func (g *registry) track(r *request) {
+	r.id = atomic.AddUint64(&g.nextID, 1)
+	cancel := r.cancel
+	r.cancel = func() {
+		cancel()
+		g.remove(r)
+	}
+}
+
+// begin counts the request as pending until it is cancelled.  It must be called after track and
+// before the request is queued.
+func (g *registry) begin(r *request) {
+	g.Lock()
+	defer g.Unlock()
+	r.pending = true
+	if g.pending == 0 {
+		g.idle = make(chan struct{})
+	}
+	g.pending++
+}
+
+// add starts tracking the request unless it has already completed
+func (g *registry) add(r *request) {
+	g.Lock()
+	defer g.Unlock()
+	// a request cancelled before this point has already tried to remove itself
+	if r.ctx.Err() != nil {
+		return
+	}
+	if len(g.entries) >= g.max {
+		g.sweepLocked()
+		if len(g.entries) >= g.max {
+			atomic.AddInt64(&g.totalUntracked, 1)
+			return
+		}
+	}
+	g.entries[r.id] = r
+}
+
+// remove stops tracking the request and no longer counts it as pending
+func (g *registry) remove(r *request) {
+	g.Lock()
+	defer g.Unlock()
+	delete(g.entries, r.id)
+	g.finishLocked(r)
+}
+
+// finishLocked stops counting the request as pending
+func (g *registry) finishLocked(r *request) {
+	if !r.pending {
+		return
+	}
+	r.pending = false
+	g.pending--
+	if g.pending == 0 {
+		close(g.idle)
+	}
+}
+
+// idleCh returns a channel that is closed once no requests are pending.  A request queued after
+// it is closed doesn't reopen it, so the pending count must be checked again.
+func (g *registry) idleCh() <-chan struct{} {
+	g.Lock()
+	defer g.Unlock()
+	return g.idle
+}
+
+// pendingCount returns the number of requests that have been queued and haven't completed
+func (g *registry) pendingCount() int64 {
+	g.Lock()
+	defer g.Unlock()
+	return g.pending
+}
+
+// sweep removes the requests whose context is done and returns how many were removed
+func (g *registry) sweep() int {
+	g.Lock()
+	defer g.Unlock()
+	return g.sweepLocked()
+}
+
+func (g *registry) sweepLocked() int {
+	removed := 0
+	for id, r := range g.entries {
+		if r.ctx.Err() != nil {
+			delete(g.entries, id)
+			g.finishLocked(r)
+			removed++
+		}
+	}
+	return removed
+}
+
+// active returns the requests that haven't completed
+func (g *registry) active() []*request {
+	g.Lock()
+	defer g.Unlock()
+	active := make([]*request, 0, len(g.entries))
+	for _, r := range g.entries {
+		if r.ctx.Err() == nil {
+			active = append(active, r)
+		}
+	}
+	return active
+}
+
+// cancelAll cancels every tracked request and returns the requests that were cancelled.  The
+// requests are cancelled outside of the lock since cancelling a request removes it from the
+// registry.
+func (g *registry) cancelAll() []*request {
+	g.Lock()
+	entries := make([]*request, 0, len(g.entries))
+	for _, r := range g.entries {
+		entries = append(entries, r)
+	}
+	g.Unlock()
+	for _, r := range entries {
+		r.cancel()
+	}
+	return entries
+}
+
+// len returns the number of tracked requests
+func (g *registry) len() int {
+	g.Lock()
+	defer g.Unlock()
+	return len(g.entries)
+}
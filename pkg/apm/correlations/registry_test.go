@@ -0,0 +1,132 @@
+package correlations
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newRegisteredRequest(g *registry) (*request, context.CancelFunc) {
+	r := &request{Correlation: &Correlation{DimName: "host", DimValue: "test-box"}}
+	var rawCancel context.CancelFunc
+	r.ctx, rawCancel = context.WithCancel(context.Background())
+	r.cancel = rawCancel
+	g.track(r)
+	return r, rawCancel
+}
+
+func TestRegistryRemovesCompletedRequests(t *testing.T) {
+	g := newRegistry(10)
+	r, _ := newRegisteredRequest(g)
+	g.add(r)
+	require.Equal(t, []*request{r}, g.active())
+	r.cancel()
+	require.Zero(t, g.len())
+
+	// a request that completes before it is added isn't tracked
+	r, _ = newRegisteredRequest(g)
+	r.cancel()
+	g.add(r)
+	require.Zero(t, g.len())
+
+	// requests whose cancel was bypassed are removed by a sweep
+	r, rawCancel := newRegisteredRequest(g)
+	g.add(r)
+	rawCancel()
+	require.Empty(t, g.active())
+	require.Equal(t, 1, g.sweep())
+	require.Zero(t, g.len())
+}
+
+func TestRegistryIsBounded(t *testing.T) {
+	g := newRegistry(2)
+	var rawCancels []context.CancelFunc
+	for i := 0; i < 3; i++ {
+		r, rawCancel := newRegisteredRequest(g)
+		g.add(r)
+		rawCancels = append(rawCancels, rawCancel)
+	}
+	require.Equal(t, 2, g.len())
+	require.Equal(t, int64(1), g.totalUntracked)
+
+	// a full registry sweeps before refusing a request
+	rawCancels[0]()
+	r, _ := newRegisteredRequest(g)
+	g.add(r)
+	require.Equal(t, 2, g.len())
+	require.Equal(t, int64(1), g.totalUntracked)
+}
+
+func TestRegistryDoesNotLeakUnderChurn(t *testing.T) {
+	g := newRegistry(1000)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				r, rawCancel := newRegisteredRequest(g)
+				switch j % 3 {
+				case 0:
+					g.add(r)
+					r.cancel()
+				case 1:
+					// completes concurrently with being added
+					go r.cancel()
+					g.add(r)
+				default:
+					g.add(r)
+					rawCancel()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	require.Eventually(t, func() bool {
+		g.sweep()
+		return g.len() == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRegistryCountsPendingRequestsWhenFull(t *testing.T) {
+	g := newRegistry(1)
+	select {
+	case <-g.idleCh():
+	default:
+		t.Fatal("an empty registry should be idle")
+	}
+
+	var requests []*request
+	for i := 0; i < 3; i++ {
+		r, _ := newRegisteredRequest(g)
+		g.begin(r)
+		g.add(r)
+		requests = append(requests, r)
+	}
+	require.Equal(t, 1, g.len())
+	require.Equal(t, int64(3), g.pendingCount())
+
+	// untracked requests keep the registry from being idle until they complete
+	idle := g.idleCh()
+	requests[0].cancel()
+	requests[1].cancel()
+	require.Zero(t, g.len())
+	require.Equal(t, int64(1), g.pendingCount())
+	select {
+	case <-idle:
+		t.Fatal("the registry shouldn't be idle while a request is pending")
+	default:
+	}
+
+	requests[2].cancel()
+	requests[2].cancel()
+	require.Zero(t, g.pendingCount())
+	select {
+	case <-idle:
+	case <-time.After(time.Second):
+		t.Fatal("the registry should be idle once every request has completed")
+	}
+}
@@ -0,0 +1,64 @@
+package correlations
+
+import (
+	"time"
+)
+
+// defaultEnqueueRetryDelay is how long to wait between attempts to enqueue a request onto a full
+// channel if EnqueueRetryDelay isn't set
+const defaultEnqueueRetryDelay = 100 * time.Millisecond
+
+// requeue tries again in the background to put a request that didn't fit onto the channel, up to
+// the configured number of attempts.  It returns false without taking the request if enqueue
+// retries are disabled or too many requests are already being requeued, so that the number of
+// goroutines is bounded.  A request that still doesn't fit is dropped and its callback is invoked
+// with ErrChFull.
+func (cc *Client) requeue(requestChan chan *request, r *request) bool {
+	if cc.enqueueRetries == 0 {
+		return false
+	}
+	select {
+	case cc.requeueSlots <- struct{}{}:
+	default:
+		return false
+	}
+
+	go func() {
+		defer func() { <-cc.requeueSlots }()
+		timer := time.NewTimer(cc.enqueueRetryDelay)
+		defer timer.Stop()
+		for attempt := uint(0); attempt < cc.enqueueRetries; attempt++ {
+			select {
+			case <-timer.C:
+			case <-r.ctx.Done():
+				cc.releaseQueuedBytes(r)
+				cc.recordDrop(r, DropCauseCancelled)
+				cc.completeFailed(r, nil, 0, nil, cc.cancelledErr())
+				return
+			case <-cc.ctx.Done():
+				cc.dropRequeued(r, errShutdown)
+				return
+			}
+			select {
+			case requestChan <- r:
+				return
+			default:
+			}
+			timer.Reset(cc.enqueueRetryDelay)
+		}
+		cc.health.recordFailure("request channel is full", cc.now())
+		cc.dropRequeued(r, ErrChFull)
+	}()
+	return true
+}
+
+// dropRequeued drops a request that couldn't be requeued
+func (cc *Client) dropRequeued(r *request, err error) {
+	cc.releaseQueuedBytes(r)
+	if cc.coalescing(r) {
+		cc.coalescer.take(r)
+	}
+	cc.recordDropForErr(r, err)
+	cc.completeFailed(r, nil, 0, nil, err)
+	r.cancel()
+}
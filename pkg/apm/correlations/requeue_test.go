@@ -0,0 +1,46 @@
+package correlations
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelationClientEnqueueRetries(t *testing.T) {
+	// the client isn't started so nothing drains the request channel
+	client, serverCh, _, _, cancel := setupUnstarted(t, func(conf *ClientConfig) {
+		conf.EnqueueRetries = 3
+		conf.EnqueueRetryDelay = 20 * time.Millisecond
+	})
+	defer close(serverCh)
+	defer cancel()
+
+	noop := CorrelateCB(func(_ *Correlation, _ error) {})
+	for i := 0; i < cap(client.requestChan); i++ {
+		client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: fmt.Sprintf("service-%d", i)}, noop)
+	}
+
+	// a request that finds the channel full is enqueued once there's room
+	requeued := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "requeued"}
+	client.Correlate(requeued, noop)
+	<-client.requestChan
+	require.Eventually(t, func() bool {
+		return len(client.requestChan) == cap(client.requestChan)
+	}, time.Second, time.Millisecond)
+	require.Zero(t, client.TotalDropped(DropCauseChannelFull))
+
+	// a request that never finds room is dropped once its retries are used up
+	errs := make(chan error, 1)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "dropped"}, CorrelateCB(func(_ *Correlation, err error) {
+		errs <- err
+	}))
+	select {
+	case err := <-errs:
+		require.Equal(t, ErrChFull, err)
+	case <-time.After(time.Second):
+		t.Fatal("request was not dropped")
+	}
+	require.Equal(t, int64(1), client.TotalDropped(DropCauseChannelFull))
+}
@@ -0,0 +1,90 @@
+package correlations
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// maxRetryIntervalEntries bounds the number of correlations the retry limiter remembers.  Once it
+// is full, retries of further correlations aren't limited until entries expire.
+const maxRetryIntervalEntries = 10000
+
+func validateMinRetryInterval(conf Config) error {
+	if conf.MinRetryInterval < 0 {
+		return errors.New("correlation min retry interval must not be negative")
+	}
+	return nil
+}
+
+type retryKey struct {
+	dimensionKey
+	typ   Type
+	value string
+}
+
+func retryKeyFor(cor *Correlation) retryKey {
+	return retryKey{dimensionKey: dimensionKey{name: cor.DimName, value: cor.DimValue}, typ: cor.Type, value: cor.Value}
+}
+
+// retryLimiter remembers when each correlation was last scheduled to be retried so that retries of
+// it can be spaced out, however many requests for it are failing.
+// this is threadsafe
+type retryLimiter struct {
+	sync.Mutex
+	interval time.Duration
+	last     map[retryKey]time.Time
+}
+
+func newRetryLimiter(interval time.Duration) *retryLimiter {
+	return &retryLimiter{
+		interval: interval,
+		last:     make(map[retryKey]time.Time),
+	}
+}
+
+// earliest returns the earliest time the correlation may be retried, which is the minimum interval
+// after its last retry, or the zero time if it may be retried at any time.  A nil limiter never
+// delays retries.
+func (l *retryLimiter) earliest(key retryKey, now time.Time) time.Time {
+	if l == nil {
+		return time.Time{}
+	}
+	l.Lock()
+	defer l.Unlock()
+	if last, ok := l.last[key]; ok && now.Sub(last) < l.interval {
+		return last.Add(l.interval)
+	}
+	return time.Time{}
+}
+
+// record remembers that the correlation is scheduled to be retried at the time.  Retries of
+// correlations that don't fit once it is full aren't remembered, and so aren't delayed.
+func (l *retryLimiter) record(key retryKey, at time.Time, now time.Time) {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	if last, ok := l.last[key]; ok {
+		if at.After(last) {
+			l.last[key] = at
+		}
+		return
+	}
+	if len(l.last) >= maxRetryIntervalEntries {
+		l.expireLocked(now)
+		if len(l.last) >= maxRetryIntervalEntries {
+			return
+		}
+	}
+	l.last[key] = at
+}
+
+func (l *retryLimiter) expireLocked(now time.Time) {
+	for key, last := range l.last {
+		if now.Sub(last) >= l.interval {
+			delete(l.last, key)
+		}
+	}
+}
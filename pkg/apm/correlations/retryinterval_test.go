@@ -0,0 +1,87 @@
+package correlations
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateMinRetryInterval(t *testing.T) {
+	require.NoError(t, validateMinRetryInterval(Config{}))
+	require.NoError(t, validateMinRetryInterval(Config{MinRetryInterval: time.Minute}))
+	require.Error(t, validateMinRetryInterval(Config{MinRetryInterval: -time.Second}))
+}
+
+func TestRetryLimiter(t *testing.T) {
+	var limiter *retryLimiter
+	key := retryKeyFor(&Correlation{Type: Service, DimName: "host", DimValue: "a", Value: "svc"})
+	now := time.Unix(1000, 0)
+	require.True(t, limiter.earliest(key, now).IsZero(), "a nil limiter doesn't limit")
+	limiter.record(key, now, now)
+
+	limiter = newRetryLimiter(time.Minute)
+	require.True(t, limiter.earliest(key, now).IsZero())
+	limiter.record(key, now, now)
+	require.Equal(t, now.Add(time.Minute), limiter.earliest(key, now.Add(59*time.Second)))
+	require.True(t, limiter.earliest(key, now.Add(time.Minute)).IsZero())
+
+	// a retry scheduled later pushes back the next one, while an earlier one doesn't
+	limiter.record(key, now.Add(time.Minute), now)
+	limiter.record(key, now.Add(30*time.Second), now)
+	require.Equal(t, now.Add(2*time.Minute), limiter.earliest(key, now.Add(time.Minute)))
+
+	// each correlation is limited separately
+	other := retryKeyFor(&Correlation{Type: Service, DimName: "host", DimValue: "a", Value: "other"})
+	require.True(t, limiter.earliest(other, now).IsZero())
+
+	// expired entries make room once it is full
+	for i := 0; i < maxRetryIntervalEntries; i++ {
+		limiter.last[retryKey{value: strconv.Itoa(i)}] = now
+	}
+	newKey := retryKeyFor(&Correlation{Value: "new"})
+	limiter.record(newKey, now.Add(2*time.Minute), now.Add(2*time.Minute))
+	require.Len(t, limiter.last, 1)
+	require.Equal(t, now.Add(3*time.Minute), limiter.earliest(newKey, now.Add(2*time.Minute)))
+}
+
+func TestCorrelationClientMinRetryInterval(t *testing.T) {
+	var attempts int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&attempts, 1)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.MaxRetries = 1
+		conf.MinRetryInterval = time.Hour
+	})
+	defer cancel()
+	client.Start()
+
+	done := make(chan error, 1)
+	cb := CorrelateCB(func(_ *Correlation, err error) { done <- err })
+	cor := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "a"}
+
+	// the first retry is sent after the retry delay and the second waits out the interval
+	client.Correlate(cor, cb)
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&attempts) == 2 && atomic.LoadInt64(&client.retryQueueLen) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	var pending []PendingRequest
+	exported, err := client.ExportPending()
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(exported, &pending))
+	require.Len(t, pending, 1)
+	require.NotNil(t, pending[0].SendAt)
+	require.True(t, pending[0].SendAt.After(time.Now().Add(59*time.Minute)))
+	require.Zero(t, client.TotalDropped(DropCauseMaxAttempts))
+
+	// it is sent once the interval has passed
+	client.FlushRetries()
+	require.Error(t, <-done)
+	require.Equal(t, int64(3), atomic.LoadInt64(&attempts))
+}
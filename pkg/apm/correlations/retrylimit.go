@@ -0,0 +1,39 @@
+package correlations
+
+import (
+	"sync/atomic"
+)
+
+// acquireRetrySlot waits for a slot to resend a request from the retry queue if the number of
+// retries in flight is limited.  It returns false if the client is shutdown while waiting.
+func (cc *Client) acquireRetrySlot(r *request) bool {
+	if cc.retrySlots != nil {
+		select {
+		case cc.retrySlots <- struct{}{}:
+		case <-cc.ctx.Done():
+			return false
+		}
+	}
+	r.retrying = true
+	atomic.AddInt64(&cc.retriesInFlight, 1)
+	return true
+}
+
+// releaseRetrySlot releases the slot claimed by acquireRetrySlot once the retried attempt has
+// completed or been abandoned.  It does nothing if the request doesn't hold a slot.
+func (cc *Client) releaseRetrySlot(r *request) {
+	if !r.retrying {
+		return
+	}
+	r.retrying = false
+	atomic.AddInt64(&cc.retriesInFlight, -1)
+	if cc.retrySlots != nil {
+		<-cc.retrySlots
+	}
+}
+
+// RetriesInFlight returns the number of requests resent from the retry queue that haven't
+// completed yet
+func (cc *Client) RetriesInFlight() int64 {
+	return atomic.LoadInt64(&cc.retriesInFlight)
+}
@@ -0,0 +1,72 @@
+package correlations
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelationClientMaxRetriesInFlight(t *testing.T) {
+	var (
+		lock        sync.Mutex
+		attempts    = map[string]int{}
+		concurrent  int
+		maxObserved int
+	)
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		attempts[r.URL.Path]++
+		first := attempts[r.URL.Path] == 1
+		if !first {
+			concurrent++
+			if concurrent > maxObserved {
+				maxObserved = concurrent
+			}
+		}
+		lock.Unlock()
+		// fail the first attempt of each request so that they are all retried at once
+		if first {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		<-release
+		lock.Lock()
+		concurrent--
+		lock.Unlock()
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.MaxRetriesInFlight = 1
+	})
+	defer cancel()
+	client.Start()
+
+	done := make(chan struct{}, 3)
+	for i := 0; i < 3; i++ {
+		client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: fmt.Sprintf("box-%d", i), Value: "service"}, CorrelateCB(func(_ *Correlation, _ error) {
+			done <- struct{}{}
+		}))
+	}
+
+	require.Eventually(t, func() bool { return client.RetriesInFlight() == 1 }, 3*time.Second, time.Millisecond)
+	// the other retries wait for the slot rather than being sent
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, int64(1), client.RetriesInFlight())
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Fatal("retries did not complete")
+		}
+	}
+	require.Eventually(t, func() bool { return client.RetriesInFlight() == 0 }, time.Second, time.Millisecond)
+	lock.Lock()
+	defer lock.Unlock()
+	require.Equal(t, 1, maxObserved)
+}
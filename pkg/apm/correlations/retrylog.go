@@ -0,0 +1,70 @@
+package correlations
+
+import (
+	"fmt"
+
+	"github.com/signalfx/signalfx-agent/pkg/apm/log"
+	"github.com/signalfx/signalfx-agent/pkg/apm/requests/requestcounter"
+)
+
+// RetryLogVerbosity determines how much is logged about requests that are retried
+type RetryLogVerbosity string
+
+const (
+	// RetryLogAll logs each retry at debug level
+	RetryLogAll RetryLogVerbosity = "all"
+	// RetryLogFirstAndLast logs the first retry of a request and whether it finally succeeded or
+	// failed, throttled
+	RetryLogFirstAndLast RetryLogVerbosity = "first_and_last"
+	// RetryLogTerminal only logs whether a retried request finally succeeded or failed, throttled
+	RetryLogTerminal RetryLogVerbosity = "terminal"
+)
+
+// validateRetryLogVerbosity returns an error if the verbosity isn't known.  An empty verbosity is
+// treated as RetryLogAll.
+func validateRetryLogVerbosity(verbosity RetryLogVerbosity) error {
+	switch verbosity {
+	case "", RetryLogAll, RetryLogFirstAndLast, RetryLogTerminal:
+		return nil
+	default:
+		return fmt.Errorf("invalid correlation retry log verbosity %q", verbosity)
+	}
+}
+
+func (cc *Client) retryLogVerbosity() RetryLogVerbosity {
+	cc.RLock()
+	defer cc.RUnlock()
+	if cc.conf.RetryLogVerbosity == "" {
+		return RetryLogAll
+	}
+	return cc.conf.RetryLogVerbosity
+}
+
+// logRetry logs that the request has been scheduled to be retried after an attempt failed with
+// the error
+func (cc *Client) logRetry(r *request, err error) {
+	switch cc.retryLogVerbosity() {
+	case RetryLogAll:
+		r.Logger(cc.log).WithError(err).WithFields(log.Fields{"method": r.operation.Method()}).Debug("Unable to update dimension, retrying")
+	case RetryLogFirstAndLast:
+		// the retry has already been counted
+		if requestcounter.GetRequestCount(r.ctx) == 1 {
+			r.ThrottledLogger(cc.throttledLog).WithError(err).WithFields(log.Fields{"method": r.operation.Method()}).ThrottledDebug("Unable to update dimension, retrying")
+		}
+	}
+}
+
+// logRetriedOutcome logs whether a request that was retried finally succeeded or failed, unless
+// each retry is logged.  err is nil if it succeeded.
+func (cc *Client) logRetriedOutcome(r *request, err error) {
+	attempts := requestcounter.GetRequestCount(r.ctx)
+	if attempts == 0 || cc.retryLogVerbosity() == RetryLogAll {
+		return
+	}
+	logger := r.ThrottledLogger(cc.throttledLog).WithFields(log.Fields{"method": r.operation.Method(), "attempts": attempts + 1})
+	if err != nil {
+		logger.WithError(err).ThrottledDebug("Unable to update dimension after retrying, not retrying")
+		return
+	}
+	logger.ThrottledDebug("Updated dimension after retrying")
+}
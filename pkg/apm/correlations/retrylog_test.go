@@ -0,0 +1,101 @@
+package correlations
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/signalfx/signalfx-agent/pkg/apm/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateRetryLogVerbosity(t *testing.T) {
+	require.NoError(t, validateRetryLogVerbosity(""))
+	require.NoError(t, validateRetryLogVerbosity(RetryLogAll))
+	require.NoError(t, validateRetryLogVerbosity(RetryLogFirstAndLast))
+	require.NoError(t, validateRetryLogVerbosity(RetryLogTerminal))
+	require.Error(t, validateRetryLogVerbosity("none"))
+}
+
+func TestCorrelationClientRetryLogVerbosity(t *testing.T) {
+	for _, tc := range []struct {
+		verbosity RetryLogVerbosity
+		logged    []string
+		throttled []string
+	}{
+		{
+			verbosity: "",
+			logged:    []string{"Unable to update dimension, retrying", "Unable to update dimension, retrying"},
+		},
+		{
+			verbosity: RetryLogFirstAndLast,
+			throttled: []string{"Unable to update dimension, retrying", "Updated dimension after retrying"},
+		},
+		{
+			verbosity: RetryLogTerminal,
+			throttled: []string{"Updated dimension after retrying"},
+		},
+	} {
+		tc := tc
+		t.Run(string(tc.verbosity), func(t *testing.T) {
+			var attempts int64
+			handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				// succeed on the third attempt
+				if atomic.AddInt64(&attempts, 1) < 3 {
+					rw.WriteHeader(http.StatusServiceUnavailable)
+				}
+			})
+			client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+				conf.RetryLogVerbosity = tc.verbosity
+			})
+			defer cancel()
+			logged := make(chan string, 10)
+			throttled := make(chan string, 10)
+			client.log = debugLogger{Logger: log.Nil, messages: logged}
+			client.throttledLog = log.NewThrottledLogger(debugLogger{Logger: log.Nil, messages: throttled}, time.Minute)
+			client.Start()
+
+			done := make(chan error, 1)
+			client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, err error) {
+				done <- err
+			}))
+			require.NoError(t, <-done)
+			require.Equal(t, tc.logged, drainMessages(logged))
+			require.Equal(t, tc.throttled, drainMessages(throttled))
+		})
+	}
+}
+
+func TestCorrelationClientRetryLogVerbosityFailure(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.RetryLogVerbosity = RetryLogTerminal
+	})
+	defer cancel()
+	throttled := make(chan string, 10)
+	client.throttledLog = log.NewThrottledLogger(debugLogger{Logger: log.Nil, messages: throttled}, time.Minute)
+	client.Start()
+
+	done := make(chan error, 1)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, err error) {
+		done <- err
+	}))
+	require.Error(t, <-done)
+	require.Equal(t, []string{"Unable to update dimension after retrying, not retrying"}, drainMessages(throttled))
+}
+
+// drainMessages returns the messages that have been logged so far
+func drainMessages(messages chan string) []string {
+	var drained []string
+	for {
+		select {
+		case msg := <-messages:
+			drained = append(drained, msg)
+		default:
+			return drained
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package correlations
+
+import (
+	"fmt"
+	"time"
+)
+
+// OperationRetry configures how failed requests of one operation are retried.  Its zero value
+// retries them like every other request.
+type OperationRetry struct {
+	// Disabled turns off retries of the operation so that a request fails on its first failed
+	// attempt
+	Disabled bool `mapstructure:"disabled"`
+	// MaxRetries replaces MaxRetries for the operation.  Defaults to MaxRetries when 0.
+	MaxRetries uint `mapstructure:"max_retries"`
+	// RetryDelay is the base of the backoff between retries of the operation in place of
+	// RetryDelay and InitialRetryDelay.  Defaults to those when 0.
+	RetryDelay time.Duration `mapstructure:"retry_delay"`
+}
+
+// RetryConfig configures retries separately for each operation
+type RetryConfig struct {
+	Correlate OperationRetry `mapstructure:"correlate"`
+	Delete    OperationRetry `mapstructure:"delete"`
+	Get       OperationRetry `mapstructure:"get"`
+}
+
+// forOperation returns the retry config of the operation
+func (c RetryConfig) forOperation(op Operation) OperationRetry {
+	switch op {
+	case OperationCorrelate:
+		return c.Correlate
+	case OperationDelete:
+		return c.Delete
+	case OperationGet:
+		return c.Get
+	default:
+		return OperationRetry{}
+	}
+}
+
+func validateRetryConfig(c RetryConfig) error {
+	for _, op := range Operations() {
+		retry := c.forOperation(op)
+		if retry.RetryDelay < 0 {
+			return fmt.Errorf("correlation retry delay of %s requests must not be negative", op)
+		}
+		if retry.Disabled && retry.MaxRetries > 0 {
+			return fmt.Errorf("correlation retries of %s requests are disabled but max retries is set", op)
+		}
+	}
+	return nil
+}
+
+// operationRetry returns how requests for the operation are retried.  GetMaxRetries and
+// GetRetryDelay are used for Gets unless Retry.Get sets its own.
+func (cc *Client) operationRetry(op Operation) OperationRetry {
+	cc.RLock()
+	defer cc.RUnlock()
+	return operationRetry(cc.conf, op)
+}
+
+func operationRetry(conf Config, op Operation) OperationRetry {
+	retry := conf.Retry.forOperation(op)
+	if op == OperationGet {
+		if retry.MaxRetries == 0 {
+			retry.MaxRetries = conf.GetMaxRetries
+		}
+		if retry.RetryDelay == 0 {
+			retry.RetryDelay = conf.GetRetryDelay
+		}
+	}
+	return retry
+}
+
+// effectiveRetry returns the retry config of each operation with the defaults filled in
+func effectiveRetry(conf Config) RetryConfig {
+	resolve := func(op Operation) OperationRetry {
+		retry := operationRetry(conf, op)
+		if !retry.Disabled && retry.MaxRetries == 0 {
+			retry.MaxRetries = conf.MaxRetries
+		}
+		if !retry.Disabled && retry.RetryDelay == 0 {
+			retry.RetryDelay = conf.RetryDelay
+		}
+		return retry
+	}
+	return RetryConfig{
+		Correlate: resolve(OperationCorrelate),
+		Delete:    resolve(OperationDelete),
+		Get:       resolve(OperationGet),
+	}
+}
@@ -0,0 +1,111 @@
+package correlations
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/signalfx/signalfx-agent/pkg/apm/requests/requestcounter"
+)
+
+func TestOperationRetry(t *testing.T) {
+	cc := &Client{retryDelay: time.Second, maxAttempts: 3}
+	cc.conf.MaxRetries = 2
+	cc.conf.RetryDelay = time.Second
+	newRequest := func(op Operation) *request {
+		return &request{
+			Correlation: &Correlation{DimName: "host", DimValue: "a"},
+			operation:   op,
+			ctx:         requestcounter.ContextWithRequestCounter(context.Background()),
+		}
+	}
+
+	// every operation uses the client-wide settings by default
+	for _, op := range Operations() {
+		require.Equal(t, uint32(3), cc.maxAttemptsFor(op))
+		require.Equal(t, time.Second, cc.retryDelayFor(newRequest(op)))
+		require.True(t, cc.shouldRetry(op, http.StatusServiceUnavailable))
+	}
+
+	cc.conf.Retry.Correlate = OperationRetry{MaxRetries: 5, RetryDelay: time.Minute}
+	cc.conf.Retry.Delete = OperationRetry{Disabled: true}
+	require.Equal(t, uint32(6), cc.maxAttemptsFor(OperationCorrelate))
+	require.Equal(t, time.Minute, cc.retryDelayFor(newRequest(OperationCorrelate)))
+	require.Equal(t, uint32(0), cc.maxAttemptsFor(OperationDelete))
+	require.False(t, cc.shouldRetry(OperationDelete, http.StatusServiceUnavailable))
+	require.False(t, cc.shouldRetry(OperationDelete, 0))
+	require.Equal(t, uint32(3), cc.maxAttemptsFor(OperationGet))
+
+	// the get settings take precedence over GetMaxRetries and GetRetryDelay
+	cc.conf.GetMaxRetries = 9
+	cc.conf.GetRetryDelay = 100 * time.Millisecond
+	require.Equal(t, uint32(10), cc.maxAttemptsFor(OperationGet))
+	cc.conf.Retry.Get = OperationRetry{MaxRetries: 1, RetryDelay: time.Millisecond}
+	require.Equal(t, uint32(2), cc.maxAttemptsFor(OperationGet))
+	require.Equal(t, time.Millisecond, cc.retryDelayFor(newRequest(OperationGet)))
+
+	require.Equal(t, RetryConfig{
+		Correlate: OperationRetry{MaxRetries: 5, RetryDelay: time.Minute},
+		Delete:    OperationRetry{Disabled: true},
+		Get:       OperationRetry{MaxRetries: 1, RetryDelay: time.Millisecond},
+	}, effectiveRetry(cc.conf))
+	require.Equal(t, RetryConfig{
+		Correlate: OperationRetry{MaxRetries: 2, RetryDelay: time.Second},
+		Delete:    OperationRetry{MaxRetries: 2, RetryDelay: time.Second},
+		Get:       OperationRetry{MaxRetries: 2, RetryDelay: time.Second},
+	}, effectiveRetry(Config{MaxRetries: 2, RetryDelay: time.Second}))
+}
+
+func TestValidateRetryConfig(t *testing.T) {
+	require.NoError(t, validateRetryConfig(RetryConfig{}))
+	require.NoError(t, validateRetryConfig(RetryConfig{Delete: OperationRetry{Disabled: true}, Get: OperationRetry{MaxRetries: 3}}))
+	require.Error(t, validateRetryConfig(RetryConfig{Get: OperationRetry{RetryDelay: -time.Second}}))
+	require.Error(t, validateRetryConfig(RetryConfig{Correlate: OperationRetry{Disabled: true, MaxRetries: 1}}))
+	require.Error(t, validateRetryConfig(RetryConfig{Delete: OperationRetry{Disabled: true, MaxRetries: 1}}))
+}
+
+func TestCorrelationClientRetryConfig(t *testing.T) {
+	var puts, deletes int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			atomic.AddInt64(&puts, 1)
+		case http.MethodDelete:
+			atomic.AddInt64(&deletes, 1)
+		}
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.Retry.Correlate.MaxRetries = 1
+		conf.Retry.Delete.Disabled = true
+	})
+	defer cancel()
+	client.Start()
+
+	errs := make(chan error, 1)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, func(_ *Correlation, err error) {
+		errs <- err
+	})
+	require.Error(t, <-errs)
+	require.Equal(t, int64(3), atomic.LoadInt64(&puts))
+
+	client.delete(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, nil, func(err error) {
+		errs <- err
+	}, nil)
+	require.Error(t, <-errs)
+	require.Equal(t, int64(1), atomic.LoadInt64(&deletes))
+	// only the correlate used up its retries
+	require.Equal(t, int64(1), client.TotalDropped(DropCauseMaxAttempts))
+
+	// the retry config can be changed while running
+	conf := client.conf
+	conf.Retry.Delete.Disabled = false
+	require.NoError(t, client.Reconfigure(conf))
+	require.Equal(t, uint32(5), client.maxAttemptsFor(OperationDelete))
+	conf.Retry.Get.RetryDelay = -time.Second
+	require.Error(t, client.Reconfigure(conf))
+}
@@ -0,0 +1,54 @@
+package correlations
+
+import (
+	"container/heap"
+	"time"
+)
+
+// retryQueue is a min-heap of requests ordered by the time they should be sent at
+// this is not threadsafe
+type retryQueue []*request
+
+var _ heap.Interface = (*retryQueue)(nil)
+
+func (q retryQueue) Len() int { return len(q) }
+
+func (q retryQueue) Less(i, j int) bool { return q[i].sendAt.Before(q[j].sendAt) }
+
+func (q retryQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+// Push is used by container/heap, use push instead
+func (q *retryQueue) Push(x interface{}) {
+	*q = append(*q, x.(*request))
+}
+
+// Pop is used by container/heap, use pop instead
+func (q *retryQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	r := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return r
+}
+
+// push adds a request to the queue
+func (q *retryQueue) push(r *request) {
+	heap.Push(q, r)
+}
+
+// peek returns the request that is due soonest without removing it
+func (q retryQueue) peek() *request {
+	if len(q) == 0 {
+		return nil
+	}
+	return q[0]
+}
+
+// popDue removes and returns the request that is due soonest if it is due at or before now
+func (q *retryQueue) popDue(now time.Time) *request {
+	if r := q.peek(); r == nil || r.sendAt.After(now) {
+		return nil
+	}
+	return heap.Pop(q).(*request)
+}
@@ -0,0 +1,38 @@
+package correlations
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryQueueOrdersBySendAt(t *testing.T) {
+	now := time.Unix(1000, 0)
+	q := &retryQueue{}
+
+	// push in an order that differs from the send order
+	for _, offset := range []int{30, 10, 20, 0} {
+		q.push(&request{
+			Correlation: &Correlation{Value: time.Duration(offset).String()},
+			ctx:         context.Background(),
+			sendAt:      now.Add(time.Duration(offset) * time.Second),
+		})
+	}
+
+	require.Equal(t, now, q.peek().sendAt)
+
+	// only requests that are due should be popped
+	var popped []time.Time
+	for r := q.popDue(now.Add(15 * time.Second)); r != nil; r = q.popDue(now.Add(15 * time.Second)) {
+		popped = append(popped, r.sendAt)
+	}
+	require.Equal(t, []time.Time{now, now.Add(10 * time.Second)}, popped)
+	require.Equal(t, 2, q.Len())
+
+	require.Equal(t, now.Add(20*time.Second), q.popDue(now.Add(time.Minute)).sendAt)
+	require.Equal(t, now.Add(30*time.Second), q.popDue(now.Add(time.Minute)).sendAt)
+	require.Nil(t, q.popDue(now.Add(time.Minute)))
+	require.Nil(t, q.peek())
+}
@@ -0,0 +1,43 @@
+package correlations
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/signalfx/signalfx-agent/pkg/apm/requests/requestcounter"
+)
+
+// recordRetrySuccess counts a retried request that succeeded.  Requests that succeed on their
+// first attempt aren't counted, so that the rate only reflects whether retrying was worthwhile.
+func (cc *Client) recordRetrySuccess(r *request) {
+	if requestcounter.GetRequestCount(r.ctx) > 0 {
+		atomic.AddInt64(&cc.TotalRetrySucceeded, 1)
+	}
+}
+
+// completeFailed invokes the request's callback with the error it failed with.  A retried request
+// that fails for good is counted as having exhausted its retries whatever the reason, whether it
+// used up its attempts, ran out of budget, was cancelled, got a response that isn't retried or
+// couldn't be queued to be retried again.
+func (cc *Client) completeFailed(r *request, body []byte, statusCode int, header http.Header, err error) bool {
+	if !r.complete(body, statusCode, header, err) {
+		return false
+	}
+	if attemptsMade(r) > 0 {
+		atomic.AddInt64(&cc.TotalRetryExhausted, 1)
+	}
+	return true
+}
+
+// RetrySuccessRate returns the fraction of retried requests that eventually succeeded rather
+// than failing for good, between 0 and 1.  A low rate means the endpoint is failing
+// persistently and retrying is mostly wasted, while a high rate means failures are transient
+// blips worth retrying.  It is 0 until a retried request has either succeeded or failed for good.
+func (cc *Client) RetrySuccessRate() float64 {
+	succeeded := atomic.LoadInt64(&cc.TotalRetrySucceeded)
+	exhausted := atomic.LoadInt64(&cc.TotalRetryExhausted)
+	if succeeded+exhausted == 0 {
+		return 0
+	}
+	return float64(succeeded) / float64(succeeded+exhausted)
+}
@@ -0,0 +1,77 @@
+package correlations
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelationClientRetrySuccessRate(t *testing.T) {
+	var attempts int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// the requests are made one at a time, the first succeeds after a retry, the second
+		// succeeds right away and the third never succeeds
+		if n := atomic.AddInt64(&attempts, 1); n == 1 || n > 3 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	client, cancel := newTestClient(t, handler, nil)
+	defer cancel()
+	client.Start()
+
+	require.Equal(t, float64(0), client.RetrySuccessRate())
+
+	done := make(chan error, 3)
+	cb := CorrelateCB(func(_ *Correlation, err error) { done <- err })
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "retried"}, cb)
+	require.NoError(t, <-done)
+	require.Equal(t, float64(1), client.RetrySuccessRate())
+
+	// a request that succeeds on its first attempt doesn't affect the rate
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "first"}, cb)
+	require.NoError(t, <-done)
+	require.Equal(t, int64(1), atomic.LoadInt64(&client.TotalRetrySucceeded))
+
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "never"}, cb)
+	require.Error(t, <-done)
+	require.Equal(t, int64(1), atomic.LoadInt64(&client.TotalRetryExhausted))
+	require.Equal(t, 0.5, client.RetrySuccessRate())
+
+	client.ResetStats()
+	require.Equal(t, float64(0), client.RetrySuccessRate())
+}
+
+func TestCorrelationClientRetryExhaustedCountsEveryFailure(t *testing.T) {
+	var attempts int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// the first request is refused once it is retried
+		if atomic.AddInt64(&attempts, 1) == 2 {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.RetryDelay = time.Hour
+	})
+	defer cancel()
+	client.Start()
+
+	done := make(chan error, 2)
+	cb := CorrelateCB(func(_ *Correlation, err error) { done <- err })
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "refused"}, cb,
+		RequestOptions{RetryDelay: 10 * time.Millisecond})
+	require.Error(t, <-done)
+	require.Equal(t, int64(1), atomic.LoadInt64(&client.TotalRetryExhausted))
+
+	// a request still waiting to be retried when the client is stopped fails for good too
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "stopped"}, cb)
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&client.retryQueueLen) == 1 }, 3*time.Second, 10*time.Millisecond)
+	client.Stop()
+	require.Error(t, <-done)
+	require.Equal(t, int64(2), atomic.LoadInt64(&client.TotalRetryExhausted))
+	require.Equal(t, float64(0), client.RetrySuccessRate())
+}
@@ -0,0 +1,290 @@
+package correlations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultDedupStateWindow is how long a sent request is remembered when DedupStateWindow is 0
+	defaultDedupStateWindow = time.Hour
+	// defaultDedupStateMaxEntries is how many sent requests are remembered when
+	// DedupStateMaxEntries is 0
+	defaultDedupStateMaxEntries = 10000
+)
+
+func validateDedupState(conf Config) error {
+	if conf.DedupStateWindow < 0 {
+		return errors.New("correlation dedup state window must not be negative")
+	}
+	if conf.DedupStateMaxEntries < 0 {
+		return errors.New("correlation dedup state max entries must not be negative")
+	}
+	return nil
+}
+
+// idempotencyKey returns a stable hash of the operation and the whole correlation, which is the
+// same for identical requests however they were made and across restarts
+func idempotencyKey(op Operation, cor *Correlation) string {
+	h := sha256.New()
+	for _, s := range []string{op.String(), string(cor.Type), cor.DimName, cor.DimValue, cor.Value} {
+		_, _ = h.Write([]byte(s))
+		_, _ = h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// sentDeduplicator remembers the correlates and deletes that were sent successfully, keyed by
+// their idempotency key, so that an identical request made within the window after a restart is a
+// duplicate.  It complements the deduplicator, which only knows about pending requests, and
+// doesn't suppress requests that were sent since the client started since those may be made again
+// on purpose.  A request forgets the opposite operation on the same correlation so that, e.g., a
+// correlate after a delete is sent.  The state is kept in a file that is loaded when the client
+// starts and saved periodically and when it stops.
+// this is threadsafe
+type sentDeduplicator struct {
+	sync.Mutex
+	path       string
+	window     time.Duration
+	maxEntries int
+	sent       map[string]sentEntry
+	dirty      bool
+}
+
+// sentEntry is when a request was sent successfully
+type sentEntry struct {
+	at time.Time
+	// previous is true if the request was sent before the client was started
+	previous bool
+}
+
+// newSentDeduplicator returns a sentDeduplicator for the config, or nil if there is no state file
+func newSentDeduplicator(conf Config) *sentDeduplicator {
+	if conf.DedupStateFile == "" {
+		return nil
+	}
+	d := &sentDeduplicator{
+		path:       conf.DedupStateFile,
+		window:     conf.DedupStateWindow,
+		maxEntries: conf.DedupStateMaxEntries,
+		sent:       make(map[string]sentEntry),
+	}
+	if d.window == 0 {
+		d.window = defaultDedupStateWindow
+	}
+	if d.maxEntries == 0 {
+		d.maxEntries = defaultDedupStateMaxEntries
+	}
+	return d
+}
+
+func opposite(op Operation) Operation {
+	if op == OperationCorrelate {
+		return OperationDelete
+	}
+	return OperationCorrelate
+}
+
+// isDup returns true if an identical request was sent within the window before the client was
+// started.  Otherwise the opposite operation on the correlation is forgotten since it won't be in
+// effect once the request is sent.  A nil sentDeduplicator never finds duplicates.
+func (d *sentDeduplicator) isDup(r *request, now time.Time) bool {
+	if d == nil || (r.operation != OperationCorrelate && r.operation != OperationDelete) {
+		return false
+	}
+	d.Lock()
+	defer d.Unlock()
+	if e, ok := d.sent[idempotencyKey(r.operation, r.Correlation)]; ok && e.previous && now.Sub(e.at) < d.window {
+		return true
+	}
+	d.removeLocked(idempotencyKey(opposite(r.operation), r.Correlation))
+	return false
+}
+
+// record remembers that the request was sent successfully.  A request that was sent before the
+// client was started is left as it is, so that it goes on deduplicating identical requests for the
+// rest of its window after the duplicates complete.
+func (d *sentDeduplicator) record(op Operation, cor *Correlation, now time.Time) {
+	if d == nil {
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.removeLocked(idempotencyKey(opposite(op), cor))
+	key := idempotencyKey(op, cor)
+	if e, ok := d.sent[key]; ok && e.previous && now.Sub(e.at) < d.window {
+		return
+	}
+	if _, ok := d.sent[key]; !ok && len(d.sent) >= d.maxEntries {
+		d.expireLocked(now)
+		if len(d.sent) >= d.maxEntries {
+			d.evictOldestLocked()
+		}
+	}
+	d.sent[key] = sentEntry{at: now}
+	d.dirty = true
+}
+
+// forget forgets that the correlate and delete of the correlation were sent
+func (d *sentDeduplicator) forget(cor *Correlation) {
+	if d == nil {
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.removeLocked(idempotencyKey(OperationCorrelate, cor))
+	d.removeLocked(idempotencyKey(OperationDelete, cor))
+}
+
+func (d *sentDeduplicator) removeLocked(key string) {
+	if _, ok := d.sent[key]; ok {
+		delete(d.sent, key)
+		d.dirty = true
+	}
+}
+
+func (d *sentDeduplicator) expireLocked(now time.Time) {
+	for key, e := range d.sent {
+		if now.Sub(e.at) >= d.window {
+			delete(d.sent, key)
+			d.dirty = true
+		}
+	}
+}
+
+func (d *sentDeduplicator) evictOldestLocked() {
+	var oldestKey string
+	var oldest time.Time
+	for key, e := range d.sent {
+		if oldestKey == "" || e.at.Before(oldest) {
+			oldestKey, oldest = key, e.at
+		}
+	}
+	d.removeLocked(oldestKey)
+}
+
+// load replaces the remembered requests with those in the state file that are still within the
+// window, which are duplicates if they are made again.  A missing file isn't an error since there
+// is nothing to remember on the first start.
+func (d *sentDeduplicator) load(now time.Time) error {
+	if d == nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(d.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	saved := make(map[string]time.Time)
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	d.sent = make(map[string]sentEntry, len(saved))
+	for key, at := range saved {
+		d.sent[key] = sentEntry{at: at, previous: true}
+	}
+	d.expireLocked(now)
+	for len(d.sent) > d.maxEntries {
+		d.evictOldestLocked()
+	}
+	return nil
+}
+
+// save writes the remembered requests to the state file if they changed since they were last
+// saved.  The file is written to a temporary file that is synced before it replaces the state
+// file, and the directory is synced after, so that a crash while saving leaves either the old or
+// the new state.
+func (d *sentDeduplicator) save(now time.Time) error {
+	if d == nil {
+		return nil
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.expireLocked(now)
+	if !d.dirty {
+		return nil
+	}
+	saved := make(map[string]time.Time, len(d.sent))
+	for key, e := range d.sent {
+		saved[key] = e.at
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	tmp := d.path + ".tmp"
+	if err := writeFileSync(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, d.path); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(d.path)); err != nil {
+		return err
+	}
+	d.dirty = false
+	return nil
+}
+
+// writeFileSync is like ioutil.WriteFile but syncs the file to disk before closing it
+func writeFileSync(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir syncs the directory so that a file renamed into it persists
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// saveDedupState saves the sent deduplicator's state, logging any failure since the client keeps
+// working without it
+func (cc *Client) saveDedupState() {
+	if err := cc.sentDedup.save(cc.now()); err != nil {
+		cc.log.WithError(err).Error("Unable to save correlation dedup state")
+	}
+}
+
+// saveDedupStatePeriodically is a routine that saves the sent deduplicator's state every cleanup
+// interval so that writing the file doesn't hold up the requests being processed
+func (cc *Client) saveDedupStatePeriodically() {
+	defer cc.wg.Done()
+	ticker := time.NewTicker(cc.dedupCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cc.ctx.Done():
+			return
+		case <-ticker.C:
+			cc.saveDedupState()
+		}
+	}
+}
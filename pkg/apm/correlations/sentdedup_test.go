@@ -0,0 +1,138 @@
+package correlations
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateDedupState(t *testing.T) {
+	require.NoError(t, validateDedupState(Config{}))
+	require.NoError(t, validateDedupState(Config{DedupStateWindow: time.Hour, DedupStateMaxEntries: 100}))
+	require.Error(t, validateDedupState(Config{DedupStateWindow: -time.Second}))
+	require.Error(t, validateDedupState(Config{DedupStateMaxEntries: -1}))
+}
+
+func TestSentDeduplicator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sentdedup")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	d := newSentDeduplicator(Config{DedupStateFile: filepath.Join(dir, "state.json"), DedupStateWindow: time.Minute, DedupStateMaxEntries: 2})
+	require.Nil(t, newSentDeduplicator(Config{}), "disabled without a state file")
+
+	a := &Correlation{Type: Service, DimName: "host", DimValue: "a", Value: "svc"}
+	b := &Correlation{Type: Service, DimName: "host", DimValue: "b", Value: "svc"}
+	c := &Correlation{Type: Service, DimName: "host", DimValue: "c", Value: "svc"}
+	correlate := func(cor *Correlation) *request { return &request{Correlation: cor, operation: OperationCorrelate} }
+	del := func(cor *Correlation) *request { return &request{Correlation: cor, operation: OperationDelete} }
+
+	require.False(t, d.isDup(correlate(a), now))
+	d.record(OperationCorrelate, a, now)
+	require.False(t, d.isDup(correlate(a), now), "requests sent since the client started aren't suppressed")
+	require.False(t, d.isDup(&request{Correlation: a, operation: OperationGet}, now))
+
+	// a delete forgets the correlate
+	require.False(t, d.isDup(del(a), now))
+	require.NotContains(t, d.sent, idempotencyKey(OperationCorrelate, a))
+	d.record(OperationDelete, a, now)
+
+	// the oldest is evicted once it is full
+	d.record(OperationCorrelate, b, now.Add(time.Second))
+	d.record(OperationCorrelate, c, now.Add(2*time.Second))
+	require.Len(t, d.sent, 2)
+	require.NotContains(t, d.sent, idempotencyKey(OperationDelete, a))
+
+	// the state survives a restart, after which the requests sent before it are duplicates
+	require.NoError(t, d.save(now.Add(2*time.Second)))
+	_, err = os.Stat(d.path + ".tmp")
+	require.True(t, os.IsNotExist(err), "temporary file is renamed")
+	loaded := newSentDeduplicator(d.conf())
+	require.NoError(t, loaded.load(now.Add(2*time.Second)))
+	require.True(t, loaded.isDup(correlate(&Correlation{Type: Service, DimName: "host", DimValue: "b", Value: "svc"}), now.Add(2*time.Second)), "keyed by content rather than identity")
+	require.True(t, loaded.isDup(correlate(c), now.Add(2*time.Second)))
+	loaded.record(OperationCorrelate, c, now.Add(3*time.Second))
+	require.True(t, loaded.isDup(correlate(c), now.Add(3*time.Second)), "recording a duplicate keeps it suppressed")
+	require.False(t, loaded.isDup(correlate(c), now.Add(time.Minute+2*time.Second)), "only remembered within the window")
+
+	// a delete forgets the correlate so that the correlation can be made again
+	require.False(t, loaded.isDup(del(b), now.Add(2*time.Second)))
+	require.False(t, loaded.isDup(correlate(b), now.Add(2*time.Second)))
+	loaded.record(OperationCorrelate, b, now.Add(2*time.Second))
+	require.False(t, loaded.isDup(correlate(b), now.Add(2*time.Second)))
+
+	loaded = newSentDeduplicator(d.conf())
+	require.NoError(t, loaded.load(now.Add(time.Minute+time.Second)))
+	require.False(t, loaded.isDup(correlate(b), now.Add(time.Minute+time.Second)), "expired while stopped")
+	require.True(t, loaded.isDup(correlate(c), now.Add(time.Minute+time.Second)))
+
+	loaded.forget(c)
+	require.False(t, loaded.isDup(correlate(c), now.Add(time.Minute+time.Second)))
+
+	require.NoError(t, newSentDeduplicator(Config{DedupStateFile: filepath.Join(dir, "missing.json")}).load(now))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "corrupt.json"), []byte("{"), 0600))
+	require.Error(t, newSentDeduplicator(Config{DedupStateFile: filepath.Join(dir, "corrupt.json")}).load(now))
+}
+
+// conf returns a config that creates a sentDeduplicator with the same settings
+func (d *sentDeduplicator) conf() Config {
+	return Config{DedupStateFile: d.path, DedupStateWindow: d.window, DedupStateMaxEntries: d.maxEntries}
+}
+
+func TestCorrelationClientDedupState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sentdedup")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var puts int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&puts, 1)
+	})
+	deduplicated := make(chan *Correlation, 1)
+	newClient := func() (*Client, func()) {
+		client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+			conf.DedupStateFile = filepath.Join(dir, "state.json")
+			conf.CleanupInterval = time.Hour
+			conf.OnDeduplicated = func(cor *Correlation) { deduplicated <- cor }
+		})
+		client.Start()
+		return client, func() {
+			client.Stop()
+			cancel()
+		}
+	}
+
+	service := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "test-service"}
+	client, stop := newClient()
+	done := make(chan error, 1)
+	client.Correlate(service, func(_ *Correlation, err error) { done <- err })
+	require.NoError(t, <-done)
+	// making it again in the same run sends it again
+	client.Correlate(service, func(_ *Correlation, err error) { done <- err })
+	require.NoError(t, <-done)
+	require.Equal(t, int64(2), atomic.LoadInt64(&puts))
+	stop()
+
+	// a client started with the same state knows the correlation was already made
+	client, stop = newClient()
+	defer stop()
+	// and completes it successfully without sending it, however many times it is made
+	for i := 0; i < 2; i++ {
+		client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "test-service"}, func(_ *Correlation, err error) { done <- err })
+		require.NoError(t, <-done)
+		require.Equal(t, service, <-deduplicated)
+	}
+	require.Equal(t, int64(2), atomic.LoadInt64(&puts))
+
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "other-service"}, func(_ *Correlation, err error) { done <- err })
+	require.NoError(t, <-done)
+	require.Equal(t, int64(3), atomic.LoadInt64(&puts))
+	require.Equal(t, defaultDedupStateWindow, client.EffectiveConfig()["dedup_state_window"])
+}
@@ -0,0 +1,84 @@
+package correlations
+
+import (
+	"sync"
+)
+
+// dimensionKey identifies a dimension
+type dimensionKey struct {
+	name  string
+	value string
+}
+
+// dimensionQueues holds the requests waiting for an earlier request for the same dimension to
+// complete.  A dimension is busy while it has an entry, even if no requests are waiting.
+// this is threadsafe
+type dimensionQueues struct {
+	sync.Mutex
+	waiting map[dimensionKey][]*request
+}
+
+func newDimensionQueues() *dimensionQueues {
+	return &dimensionQueues{waiting: make(map[dimensionKey][]*request)}
+}
+
+// acquire returns true if the request may be sent now.  Otherwise the request is queued until
+// the dimension is released.
+func (q *dimensionQueues) acquire(r *request) bool {
+	key := dimensionKey{name: r.DimName, value: r.DimValue}
+	q.Lock()
+	defer q.Unlock()
+	if waiting, busy := q.waiting[key]; busy {
+		q.waiting[key] = append(waiting, r)
+		return false
+	}
+	q.waiting[key] = nil
+	return true
+}
+
+// release returns the next request waiting for the dimension, which now holds it, or nil if none
+// are waiting and the dimension is no longer busy.  Requests cancelled while waiting are skipped.
+func (q *dimensionQueues) release(key dimensionKey) *request {
+	q.Lock()
+	defer q.Unlock()
+	waiting := q.waiting[key]
+	for len(waiting) > 0 {
+		next := waiting[0]
+		waiting[0] = nil
+		waiting = waiting[1:]
+		if next.ctx.Err() == nil {
+			q.waiting[key] = waiting
+			return next
+		}
+	}
+	delete(q.waiting, key)
+	return nil
+}
+
+// dispatch sends the request, waiting until earlier requests for the same dimension have
+// completed if operations are serialized by dimension
+func (cc *Client) dispatch(r *request) {
+	if cc.dimensionQueues == nil {
+		cc.makeRequest(r)
+		return
+	}
+	if cc.dimensionQueues.acquire(r) {
+		cc.makeSerializedRequest(r)
+	}
+}
+
+// makeSerializedRequest sends a request that holds its dimension and sends the next request
+// waiting for the dimension once it completes, including any retries
+func (cc *Client) makeSerializedRequest(r *request) {
+	cc.makeRequest(r)
+	go func() {
+		select {
+		case <-r.ctx.Done():
+		case <-cc.ctx.Done():
+			return
+		}
+		if next := cc.dimensionQueues.release(dimensionKey{name: r.DimName, value: r.DimValue}); next != nil {
+			cc.makeSerializedRequest(next)
+		}
+	}()
+}
@@ -0,0 +1,28 @@
+package correlations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDimensionQueues(t *testing.T) {
+	q := newDimensionQueues()
+	first := newTestRequest(OperationCorrelate, &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "first"})
+	cancelled := newTestRequest(OperationDelete, &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "first"})
+	second := newTestRequest(OperationCorrelate, &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "second"})
+	other := newTestRequest(OperationCorrelate, &Correlation{Type: Service, DimName: "host", DimValue: "other-box", Value: "first"})
+
+	require.True(t, q.acquire(first))
+	require.False(t, q.acquire(cancelled))
+	require.False(t, q.acquire(second))
+	// other dimensions aren't held up
+	require.True(t, q.acquire(other))
+
+	cancelled.cancel()
+	key := dimensionKey{name: "host", value: "test-box"}
+	require.Equal(t, second, q.release(key))
+	require.Nil(t, q.release(key))
+	// the dimension is free once nothing is waiting
+	require.True(t, q.acquire(first))
+}
@@ -0,0 +1,34 @@
+package correlations
+
+import (
+	"runtime"
+)
+
+// shedder decides whether to shed updates based on the pressure on the agent.
+// A nil shedder never sheds.
+type shedder struct {
+	threshold float64
+	pressure  func() float64
+}
+
+// newShedder returns a shedder that sheds while pressure exceeds the threshold.  If pressure is
+// nil the number of goroutines is used.
+func newShedder(threshold float64, pressure func() float64) *shedder {
+	if pressure == nil {
+		pressure = goroutinePressure
+	}
+	return &shedder{threshold: threshold, pressure: pressure}
+}
+
+// goroutinePressure reports the number of goroutines as the pressure
+func goroutinePressure() float64 {
+	return float64(runtime.NumGoroutine())
+}
+
+// shouldShed returns whether the pressure is currently over the threshold
+func (s *shedder) shouldShed() bool {
+	if s == nil {
+		return false
+	}
+	return s.pressure() > s.threshold
+}
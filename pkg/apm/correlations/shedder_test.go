@@ -0,0 +1,23 @@
+package correlations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShedder(t *testing.T) {
+	var s *shedder
+	require.False(t, s.shouldShed())
+
+	pressure := 5.0
+	s = newShedder(10, func() float64 { return pressure })
+	require.False(t, s.shouldShed())
+	pressure = 10
+	require.False(t, s.shouldShed())
+	pressure = 11
+	require.True(t, s.shouldShed())
+
+	// the number of goroutines is used by default
+	require.False(t, newShedder(1e9, nil).shouldShed())
+}
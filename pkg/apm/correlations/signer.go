@@ -0,0 +1,36 @@
+package correlations
+
+import (
+	"net/http"
+)
+
+// RequestSigner authenticates requests to the correlation endpoint.  Sign is called for every
+// attempt of a request, including retries, just before it is sent.
+type RequestSigner interface {
+	Sign(req *http.Request) error
+}
+
+// headerSigner authenticates requests with a static token in a header
+type headerSigner struct {
+	header string
+	value  string
+}
+
+// newHeaderSigner returns a signer that sends the token in the header, prefixed by the scheme if
+// one is given
+func newHeaderSigner(header string, scheme string, token string) *headerSigner {
+	if header == "" {
+		header = defaultAuthHeader
+	}
+	value := token
+	if scheme != "" {
+		value = scheme + " " + token
+	}
+	return &headerSigner{header: header, value: value}
+}
+
+// Sign sets the token header on the request
+func (s *headerSigner) Sign(req *http.Request) error {
+	req.Header.Set(s.header, s.value)
+	return nil
+}
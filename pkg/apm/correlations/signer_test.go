@@ -0,0 +1,18 @@
+package correlations
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderSigner(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "http://localhost", nil)
+	require.NoError(t, err)
+	require.NoError(t, newHeaderSigner("", "", "abc123").Sign(req))
+	require.Equal(t, "abc123", req.Header.Get("X-SF-TOKEN"))
+
+	require.NoError(t, newHeaderSigner("Authorization", "Bearer", "abc123").Sign(req))
+	require.Equal(t, "Bearer abc123", req.Header.Get("Authorization"))
+}
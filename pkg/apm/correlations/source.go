@@ -0,0 +1,98 @@
+package correlations
+
+import (
+	"github.com/signalfx/golib/v3/datapoint"
+	"github.com/signalfx/golib/v3/sfxclient"
+	"github.com/signalfx/signalfx-agent/pkg/apm/log"
+)
+
+// defaultMaxTrackedSources is the number of sources counted individually if MaxTrackedSources
+// isn't set
+const defaultMaxTrackedSources = 10
+
+// sourceCounters counts the requests and failures of each source that tags its requests with
+// RequestOptions.Source.  Requests without a source aren't counted.
+// this is threadsafe
+type sourceCounters struct {
+	requests *keyCounter
+	failures *keyCounter
+}
+
+func newSourceCounters(limit int) *sourceCounters {
+	if limit <= 0 {
+		limit = defaultMaxTrackedSources
+	}
+	return &sourceCounters{
+		requests: newKeyCounter(limit),
+		failures: newKeyCounter(limit),
+	}
+}
+
+// countRequest counts a request accepted from the source
+func (s *sourceCounters) countRequest(source string) {
+	if source != "" {
+		s.requests.increment(source)
+	}
+}
+
+// countFailure counts a request from the source that failed
+func (s *sourceCounters) countFailure(source string) {
+	if source != "" {
+		s.failures.increment(source)
+	}
+}
+
+func (s *sourceCounters) reset() {
+	s.requests.reset()
+	s.failures.reset()
+}
+
+// datapoints returns cumulative counters of requests and failures labeled by source.  Nothing is
+// reported until a request with a source is made.
+func (s *sourceCounters) datapoints() []*datapoint.Datapoint {
+	var dps []*datapoint.Datapoint
+	for _, m := range []struct {
+		metric  string
+		counter *keyCounter
+	}{
+		{"sfxagent.correlation_updates_by_source", s.requests},
+		{"sfxagent.correlation_failures_by_source", s.failures},
+	} {
+		metric := m.metric
+		counts, other := m.counter.snapshot()
+		if len(counts) == 0 {
+			continue
+		}
+		for _, c := range counts {
+			dps = append(dps, sfxclient.Cumulative(metric, map[string]string{"source": c.Key}, c.Count))
+		}
+		dps = append(dps, sfxclient.Cumulative(metric, map[string]string{"source": otherKey}, other))
+	}
+	return dps
+}
+
+// withSource adds the source to the logger's fields if there is one
+func withSource(l log.Logger, source string) log.Logger {
+	if source == "" {
+		return l
+	}
+	return l.WithFields(log.Fields{"source": source})
+}
+
+// withSourceThrottled is like withSource for throttled loggers
+func withSourceThrottled(l *log.ThrottledLogger, source string) *log.ThrottledLogger {
+	if source == "" {
+		return l
+	}
+	return l.WithFields(log.Fields{"source": source})
+}
+
+// Logger returns a logger with the fields of the correlation and the source of the request
+func (r *request) Logger(l log.Logger) log.Logger {
+	return withSource(r.Correlation.Logger(l), r.opts.Source)
+}
+
+// ThrottledLogger is like Logger for throttled loggers
+func (r *request) ThrottledLogger(l *log.ThrottledLogger) *log.ThrottledLogger {
+	return withSourceThrottled(r.Correlation.ThrottledLogger(l), r.opts.Source)
+}
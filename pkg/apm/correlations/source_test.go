@@ -0,0 +1,57 @@
+package correlations
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceCounters(t *testing.T) {
+	s := newSourceCounters(1)
+	s.countRequest("")
+	require.Empty(t, s.datapoints())
+
+	s.countRequest("tracetracker")
+	s.countRequest("tracetracker")
+	s.countRequest("spanprocessor")
+	s.countFailure("tracetracker")
+
+	counts, other := s.requests.snapshot()
+	require.Equal(t, []KeyCount{{Key: "tracetracker", Count: 2}}, counts)
+	require.Equal(t, int64(1), other)
+	require.Len(t, s.datapoints(), 4)
+
+	s.reset()
+	require.Empty(t, s.datapoints())
+}
+
+func TestCorrelationClientRequestSource(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if putPathRegexp.FindStringSubmatch(r.URL.Path)[2] == "bad-box" {
+			rw.WriteHeader(http.StatusBadRequest)
+		}
+	})
+	client, cancel := newTestClient(t, handler, nil)
+	defer cancel()
+	client.Start()
+
+	done := make(chan struct{}, 3)
+	cb := CorrelateCB(func(_ *Correlation, _ error) { done <- struct{}{} })
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, cb, RequestOptions{Source: "tracetracker"})
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "bad-box", Value: "service"}, cb, RequestOptions{Source: "tracetracker"})
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "other-box", Value: "service"}, cb)
+	for i := 0; i < 3; i++ {
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Fatal("correlations did not complete")
+		}
+	}
+
+	requests, _ := client.sources.requests.snapshot()
+	require.Equal(t, []KeyCount{{Key: "tracetracker", Count: 2}}, requests)
+	failures, _ := client.sources.failures.snapshot()
+	require.Equal(t, []KeyCount{{Key: "tracetracker", Count: 1}}, failures)
+}
@@ -0,0 +1,113 @@
+package correlations
+
+import (
+	"container/heap"
+	"sync/atomic"
+)
+
+// Stop shuts the client down and waits for its goroutines to exit.  Every outstanding request is
+// cancelled first so that none of them is sent or retried, and then the client's context is
+// cancelled, which aborts the attempts in flight and unblocks any waiting for a request sender.
+// The callback of every request still queued, waiting to be retried or in flight is invoked
+// before Stop returns, with a shutdown error unless the request sender reported the aborted
+// attempt first.  A RequestSender passed in the ClientConfig isn't stopped since it may be shared with
+// other clients.  The dedup state file, if any, is saved last.  It is safe to call Stop more than
+// once.
+func (cc *Client) Stop() {
+	atomic.StoreInt32(&cc.stopping, 1)
+	cancelled := cc.registry.cancelAll()
+	cc.stop()
+	cc.wg.Wait()
+	cc.drainQueues()
+	cc.failUnfinished(cancelled)
+	cc.saveDedupState()
+}
+
+// cancelledErr returns the error for a request that was cancelled before it was sent, which is a
+// shutdown error once the client is stopping
+func (cc *Client) cancelledErr() error {
+	if atomic.LoadInt32(&cc.stopping) == 1 {
+		return errShutdown
+	}
+	return errRequestCancelled
+}
+
+// failShutdown drops a request that won't be sent because the client is shutting down
+func (cc *Client) failShutdown(r *request) {
+	cc.recordDrop(r, DropCauseShutdown)
+	cc.completeFailed(r, nil, 0, nil, errShutdown)
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
+
+// failQueued fails a request taken off a request channel at shutdown
+func (cc *Client) failQueued(r *request) {
+	cc.releaseQueuedBytes(r)
+	if cc.coalescing(r) {
+		cc.coalescer.take(r)
+	}
+	cc.failShutdown(r)
+}
+
+// failRetry fails a request waiting to be retried at shutdown
+func (cc *Client) failRetry(r *request) {
+	cc.adjustRetryQueueLen(-1)
+	cc.releaseQueuedBytes(r)
+	cc.failShutdown(r)
+}
+
+// failRetries fails the requests processRetryChan was waiting to retry when it exited
+func (cc *Client) failRetries(pending *retryQueue) {
+	for pending.Len() > 0 {
+		cc.failRetry(heap.Pop(pending).(*request))
+	}
+}
+
+// failUnfinished fails the cancelled requests whose callback hasn't been invoked yet, which are
+// those the request sender hasn't called back for.  Their callback isn't invoked again once the
+// request sender gets to them.
+func (cc *Client) failUnfinished(cancelled []*request) {
+	for _, r := range cancelled {
+		if cc.completeFailed(r, nil, 0, nil, errShutdown) {
+			cc.recordDrop(r, DropCauseShutdown)
+		}
+	}
+}
+
+// drainQueues fails the requests left on the queues once the client's routines have exited, so
+// that no caller is left waiting for a callback, and then waits for the requests being requeued,
+// which fail as soon as the client is stopped
+func (cc *Client) drainQueues() {
+	for _, ch := range []chan *request{cc.highPriorityChan, cc.requestChan, cc.overflowChan} {
+		for drained := false; !drained; {
+			select {
+			case r := <-ch:
+				cc.failQueued(r)
+			default:
+				drained = true
+			}
+		}
+	}
+	for drained := false; !drained; {
+		select {
+		case r := <-cc.retryChan:
+			cc.failRetry(r)
+		default:
+			drained = true
+		}
+	}
+	// processChan has exited, so the held deletes are no longer used by it
+	for cc.heldDeletes.Len() > 0 {
+		cc.failShutdown(heap.Pop(cc.heldDeletes).(*request))
+	}
+
+	// holding every requeue slot means no request is being requeued, and they are given back so
+	// that Stop can be called again
+	for i := 0; i < cap(cc.requeueSlots); i++ {
+		cc.requeueSlots <- struct{}{}
+	}
+	for i := 0; i < cap(cc.requeueSlots); i++ {
+		<-cc.requeueSlots
+	}
+}
@@ -0,0 +1,87 @@
+package correlations
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// clientGoroutines returns how many goroutines are running client or request sender code
+func clientGoroutines() int {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	count := 0
+	for _, g := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.Contains(g, []byte("correlations.(*Client)")) || bytes.Contains(g, []byte("requests.(*ReqSender)")) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestCorrelationClientStop(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	var received int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&received, 1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.MaxRequests = 2
+		conf.MaxBuffered = 20
+	})
+	defer cancel()
+	client.Start()
+
+	var completed int64
+	for i := 0; i < 20; i++ {
+		client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: string(rune('a' + i))}, CorrelateCB(func(_ *Correlation, err error) {
+			require.Error(t, err)
+			atomic.AddInt64(&completed, 1)
+		}))
+	}
+	// both senders are busy and the client is blocked waiting for one
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&received) == 2 }, 3*time.Second, 10*time.Millisecond)
+
+	// deletes and gets still queued are released at shutdown
+	deleted := make(chan map[*Correlation]error, 1)
+	queuedDelete := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "queued"}
+	client.DeleteMany(context.Background(), []*Correlation{queuedDelete}, func(results map[*Correlation]error) { deleted <- results })
+	got := make(chan error, 1)
+	go func() {
+		_, err := client.GetSync(context.Background(), "host", "test-box")
+		got <- err
+	}()
+	require.Eventually(t, func() bool { return client.registry.len() == 22 }, 3*time.Second, 10*time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		client.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Stop didn't return while requests were in flight")
+	}
+
+	// the callback of every request is invoked before Stop returns
+	require.Equal(t, int64(20), atomic.LoadInt64(&completed))
+	require.Equal(t, errShutdown, (<-deleted)[queuedDelete])
+	require.Equal(t, errShutdown, <-got)
+	require.Eventually(t, func() bool { return clientGoroutines() == 0 }, 3*time.Second, 10*time.Millisecond)
+	require.Equal(t, 0, client.registry.len())
+	require.Equal(t, int64(0), client.InFlight())
+
+	client.Stop()
+}
@@ -0,0 +1,34 @@
+package correlations
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// countRequestBytes counts the body of a request that is about to be sent.  Each attempt is
+// counted, so retries add to the total.
+func (cc *Client) countRequestBytes(req *http.Request) {
+	if req.ContentLength > 0 {
+		atomic.AddInt64(&cc.TotalRequestBytes, req.ContentLength)
+	}
+}
+
+// countResponseBytes counts a response body as it was received, before it is decompressed
+func (cc *Client) countResponseBytes(body []byte) {
+	if len(body) > 0 {
+		atomic.AddInt64(&cc.TotalResponseBytes, int64(len(body)))
+	}
+}
+
+// RequestBytesSent returns the total size of the bodies of the requests sent, which are the
+// values of Correlates
+func (cc *Client) RequestBytesSent() int64 {
+	return atomic.LoadInt64(&cc.TotalRequestBytes)
+}
+
+// ResponseBytesReceived returns the total size of the response bodies received, mostly the
+// correlations returned by Gets.  Compressed responses are counted at their compressed size, so
+// comparing it to TotalGetBytesSaved shows what compression saves.
+func (cc *Client) ResponseBytesReceived() int64 {
+	return atomic.LoadInt64(&cc.TotalResponseBytes)
+}
@@ -0,0 +1,49 @@
+package correlations
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelationClientTrafficBytes(t *testing.T) {
+	const getBody = `{"sf_services":["test-service"]}`
+	var puts int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			_, _ = rw.Write([]byte(getBody))
+		case http.MethodPut:
+			// the first attempt fails so that each attempt is counted
+			if atomic.AddInt64(&puts, 1) == 1 {
+				rw.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = rw.Write([]byte("busy"))
+			}
+		}
+	})
+	client, cancel := newTestClient(t, handler, nil)
+	defer cancel()
+	client.Start()
+
+	done := make(chan error, 1)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "svc"}, func(_ *Correlation, err error) {
+		done <- err
+	})
+	require.NoError(t, <-done)
+	require.Equal(t, int64(2*len("svc")), client.RequestBytesSent())
+	require.Equal(t, int64(len("busy")), client.ResponseBytesReceived())
+
+	got := make(chan map[string][]string, 1)
+	client.Get("host", "test-box", func(correlations map[string][]string) {
+		got <- correlations
+	})
+	require.Equal(t, []string{"test-service"}, (<-got)["sf_services"])
+	require.Equal(t, int64(len("busy")+len(getBody)), client.ResponseBytesReceived())
+	require.Equal(t, int64(2*len("svc")), client.RequestBytesSent(), "gets have no body")
+
+	client.ResetStats()
+	require.Zero(t, client.RequestBytesSent())
+	require.Zero(t, client.ResponseBytesReceived())
+}
@@ -0,0 +1,109 @@
+package correlations
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TTLFallback determines how the TTL of a correlation is honored when it isn't sent to the backend
+type TTLFallback string
+
+const (
+	// TTLFallbackDelete deletes the correlation once its TTL passes unless it is refreshed
+	TTLFallbackDelete TTLFallback = "delete"
+	// TTLFallbackNone ignores the TTL so that the correlation doesn't expire
+	TTLFallbackNone TTLFallback = "none"
+)
+
+func validateTTLFallback(f TTLFallback) error {
+	switch f {
+	case "", TTLFallbackDelete, TTLFallbackNone:
+		return nil
+	default:
+		return fmt.Errorf("invalid correlation ttl fallback %q", f)
+	}
+}
+
+// expiry is a scheduled delete of a correlation
+type expiry struct {
+	timer *time.Timer
+}
+
+// expiries tracks the scheduled deletes of correlations made with a TTL
+// this is threadsafe
+type expiries struct {
+	sync.Mutex
+	pending map[Correlation]*expiry
+}
+
+func newExpiries() *expiries {
+	return &expiries{pending: make(map[Correlation]*expiry)}
+}
+
+// schedule calls expire after the ttl unless the correlation is scheduled again or cancelled first
+func (e *expiries) schedule(cor Correlation, ttl time.Duration, expire func()) {
+	e.Lock()
+	defer e.Unlock()
+	if existing, ok := e.pending[cor]; ok {
+		existing.timer.Stop()
+	}
+	ex := &expiry{}
+	ex.timer = time.AfterFunc(ttl, func() {
+		e.Lock()
+		// the correlation may have been refreshed after the timer fired but before it got the lock
+		current := e.pending[cor] == ex
+		if current {
+			delete(e.pending, cor)
+		}
+		e.Unlock()
+		if current {
+			expire()
+		}
+	})
+	e.pending[cor] = ex
+}
+
+// cancel stops the scheduled delete of the correlation, if there is one
+func (e *expiries) cancel(cor Correlation) {
+	e.Lock()
+	defer e.Unlock()
+	if existing, ok := e.pending[cor]; ok {
+		existing.timer.Stop()
+		delete(e.pending, cor)
+	}
+}
+
+// len returns the number of correlations with a scheduled delete
+func (e *expiries) len() int {
+	e.Lock()
+	defer e.Unlock()
+	return len(e.pending)
+}
+
+// CorrelateWithTTL makes a correlation that expires if it isn't made again within the ttl.  If
+// TTLHeader is configured the ttl is sent to the backend, which expires the correlation.
+// Otherwise, with the default TTLFallback, the client deletes the correlation once the ttl passes;
+// calling CorrelateWithTTL again restarts the ttl, while making the correlation with Correlate or
+// deleting it cancels it.  A ttl that isn't positive makes a correlation that doesn't expire.
+func (cc *Client) CorrelateWithTTL(cor *Correlation, ttl time.Duration, cb CorrelateCB) {
+	if ttl <= 0 {
+		cc.Correlate(cor, cb)
+		return
+	}
+	if cc.ttlHeader != "" {
+		cc.Correlate(cor, cb, RequestOptions{TTL: ttl})
+		return
+	}
+	expired := *cor
+	// the delete scheduled by an earlier call is cancelled by Correlate and replaced here
+	cc.Correlate(cor, cb)
+	if cc.ttlFallback == TTLFallbackDelete {
+		cc.expiries.schedule(expired, ttl, func() {
+			if cc.ctx.Err() != nil {
+				return
+			}
+			cc.Delete(&expired, func(_ *Correlation) {})
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package correlations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTTLFallback(t *testing.T) {
+	require.NoError(t, validateTTLFallback(""))
+	require.NoError(t, validateTTLFallback(TTLFallbackDelete))
+	require.NoError(t, validateTTLFallback(TTLFallbackNone))
+	require.Error(t, validateTTLFallback("forever"))
+}
+
+func TestExpiries(t *testing.T) {
+	e := newExpiries()
+	cor := Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}
+	expired := make(chan struct{}, 2)
+	expire := func() { expired <- struct{}{} }
+
+	e.schedule(cor, 50*time.Millisecond, expire)
+	// refreshing replaces the scheduled delete rather than adding another
+	e.schedule(cor, 100*time.Millisecond, expire)
+	require.Equal(t, 1, e.len())
+	<-expired
+	require.Zero(t, e.len())
+	select {
+	case <-expired:
+		t.Fatal("replaced delete should not run")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	e.schedule(cor, 50*time.Millisecond, expire)
+	e.cancel(cor)
+	require.Zero(t, e.len())
+	select {
+	case <-expired:
+		t.Fatal("cancelled delete should not run")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,56 @@
+package correlations
+
+import (
+	"errors"
+	"fmt"
+)
+
+// typeFilter determines which correlation types may be sent
+type typeFilter struct {
+	allowed map[Type]bool
+	denied  map[Type]bool
+}
+
+// newTypeFilter returns a filter that only permits the allowed types, if any are given, and
+// doesn't permit any of the denied types.  It returns nil if neither list is set so that every
+// type is permitted.
+func newTypeFilter(allowed []Type, denied []Type) (*typeFilter, error) {
+	if len(allowed) == 0 && len(denied) == 0 {
+		return nil, nil
+	}
+	if len(allowed) > 0 && len(denied) > 0 {
+		return nil, errors.New("only one of allowed or denied correlation types may be set")
+	}
+
+	toSet := func(types []Type) (map[Type]bool, error) {
+		set := make(map[Type]bool, len(types))
+		for _, t := range types {
+			if t != Service && t != Environment {
+				return nil, fmt.Errorf("unknown correlation type %q", t)
+			}
+			set[t] = true
+		}
+		return set, nil
+	}
+
+	var f typeFilter
+	var err error
+	if f.allowed, err = toSet(allowed); err != nil {
+		return nil, err
+	}
+	if f.denied, err = toSet(denied); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// permits returns whether the correlation type may be sent.  A nil filter permits every type.
+func (f *typeFilter) permits(t Type) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.allowed) > 0 && !f.allowed[t] {
+		return false
+	}
+	return !f.denied[t]
+}
@@ -0,0 +1,29 @@
+package correlations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTypeFilter(t *testing.T) {
+	f, err := newTypeFilter(nil, nil)
+	require.NoError(t, err)
+	require.True(t, f.permits(Service))
+	require.True(t, f.permits(Environment))
+
+	f, err = newTypeFilter([]Type{Service}, nil)
+	require.NoError(t, err)
+	require.True(t, f.permits(Service))
+	require.False(t, f.permits(Environment))
+
+	f, err = newTypeFilter(nil, []Type{Service})
+	require.NoError(t, err)
+	require.False(t, f.permits(Service))
+	require.True(t, f.permits(Environment))
+
+	_, err = newTypeFilter([]Type{Service}, []Type{Environment})
+	require.Error(t, err)
+	_, err = newTypeFilter([]Type{"host"}, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,52 @@
+package correlations
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrURLTooLong is returned when the endpoint a request would be sent to is longer than the
+// configured MaxURLLength
+type ErrURLTooLong struct {
+	// Length is the length of the endpoint url
+	Length int
+	// Max is the maximum length allowed
+	Max int
+}
+
+func (e *ErrURLTooLong) Error() string {
+	return fmt.Sprintf("correlation url length %d exceeds the maximum of %d", e.Length, e.Max)
+}
+
+// Retryable returns false because the url will be too long every time
+func (e *ErrURLTooLong) Retryable() bool {
+	return false
+}
+
+// StatusCode returns 0 because requests with urls that are too long aren't sent
+func (e *ErrURLTooLong) StatusCode() int {
+	return 0
+}
+
+var _ error = (*ErrURLTooLong)(nil)
+
+func validateMaxURLLength(max int) error {
+	if max < 0 {
+		return errors.New("correlation max url length must not be negative")
+	}
+	return nil
+}
+
+// checkURLLength returns an ErrURLTooLong if the request's endpoint is longer than MaxURLLength
+func (cc *Client) checkURLLength(r *request) error {
+	cc.RLock()
+	max := cc.conf.MaxURLLength
+	cc.RUnlock()
+	if max <= 0 {
+		return nil
+	}
+	if length := len(cc.endpoint(r)); length > max {
+		return &ErrURLTooLong{Length: length, Max: max}
+	}
+	return nil
+}
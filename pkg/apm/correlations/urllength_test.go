@@ -0,0 +1,73 @@
+package correlations
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateMaxURLLength(t *testing.T) {
+	require.NoError(t, validateMaxURLLength(0))
+	require.NoError(t, validateMaxURLLength(2048))
+	require.Error(t, validateMaxURLLength(-1))
+}
+
+func TestCheckURLLength(t *testing.T) {
+	apiURL, err := url.Parse("https://api.signalfx.com")
+	require.NoError(t, err)
+	cc := &Client{APIURL: apiURL}
+	r := &request{
+		Correlation: &Correlation{Type: Service, DimName: "host", DimValue: "a/b", Value: "service"},
+		operation:   OperationDelete,
+	}
+	endpoint := "https://api.signalfx.com/v2/apm/correlate/host/a%2Fb/service/service"
+	require.Equal(t, endpoint, cc.endpoint(r))
+
+	// no limit
+	require.NoError(t, cc.checkURLLength(r))
+
+	// the escaped length of every component counts
+	cc.conf.MaxURLLength = len(endpoint)
+	require.NoError(t, cc.checkURLLength(r))
+
+	cc.conf.MaxURLLength = len(endpoint) - 1
+	err = cc.checkURLLength(r)
+	require.Equal(t, &ErrURLTooLong{Length: len(endpoint), Max: len(endpoint) - 1}, err)
+	require.False(t, err.(CorrelationError).Retryable())
+
+	// correlates don't include the value in the url
+	r.operation = OperationCorrelate
+	require.NoError(t, cc.checkURLLength(r))
+}
+
+func TestCorrelationClientRejectsLongURLs(t *testing.T) {
+	client, serverCh, _, _, cancel := setupUnstarted(t, nil)
+	defer close(serverCh)
+	defer cancel()
+
+	cor := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}
+	client.conf.MaxURLLength = len(client.endpoint(&request{Correlation: cor, operation: OperationDelete}))
+	client.Start()
+
+	long := &Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service2"}
+	results := make(chan map[*Correlation]error, 1)
+	client.DeleteMany(context.Background(), []*Correlation{long}, func(r map[*Correlation]error) { results <- r })
+	deleteErr := (<-results)[long]
+	// the error is reported as a drop that wraps the url length error
+	var tooLong *ErrURLTooLong
+	require.True(t, errors.As(deleteErr, &tooLong))
+	cause, ok := dropCauseForErr(deleteErr)
+	require.True(t, ok)
+	require.Equal(t, DropCauseURLTooLong, cause)
+	require.Equal(t, int64(1), atomic.LoadInt64(&client.TotalURLTooLong))
+	require.Equal(t, int64(1), client.TotalDropped(DropCauseURLTooLong))
+
+	// a url at the limit is sent
+	client.Delete(cor, func(_ *Correlation) {})
+	cors := waitForCors(serverCh, 1, 3)
+	require.Len(t, cors, 1)
+}
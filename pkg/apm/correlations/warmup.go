@@ -0,0 +1,52 @@
+package correlations
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/signalfx/signalfx-agent/pkg/apm/log"
+)
+
+func validateWarmUpConnections(count int) error {
+	if count < 0 {
+		return errors.New("correlation warm up connections must not be negative")
+	}
+	return nil
+}
+
+// warmUp establishes connections to the correlation endpoint's host with concurrent HEAD requests
+// so that they are pooled by the transport for the first requests to reuse.  The responses don't
+// matter, only the connections, so the requests aren't signed and failures are only logged.
+func (cc *Client) warmUp() {
+	defer cc.wg.Done()
+	defer close(cc.warmedUp)
+
+	var wg sync.WaitGroup
+	wg.Add(cc.warmUpConnections)
+	for i := 0; i < cc.warmUpConnections; i++ {
+		go func() {
+			defer wg.Done()
+			if err := cc.warmUpConnection(); err != nil && cc.ctx.Err() == nil {
+				cc.throttledLog.WithError(err).WithFields(log.Fields{"url": cc.APIURL.String()}).ThrottledDebug("Unable to warm up correlation connection")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (cc *Client) warmUpConnection() error {
+	req, err := http.NewRequestWithContext(cc.ctx, http.MethodHead, cc.APIURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := cc.warmUpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	// the body has to be read and closed for the connection to be returned to the pool
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return resp.Body.Close()
+}
@@ -0,0 +1,61 @@
+package correlations
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateWarmUpConnections(t *testing.T) {
+	require.NoError(t, validateWarmUpConnections(0))
+	require.NoError(t, validateWarmUpConnections(4))
+	require.Error(t, validateWarmUpConnections(-1))
+}
+
+func TestCorrelationClientWarmsUpConnections(t *testing.T) {
+	var heads int64
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			atomic.AddInt64(&heads, 1)
+			// hold the connections open until they have all been established
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+	client, cancel := newTestClient(t, handler, func(conf *ClientConfig) {
+		conf.TraceConnections = true
+		conf.WarmUpConnections = 2
+	})
+	defer cancel()
+	client.Start()
+
+	select {
+	case <-client.warmedUp:
+	case <-time.After(3 * time.Second):
+		t.Fatal("connections were not warmed up")
+	}
+	require.Equal(t, int64(2), atomic.LoadInt64(&heads))
+
+	// the first request reuses a warmed up connection
+	done := make(chan error, 1)
+	client.Correlate(&Correlation{Type: Service, DimName: "host", DimValue: "test-box", Value: "service"}, CorrelateCB(func(_ *Correlation, err error) {
+		done <- err
+	}))
+	require.NoError(t, <-done)
+	require.Equal(t, int64(1), atomic.LoadInt64(&client.TotalConnReused))
+	require.Equal(t, int64(0), atomic.LoadInt64(&client.TotalConnNew))
+}
+
+func TestCorrelationClientWarmUpDisabled(t *testing.T) {
+	client, cancel := newTestClient(t, http.NotFoundHandler(), nil)
+	defer cancel()
+	client.Start()
+
+	select {
+	case <-client.warmedUp:
+		t.Fatal("connections should not be warmed up")
+	default:
+	}
+}
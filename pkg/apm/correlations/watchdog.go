@@ -0,0 +1,86 @@
+package correlations
+
+import (
+	"sync"
+	"time"
+)
+
+// watchdog detects when requests are being made but none of them succeed.  The client is stalled
+// once requests have been enqueued without a single success for the whole window, which
+// distinguishes a client that is failing from one that is idle.  It fires once per stall and is
+// re-armed by the next success.
+// A nil watchdog ignores everything recorded.
+// this is threadsafe
+type watchdog struct {
+	sync.Mutex
+	window    time.Duration
+	onStalled func()
+
+	// when the first request since the last success was enqueued, zero if none has been
+	waitingSince time.Time
+	fired        bool
+}
+
+func newWatchdog(window time.Duration, onStalled func()) *watchdog {
+	if window <= 0 || onStalled == nil {
+		return nil
+	}
+	return &watchdog{window: window, onStalled: onStalled}
+}
+
+// recordEnqueue records that a request was enqueued
+func (w *watchdog) recordEnqueue(now time.Time) {
+	if w == nil {
+		return
+	}
+	w.Lock()
+	defer w.Unlock()
+	if w.waitingSince.IsZero() {
+		w.waitingSince = now
+	}
+}
+
+// recordSuccess records that a request succeeded
+func (w *watchdog) recordSuccess() {
+	if w == nil {
+		return
+	}
+	w.Lock()
+	defer w.Unlock()
+	w.waitingSince = time.Time{}
+	w.fired = false
+}
+
+// check fires onStalled if the client has stalled and it hasn't already fired for this stall
+func (w *watchdog) check(now time.Time) {
+	w.Lock()
+	stalled := !w.fired && !w.waitingSince.IsZero() && now.Sub(w.waitingSince) >= w.window
+	if stalled {
+		w.fired = true
+	}
+	w.Unlock()
+
+	if stalled {
+		w.onStalled()
+	}
+}
+
+// watchForStalls is a routine that periodically checks whether the client has stalled
+func (cc *Client) watchForStalls() {
+	defer cc.wg.Done()
+	// check more often than the window so that a stall is noticed soon after it happens
+	interval := cc.watchdog.window / 4
+	if interval <= 0 {
+		interval = cc.watchdog.window
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cc.ctx.Done():
+			return
+		case <-ticker.C:
+			cc.watchdog.check(cc.now())
+		}
+	}
+}
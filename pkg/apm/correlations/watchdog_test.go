@@ -0,0 +1,40 @@
+package correlations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchdogFiresOncePerStall(t *testing.T) {
+	var stalls int
+	w := newWatchdog(time.Minute, func() { stalls++ })
+	now := time.Unix(1000, 0)
+
+	// an idle client isn't stalled
+	w.check(now.Add(time.Hour))
+	require.Equal(t, 0, stalls)
+
+	w.recordEnqueue(now)
+	w.recordEnqueue(now.Add(30 * time.Second))
+	w.check(now.Add(59 * time.Second))
+	require.Equal(t, 0, stalls)
+	w.check(now.Add(time.Minute))
+	require.Equal(t, 1, stalls)
+	w.check(now.Add(2 * time.Minute))
+	require.Equal(t, 1, stalls)
+
+	// a success re-arms the watchdog and restarts the window
+	w.recordSuccess()
+	w.recordEnqueue(now.Add(3 * time.Minute))
+	w.check(now.Add(3*time.Minute + 30*time.Second))
+	require.Equal(t, 1, stalls)
+	w.check(now.Add(4 * time.Minute))
+	require.Equal(t, 2, stalls)
+}
+
+func TestWatchdogDisabled(t *testing.T) {
+	require.Nil(t, newWatchdog(0, func() {}))
+	require.Nil(t, newWatchdog(time.Minute, nil))
+}
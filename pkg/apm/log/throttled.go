@@ -0,0 +1,105 @@
+package log
+
+import (
+	"sync"
+	"time"
+)
+
+// maxThrottledMessages bounds the number of distinct messages tracked by a throttled logger.  In the
+// common case only a small handful of messages are repeated so the history is simply reset if the
+// limit is hit.
+const maxThrottledMessages = 100
+
+// throttleHistory records when messages were last logged and is shared by all copies of a ThrottledLogger
+type throttleHistory struct {
+	sync.Mutex
+	lastSeen map[string]time.Time
+}
+
+// ThrottledLogger is a logger that limits how often the same message is logged.  Throttling is explicit
+// in the method names since most messages should be logged without being throttled.
+type ThrottledLogger struct {
+	Logger
+	duration time.Duration
+	history  *throttleHistory
+	now      func() time.Time
+	// key distinguishes messages that are throttled separately even though their text is the same
+	key string
+}
+
+// NewThrottledLogger returns a logger that logs each distinct throttled message at most once per duration
+func NewThrottledLogger(logger Logger, duration time.Duration) *ThrottledLogger {
+	return &ThrottledLogger{
+		Logger:   logger,
+		duration: duration,
+		history:  &throttleHistory{lastSeen: make(map[string]time.Time)},
+		now:      time.Now,
+	}
+}
+
+func (tl *ThrottledLogger) copy(logger Logger) *ThrottledLogger {
+	return &ThrottledLogger{
+		Logger:   logger,
+		duration: tl.duration,
+		history:  tl.history,
+		now:      tl.now,
+		key:      tl.key,
+	}
+}
+
+// WithFields is equivalent to Logger.WithFields but retains throttling
+func (tl *ThrottledLogger) WithFields(fields Fields) *ThrottledLogger {
+	return tl.copy(tl.Logger.WithFields(fields))
+}
+
+// WithError is equivalent to Logger.WithError but retains throttling
+func (tl *ThrottledLogger) WithError(err error) *ThrottledLogger {
+	return tl.copy(tl.Logger.WithError(err))
+}
+
+// WithThrottleKey returns a logger that throttles its messages separately from the same messages
+// logged with a different key, e.g. so that a failure for one item doesn't hide failures for others
+func (tl *ThrottledLogger) WithThrottleKey(key string) *ThrottledLogger {
+	throttled := tl.copy(tl.Logger)
+	throttled.key = key
+	return throttled
+}
+
+// shouldLog returns true if the message at the level hasn't been logged within the throttle duration
+func (tl *ThrottledLogger) shouldLog(level string, msg string) bool {
+	key := level + ":" + tl.key + ":" + msg
+	rightNow := tl.now()
+
+	tl.history.Lock()
+	defer tl.history.Unlock()
+
+	if lastSeen, ok := tl.history.lastSeen[key]; ok && lastSeen.Add(tl.duration).After(rightNow) {
+		return false
+	}
+	if len(tl.history.lastSeen) >= maxThrottledMessages {
+		tl.history.lastSeen = make(map[string]time.Time)
+	}
+	tl.history.lastSeen[key] = rightNow
+	return true
+}
+
+// ThrottledDebug logs a debug message, throttled
+func (tl *ThrottledLogger) ThrottledDebug(msg string) {
+	if tl.shouldLog("debug", msg) {
+		tl.Logger.Debug(msg)
+	}
+}
+
+// ThrottledWarn logs a warning message, throttled
+func (tl *ThrottledLogger) ThrottledWarn(msg string) {
+	if tl.shouldLog("warn", msg) {
+		tl.Logger.Warn(msg)
+	}
+}
+
+// ThrottledError logs an error message, throttled
+func (tl *ThrottledLogger) ThrottledError(msg string) {
+	if tl.shouldLog("error", msg) {
+		tl.Logger.Error(msg)
+	}
+}
@@ -0,0 +1,45 @@
+package log
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingLogger struct {
+	nilLogger
+	errors *int
+}
+
+func (c countingLogger) Error(msg string) {
+	*c.errors++
+}
+
+func (c countingLogger) WithFields(fields Fields) Logger {
+	return c
+}
+
+func TestThrottledLogger(t *testing.T) {
+	var errors int
+	tl := NewThrottledLogger(countingLogger{errors: &errors}, time.Minute)
+	now := time.Unix(100, 0)
+	tl.now = func() time.Time { return now }
+
+	tl.ThrottledError("first")
+	tl.WithFields(Fields{"a": "b"}).ThrottledError("first")
+	assert.Equal(t, 1, errors, "repeated message should be throttled, including on derived loggers")
+
+	tl.ThrottledError("second")
+	assert.Equal(t, 2, errors, "distinct message should not be throttled")
+
+	now = now.Add(time.Minute)
+	tl.ThrottledError("first")
+	assert.Equal(t, 3, errors, "message should be logged again after the throttle duration")
+
+	tl.WithThrottleKey("a").ThrottledError("first")
+	tl.WithThrottleKey("b").WithFields(Fields{"a": "b"}).ThrottledError("first")
+	assert.Equal(t, 5, errors, "message with a distinct key should not be throttled")
+	tl.WithThrottleKey("a").ThrottledError("first")
+	assert.Equal(t, 5, errors, "repeated message with the same key should be throttled")
+}
@@ -0,0 +1,129 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/signalfx/golib/v3/datapoint"
+	"github.com/signalfx/golib/v3/sfxclient"
+)
+
+// DialFunc dials a network address, e.g. net.Dialer.DialContext
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// LookupFunc resolves a host to its IP addresses, e.g. net.Resolver.LookupHost
+type LookupFunc func(ctx context.Context, host string) ([]string, error)
+
+type entry struct {
+	addrs     []string
+	expiresAt time.Time
+	// next is the index of the address the next connection is dialed to first
+	next uint32
+}
+
+// Resolver caches the addresses hosts resolve to for a TTL so that each new connection doesn't
+// need a lookup.  Connections are spread across a host's addresses round robin.  If a lookup fails
+// after an entry expires, the expired addresses continue to be used until a lookup succeeds.
+// this is threadsafe
+type Resolver struct {
+	sync.Mutex
+	ttl     time.Duration
+	lookup  LookupFunc
+	entries map[string]*entry
+
+	// For easier unit testing
+	now func() time.Time
+
+	TotalHits   int64
+	TotalMisses int64
+}
+
+// NewResolver returns a resolver that caches lookups for the ttl.  If lookup is nil the default
+// net.Resolver is used.
+func NewResolver(ttl time.Duration, lookup LookupFunc) *Resolver {
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupHost
+	}
+	return &Resolver{
+		ttl:     ttl,
+		lookup:  lookup,
+		entries: make(map[string]*entry),
+		now:     time.Now,
+	}
+}
+
+// resolve returns the addresses for the host, ordered starting with the address to try first
+func (r *Resolver) resolve(ctx context.Context, host string) ([]string, error) {
+	r.Lock()
+	e, ok := r.entries[host]
+	r.Unlock()
+
+	if ok && r.now().Before(e.expiresAt) {
+		atomic.AddInt64(&r.TotalHits, int64(1))
+		return e.rotate(), nil
+	}
+	atomic.AddInt64(&r.TotalMisses, int64(1))
+
+	addrs, err := r.lookup(ctx, host)
+	if err == nil && len(addrs) == 0 {
+		err = errors.New("no addresses found for host " + host)
+	}
+	if err != nil {
+		if ok {
+			// tolerate transient lookup failures by using the expired addresses
+			return e.rotate(), nil
+		}
+		return nil, err
+	}
+
+	e = &entry{addrs: addrs, expiresAt: r.now().Add(r.ttl)}
+	r.Lock()
+	r.entries[host] = e
+	r.Unlock()
+	return e.rotate(), nil
+}
+
+// rotate returns the addresses starting at the next one in the round robin
+func (e *entry) rotate() []string {
+	start := int(atomic.AddUint32(&e.next, 1)-1) % len(e.addrs)
+	return append(append(make([]string, 0, len(e.addrs)), e.addrs[start:]...), e.addrs[:start]...)
+}
+
+// DialContext returns a dial function for an http.Transport that dials the cached addresses of
+// the host using dial.  Each address is tried in turn until a connection is made.
+func (r *Resolver) DialContext(dial DialFunc) DialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		// addresses that are already IPs don't need resolving
+		if net.ParseIP(host) != nil {
+			return dial(ctx, network, address)
+		}
+
+		addrs, err := r.resolve(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			var conn net.Conn
+			if conn, err = dial(ctx, network, net.JoinHostPort(addr, port)); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// InternalMetrics returns datapoints that describe the cache's hits and misses
+func (r *Resolver) InternalMetrics(client string) []*datapoint.Datapoint {
+	return []*datapoint.Datapoint{
+		sfxclient.CumulativeP("sfxagent.dns_cache_hits", map[string]string{"client": client}, &r.TotalHits),
+		sfxclient.CumulativeP("sfxagent.dns_cache_misses", map[string]string{"client": client}, &r.TotalMisses),
+	}
+}
@@ -0,0 +1,56 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolver(t *testing.T) {
+	lookups := 0
+	var lookupErr error
+	r := NewResolver(time.Minute, func(_ context.Context, host string) ([]string, error) {
+		lookups++
+		return []string{"10.0.0.1", "10.0.0.2"}, lookupErr
+	})
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	var dialed []string
+	dial := r.DialContext(func(_ context.Context, _, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		if address == "10.0.0.2:443" {
+			return nil, errors.New("refused")
+		}
+		return nil, nil
+	})
+
+	_, err := dial(context.Background(), "tcp", "ingest.example.com:443")
+	require.NoError(t, err)
+	// the next connection starts at the other address and falls back to the first when it fails
+	_, err = dial(context.Background(), "tcp", "ingest.example.com:443")
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.0.1:443", "10.0.0.2:443", "10.0.0.1:443"}, dialed)
+	require.Equal(t, 1, lookups)
+	require.Equal(t, int64(1), r.TotalHits)
+	require.Equal(t, int64(1), r.TotalMisses)
+
+	// expired addresses are still used when the lookup fails
+	now = now.Add(2 * time.Minute)
+	lookupErr = errors.New("lookup failed")
+	_, err = dial(context.Background(), "tcp", "ingest.example.com:443")
+	require.NoError(t, err)
+	require.Equal(t, 2, lookups)
+
+	// IP addresses aren't looked up
+	_, err = dial(context.Background(), "tcp", "10.0.0.3:443")
+	require.NoError(t, err)
+	require.Equal(t, 2, lookups)
+
+	_, err = dial(context.Background(), "tcp", "other.example.com:443")
+	require.Error(t, err)
+}
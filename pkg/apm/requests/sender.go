@@ -3,11 +3,15 @@ package requests
 import (
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"sync/atomic"
 )
 
+// ReqSender sends requests using a bounded number of workers.  A single ReqSender is safe to
+// share between clients, in which case they are all limited by its worker count and their
+// requests are reported together in its counters.
 type ReqSender struct {
 	client      *http.Client
 	requests    chan *http.Request
@@ -32,6 +36,8 @@ func NewReqSender(ctx context.Context, client *http.Client, workerCount uint, cl
 	}
 }
 
+// Send hands the request to a worker, blocking until one is free.  If the request's context or
+// the sender's context is done before then, the request fails without being sent.
 func (rs *ReqSender) Send(req *http.Request) {
 	// Slight optimization to avoid spinning up unnecessary workers if there
 	// aren't ever that many dim updates. Once workers start, they remain for the
@@ -40,17 +46,38 @@ func (rs *ReqSender) Send(req *http.Request) {
 	case rs.requests <- req:
 		return
 	default:
-		if atomic.LoadInt64(&rs.RunningWorkers) < int64(atomic.LoadUint32(&rs.workerCount)) {
+		if rs.reserveWorker() {
 			go rs.processRequests()
 		}
 
-		// Block until we can get through a request
-		rs.requests <- req
+		// Block until we can get through a request, giving up if the request or sender is done
+		select {
+		case rs.requests <- req:
+		case <-req.Context().Done():
+			onRequestFailed(req, nil, 0, nil, fmt.Errorf("request to %s was not sent: %w", req.URL.String(), req.Context().Err()))
+		case <-rs.ctx.Done():
+			onRequestFailed(req, nil, 0, nil, fmt.Errorf("request to %s was not sent: %w", req.URL.String(), rs.ctx.Err()))
+		}
+	}
+}
+
+// reserveWorker claims a slot for a new worker if the worker count hasn't been reached.  The slot
+// is claimed atomically so that concurrent senders can't start more workers than allowed.
+func (rs *ReqSender) reserveWorker() bool {
+	for {
+		running := atomic.LoadInt64(&rs.RunningWorkers)
+		if running >= int64(atomic.LoadUint32(&rs.workerCount)) {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&rs.RunningWorkers, running, running+1) {
+			return true
+		}
 	}
 }
 
+// processRequests sends requests until the context is done.  The worker slot must already have
+// been reserved with reserveWorker.
 func (rs *ReqSender) processRequests() {
-	atomic.AddInt64(&rs.RunningWorkers, int64(1))
 	defer atomic.AddInt64(&rs.RunningWorkers, int64(-1))
 
 	for {
@@ -69,20 +96,20 @@ func (rs *ReqSender) processRequests() {
 }
 
 func (rs *ReqSender) sendRequest(req *http.Request) error {
-	body, statusCode, err := sendRequest(rs.client, req)
+	body, statusCode, header, err := sendRequest(rs.client, req)
 	// If it was successful there is nothing else to do.
-	if statusCode == 200 {
-		onRequestSuccess(req, body)
+	if isSuccess(req, statusCode) && err == nil {
+		onRequestSuccess(req, body, statusCode, header)
 		return nil
 	}
 
 	if err != nil {
-		err = fmt.Errorf("error making HTTP request to %s: %v", req.URL.String(), err)
+		err = fmt.Errorf("error making HTTP request to %s: %w", req.URL.String(), err)
 	} else {
 		err = fmt.Errorf("unexpected status code %d on response for request to %s: %s", statusCode, req.URL.String(), string(body))
 	}
 
-	onRequestFailed(req, body, statusCode, err)
+	onRequestFailed(req, body, statusCode, header, err)
 
 	return err
 }
@@ -91,20 +118,89 @@ type key int
 
 const RequestFailedCallbackKey key = 1
 const RequestSuccessCallbackKey key = 2
+const RequestFailedHeaderCallbackKey key = 3
+const RequestSuccessHeaderCallbackKey key = 4
+
+// ResponseBodyLimitKey is the context key of the maximum number of bytes of the response body to
+// read, as an int64.  The limit is enforced while reading so that it holds for chunked responses
+// and responses with a wrong Content-Length.  A longer body fails the request with an
+// *ErrResponseTooLarge.
+const ResponseBodyLimitKey key = 5
+
+// AnySuccessStatusKey is the context key of a bool that makes any 2xx status a successful request
+// when true.  Otherwise only a 200 is.
+const AnySuccessStatusKey key = 6
+
+// ErrResponseTooLarge is the error for a response with a body longer than the request's limit
+type ErrResponseTooLarge struct {
+	Limit int64
+}
+
+func (e *ErrResponseTooLarge) Error() string {
+	return fmt.Sprintf("response body exceeds the limit of %d bytes", e.Limit)
+}
+
+// ErrInformationalResponse is the error for a request whose final response has a 1xx status
+type ErrInformationalResponse struct {
+	StatusCode int
+}
+
+func (e *ErrInformationalResponse) Error() string {
+	return fmt.Sprintf("unexpected informational status code %d as the final response", e.StatusCode)
+}
 
 type RequestFailedCallback func(body []byte, statusCode int, err error)
 type RequestSuccessCallback func([]byte)
 
-func onRequestSuccess(req *http.Request, body []byte) {
+// RequestFailedHeaderCallback is like RequestFailedCallback but also receives the response header.
+// The header is nil if no response was received.  It takes precedence over a RequestFailedCallback
+// on the same request.
+type RequestFailedHeaderCallback func(body []byte, statusCode int, header http.Header, err error)
+
+// RequestSuccessHeaderCallback is like RequestSuccessCallback but also receives the response status
+// code and header.  It takes precedence over a RequestSuccessCallback on the same request.
+type RequestSuccessHeaderCallback func(body []byte, statusCode int, header http.Header)
+
+// IsInformationalStatus returns whether the status code is a 1xx code.  The http client waits for
+// the final response after interim 1xx responses, so one is only seen as the final status if the
+// server switched protocols, which requests sent by a ReqSender never ask for.  It is treated as a
+// failure with an *ErrInformationalResponse.
+func IsInformationalStatus(statusCode int) bool {
+	return statusCode >= 100 && statusCode < 200
+}
+
+// IsSuccessStatus returns whether the status code is a 2xx code, which is treated as a successful
+// request if the request sets AnySuccessStatusKey
+func IsSuccessStatus(statusCode int) bool {
+	return statusCode >= 200 && statusCode < 300
+}
+
+// isSuccess returns whether the status code makes the request successful
+func isSuccess(req *http.Request, statusCode int) bool {
+	if anyStatus, _ := req.Context().Value(AnySuccessStatusKey).(bool); anyStatus {
+		return IsSuccessStatus(statusCode)
+	}
+	return statusCode == http.StatusOK
+}
+
+func onRequestSuccess(req *http.Request, body []byte, statusCode int, header http.Header) {
 	ctx := req.Context()
+	if headerCb, ok := ctx.Value(RequestSuccessHeaderCallbackKey).(RequestSuccessHeaderCallback); ok {
+		headerCb(body, statusCode, header)
+		return
+	}
 	cb, ok := ctx.Value(RequestSuccessCallbackKey).(RequestSuccessCallback)
 	if !ok {
 		return
 	}
 	cb(body)
 }
-func onRequestFailed(req *http.Request, body []byte, statusCode int, err error) {
+func onRequestFailed(req *http.Request, body []byte, statusCode int, header http.Header, err error) {
 	ctx := req.Context()
+	if headerCb, ok := ctx.Value(RequestFailedHeaderCallbackKey).(RequestFailedHeaderCallback); ok {
+		headerCb(body, statusCode, header, err)
+		return
+	}
 	cb, ok := ctx.Value(RequestFailedCallbackKey).(RequestFailedCallback)
 	if !ok {
 		return
@@ -112,14 +208,29 @@ func onRequestFailed(req *http.Request, body []byte, statusCode int, err error)
 	cb(body, statusCode, err)
 }
 
-func sendRequest(client *http.Client, req *http.Request) ([]byte, int, error) {
+func sendRequest(client *http.Client, req *http.Request) ([]byte, int, http.Header, error) {
 	resp, err := client.Do(req)
 
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, nil, err
 	}
 	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
-	return body, resp.StatusCode, err
+	// the body of a protocol switch is the connection itself, so it isn't read
+	if IsInformationalStatus(resp.StatusCode) {
+		return nil, resp.StatusCode, resp.Header, &ErrInformationalResponse{StatusCode: resp.StatusCode}
+	}
+
+	limit, limited := req.Context().Value(ResponseBodyLimitKey).(int64)
+	if !limited {
+		body, err := ioutil.ReadAll(resp.Body)
+		return body, resp.StatusCode, resp.Header, err
+	}
+
+	// read one byte more than the limit to tell a body of exactly the limit from a longer one
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err == nil && int64(len(body)) > limit {
+		return nil, resp.StatusCode, resp.Header, &ErrResponseTooLarge{Limit: limit}
+	}
+	return body, resp.StatusCode, resp.Header, err
 }
@@ -2,6 +2,12 @@ package tracetracker
 
 import (
 	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -11,6 +17,7 @@ import (
 	"github.com/signalfx/golib/v3/pointer"
 	"github.com/signalfx/golib/v3/trace"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/signalfx/signalfx-agent/pkg/apm/correlations"
 	"github.com/signalfx/signalfx-agent/pkg/apm/log"
@@ -149,14 +156,14 @@ func (c *correlationTestClient) Get(dimName string, dimValue string, cb correlat
 		}
 	}()
 }
-func (c *correlationTestClient) Correlate(cl *correlations.Correlation, cb correlations.CorrelateCB) {
+func (c *correlationTestClient) Correlate(cl *correlations.Correlation, cb correlations.CorrelateCB, _ ...correlations.RequestOptions) {
 	c.Lock()
 	defer c.Unlock()
 	c.cors = append(c.cors, cl)
 	cb(cl, nil)
 	atomic.AddInt64(&c.correlateCounter, 1)
 }
-func (c *correlationTestClient) Delete(cl *correlations.Correlation, cb correlations.SuccessfulDeleteCB) {
+func (c *correlationTestClient) Delete(cl *correlations.Correlation, cb correlations.SuccessfulDeleteCB, _ ...correlations.RequestOptions) {
 	c.Lock()
 	defer c.Unlock()
 	c.cors = append(c.cors, cl)
@@ -299,3 +306,52 @@ func TestCorrelationUpdates(t *testing.T) {
 	assert.Equal(t, int64(4), a.hostServiceCache.PurgedCount, "purgedServiceCount is not properly tracked")
 	assert.Equal(t, int64(4), a.hostEnvironmentCache.PurgedCount, "activeEnvironmentCount is not properly tracked")
 }
+
+func TestDeduplicatedCorrelationIsCached(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracetracker")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var puts int64
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			atomic.AddInt64(&puts, 1)
+			return
+		}
+		_, _ = rw.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	hostIDDims := map[string]string{"host": "test"}
+	spans := []*trace.Span{{
+		LocalEndpoint: &trace.Endpoint{ServiceName: pointer.String("one")},
+		Tags:          hostIDDims,
+	}}
+	// track runs a tracker with a client using the dedup state file until the service is cached
+	track := func() {
+		client, err := correlations.NewCorrelationClient(log.Nil, context.Background(), &http.Client{}, correlations.ClientConfig{
+			Config: correlations.Config{
+				MaxRequests:    10,
+				MaxBuffered:    10,
+				DedupStateFile: filepath.Join(dir, "state.json"),
+			},
+			URL: serverURL,
+		})
+		require.NoError(t, err)
+		client.Start()
+		defer client.(*correlations.Client).Stop()
+
+		a := New(log.Nil, 5*time.Minute, client, hostIDDims, false, nil, DefaultDimsToSyncSource)
+		a.AddSpans(context.Background(), spans)
+		require.Eventually(t, func() bool { return a.hostServiceCache.GetActiveCount() == 1 }, 5*time.Second, 10*time.Millisecond)
+	}
+
+	track()
+	require.Equal(t, int64(1), atomic.LoadInt64(&puts))
+	// after a restart the correlation isn't sent again, but the tracker still caches the service
+	// since the client reports it as made
+	track()
+	require.Equal(t, int64(1), atomic.LoadInt64(&puts))
+}
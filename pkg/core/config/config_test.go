@@ -58,4 +58,40 @@ func TestWriterOutputValidation(t *testing.T) {
 		err := c.validate()
 		require.Nil(t, err)
 	})
+
+	t.Run("correlation local address can't be used with a unix socket", func(t *testing.T) {
+		c := &Config{
+			Writer: WriterConfig{
+				PropertiesUnixSocketPath: "/var/run/proxy.sock",
+				PropertiesLocalAddress:   "127.0.0.1",
+			},
+			Monitors: []MonitorConfig{
+				{},
+				{ProcPath: "/proc"},
+			},
+		}
+		require.Nil(t, defaults.Set(c))
+
+		err := c.validate()
+		require.NotNil(t, err)
+		require.Contains(t, err.Error(), "propertiesLocalAddress")
+	})
+
+	t.Run("correlation dns cache can't be used with a unix socket", func(t *testing.T) {
+		c := &Config{
+			Writer: WriterConfig{
+				PropertiesUnixSocketPath:     "/var/run/proxy.sock",
+				PropertiesDNSCacheTTLSeconds: 30,
+			},
+			Monitors: []MonitorConfig{
+				{},
+				{ProcPath: "/proc"},
+			},
+		}
+		require.Nil(t, defaults.Set(c))
+
+		err := c.validate()
+		require.NotNil(t, err)
+		require.Contains(t, err.Error(), "propertiesDNSCacheTTLSeconds")
+	})
 }
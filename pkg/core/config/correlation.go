@@ -9,14 +9,41 @@ import (
 func ClientConfigFromWriterConfig(conf *WriterConfig) correlations.ClientConfig {
 	return correlations.ClientConfig{
 		Config: correlations.Config{
-			MaxRequests:     conf.PropertiesMaxRequests,
-			MaxBuffered:     conf.PropertiesMaxBuffered,
-			MaxRetries:      conf.TraceHostCorrelationMaxRequestRetries,
-			LogUpdates:      conf.LogDimensionUpdates,
-			RetryDelay:      time.Duration(conf.PropertiesSendDelaySeconds) * time.Second,
-			CleanupInterval: conf.TraceHostCorrelationPurgeInterval.AsDuration(),
+			MaxRequests:          conf.PropertiesMaxRequests,
+			MaxBuffered:          conf.PropertiesMaxBuffered,
+			MaxRetries:           conf.TraceHostCorrelationMaxRequestRetries,
+			LogUpdates:           conf.LogDimensionUpdates,
+			RetryDelay:           time.Duration(conf.PropertiesSendDelaySeconds) * time.Second,
+			CleanupInterval:      conf.TraceHostCorrelationPurgeInterval.AsDuration(),
+			StartupJitter:        time.Duration(conf.PropertiesStartupJitterSeconds) * time.Second,
+			BackoffStrategy:      correlations.BackoffStrategy(conf.PropertiesBackoffStrategy),
+			MaxRetryDelay:        time.Duration(conf.PropertiesMaxBackoffSeconds) * time.Second,
+			MaxGetRequests:       conf.PropertiesMaxGetRequests,
+			InitialRetryDelay:    time.Duration(conf.PropertiesInitialRetryDelaySeconds) * time.Second,
+			DebugLogRequests:     conf.PropertiesDebugLogRequests,
+			RetryLogVerbosity:    correlations.RetryLogVerbosity(conf.PropertiesRetryLogVerbosity),
+			DedupMaxEntries:      int(conf.PropertiesDedupMaxEntries),
+			GetMaxRetries:        conf.PropertiesGetMaxRetries,
+			GetRetryDelay:        time.Duration(conf.PropertiesGetRetryDelaySeconds) * time.Second,
+			MinRetryInterval:     time.Duration(conf.PropertiesMinRetryIntervalSeconds) * time.Second,
+			MaxEntriesStatusCode: int(conf.PropertiesMaxEntriesStatusCode),
+			DedupStateFile:       conf.PropertiesDedupStateFile,
+			DedupStateWindow:     time.Duration(conf.PropertiesDedupStateWindowSeconds) * time.Second,
+			Retry: correlations.RetryConfig{
+				Correlate: conf.PropertiesRetry.Correlate.operationRetry(),
+				Delete:    conf.PropertiesRetry.Delete.operationRetry(),
+				Get:       conf.PropertiesRetry.Get.operationRetry(),
+			},
 		},
 		AccessToken: conf.SignalFxAccessToken,
 		URL:         conf.ParsedAPIURL(),
 	}
 }
+
+func (c OperationRetryConfig) operationRetry() correlations.OperationRetry {
+	return correlations.OperationRetry{
+		Disabled:   c.Enabled != nil && !*c.Enabled,
+		MaxRetries: c.MaxRetries,
+		RetryDelay: time.Duration(c.RetryDelaySeconds) * time.Second,
+	}
+}
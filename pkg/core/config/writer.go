@@ -106,6 +106,104 @@ type WriterConfig struct {
 	TraceHostCorrelationMetricsInterval timeutil.Duration `yaml:"traceHostCorrelationMetricsInterval" default:"1m"`
 	// How many times to retry requests related to trace host correlation
 	TraceHostCorrelationMaxRequestRetries uint `yaml:"traceHostCorrelationMaxRequestRetries" default:"2"`
+	// The maximum number of seconds to randomly delay the start of trace host
+	// correlation requests by.  This helps spread out the load on the backend
+	// when a large number of agents are restarted at the same time.
+	PropertiesStartupJitterSeconds uint `yaml:"propertiesStartupJitterSeconds" default:"0"`
+	// How to compute the delay between retries of trace host correlation
+	// requests.  `constant` waits `propertiesSendDelaySeconds` before every
+	// retry.  `full_jitter` waits a random duration between zero and
+	// `propertiesSendDelaySeconds` doubled for each previous retry, capped at
+	// `propertiesMaxBackoffSeconds`.
+	PropertiesBackoffStrategy string `yaml:"propertiesBackoffStrategy" default:"constant"`
+	// The maximum number of seconds to wait between retries of trace host
+	// correlation requests when `propertiesBackoffStrategy` is `full_jitter`.
+	PropertiesMaxBackoffSeconds uint `yaml:"propertiesMaxBackoffSeconds" default:"300"`
+	// The maximum number of concurrent requests that fetch trace host
+	// correlations.  These count towards `propertiesMaxRequests`, so setting
+	// this lower leaves room for correlation updates when many are fetched at
+	// once, e.g. on startup.  If 0, fetches are only limited by
+	// `propertiesMaxRequests`.
+	PropertiesMaxGetRequests uint `yaml:"propertiesMaxGetRequests" default:"0"`
+	// How long, in seconds, the addresses that the ingest host resolves to
+	// are cached for when connecting to send correlation updates.
+	// Connections are spread across the cached addresses.  If 0, every new
+	// connection resolves the host with the standard resolver.
+	PropertiesDNSCacheTTLSeconds uint `yaml:"propertiesDNSCacheTTLSeconds" default:"0"`
+	// The number of seconds to wait before the first retry of a failed trace
+	// host correlation request, giving the backend longer to recover from the
+	// first failure.  Later retries are spaced by `propertiesSendDelaySeconds`.
+	// If 0, the first retry also waits `propertiesSendDelaySeconds`.
+	PropertiesInitialRetryDelaySeconds uint `yaml:"propertiesInitialRetryDelaySeconds" default:"0"`
+	// The path of a Unix domain socket to send trace host correlation
+	// requests through instead of connecting to the host of `apiUrl`, e.g.
+	// when an ingest proxy runs as a sidecar.  The host of `apiUrl` is then
+	// only a placeholder, but its scheme and path are still used.  The socket
+	// must exist when the writer is created.  Proxy environment variables
+	// aren't used for it.  Can't be set together with a non-zero
+	// `propertiesDNSCacheTTLSeconds`.
+	PropertiesUnixSocketPath string `yaml:"propertiesUnixSocketPath"`
+	// The IP address to send trace host correlation requests from on hosts
+	// with several network interfaces, e.g. when the ingest side only allows
+	// traffic from certain addresses.  The address must be an IP without a
+	// port.  If unset, the operating system chooses the source address.  Can't
+	// be set together with `propertiesUnixSocketPath`.
+	PropertiesLocalAddress string `yaml:"propertiesLocalAddress"`
+	// If true, the method and endpoint of each trace host correlation
+	// request are logged at debug level, which helps diagnose how dimension
+	// values are encoded.  Each distinct endpoint is logged at most once every
+	// 20 seconds.
+	PropertiesDebugLogRequests bool `yaml:"propertiesDebugLogRequests" default:"false"`
+	// How much is logged about trace host correlation requests that are
+	// retried.  `all` logs each retry at debug level.  `first_and_last` logs
+	// the first retry of a request and whether it finally succeeded or
+	// failed, and `terminal` only logs whether it finally succeeded or
+	// failed.  Both are throttled to keep the log volume down during outages.
+	PropertiesRetryLogVerbosity string `yaml:"propertiesRetryLogVerbosity" default:"all"`
+	// How many pending trace host correlation updates are remembered so that
+	// duplicates of them aren't sent, separately for updates that add and
+	// remove correlations.  A larger value catches duplicates made further
+	// apart at the cost of memory, without changing how many updates are
+	// buffered.  If 0, `propertiesMaxBuffered` is used.
+	PropertiesDedupMaxEntries uint `yaml:"propertiesDedupMaxEntries" default:"0"`
+	// How many times a request that fetches the trace host correlations of a
+	// dimension, e.g. on startup, is retried.  Fetches are cheap and don't
+	// change anything, so they can be retried more than updates.  If 0,
+	// `traceHostCorrelationMaxRequestRetries` is used.
+	PropertiesGetMaxRetries uint `yaml:"propertiesGetMaxRetries" default:"0"`
+	// The number of seconds to wait between retries of a request that fetches
+	// the trace host correlations of a dimension.  If 0, fetches are retried
+	// like updates.
+	PropertiesGetRetryDelaySeconds uint `yaml:"propertiesGetRetryDelaySeconds" default:"0"`
+	// The minimum number of seconds between retries of the same trace host
+	// correlation, even by separate requests, which caps how often a
+	// correlation that keeps failing is sent.  A retry sooner than this waits
+	// until the interval has passed.  If 0, retries aren't limited.
+	PropertiesMinRetryIntervalSeconds uint `yaml:"propertiesMinRetryIntervalSeconds" default:"0"`
+	// If true, an agent event is sent for each trace host correlation request
+	// that fails and won't be retried, e.g. because the access token was
+	// refused or every retry failed, with the dimension and the category of
+	// the failure.
+	PropertiesFailureEvents bool `yaml:"propertiesFailureEvents" default:"false"`
+	// The status code the backend responds with when a dimension already has
+	// the maximum number of trace host correlations of a type, for deployments
+	// behind proxies that rewrite the 418 the backend sends.  Correlations
+	// that receive it aren't retried.
+	PropertiesMaxEntriesStatusCode uint `yaml:"propertiesMaxEntriesStatusCode" default:"418"`
+	// Configures retries of trace host correlation requests separately for
+	// each kind of request: `correlate` for requests that add correlations,
+	// `delete` for requests that remove them and `get` for requests that fetch
+	// the correlations of a dimension.  By default every kind is retried like
+	// the others.
+	PropertiesRetry CorrelationRetryConfig `yaml:"propertiesRetry" default:"{}"`
+	// The file in which the trace host correlation updates that were sent
+	// successfully are remembered, so that identical updates made within
+	// `propertiesDedupStateWindowSeconds` after the agent restarts aren't sent
+	// again.  If empty, only pending updates are deduplicated.
+	PropertiesDedupStateFile string `yaml:"propertiesDedupStateFile"`
+	// How many seconds an update is remembered in `propertiesDedupStateFile`.
+	// If 0, updates are remembered for an hour.
+	PropertiesDedupStateWindowSeconds uint `yaml:"propertiesDedupStateWindowSeconds" default:"0"`
 	// How many trace spans are allowed to be in the process of sending.  While
 	// this number is exceeded, the oldest spans will be discarded to
 	// accommodate new spans generated to avoid memory exhaustion.  If you see
@@ -184,6 +282,14 @@ func (wc *WriterConfig) Validate() error {
 		return fmt.Errorf("datapoint filters are invalid: %v", err)
 	}
 
+	if wc.PropertiesUnixSocketPath != "" && wc.PropertiesLocalAddress != "" {
+		return errors.New("propertiesLocalAddress can't be set together with propertiesUnixSocketPath")
+	}
+
+	if wc.PropertiesUnixSocketPath != "" && wc.PropertiesDNSCacheTTLSeconds > 0 {
+		return errors.New("propertiesDNSCacheTTLSeconds can't be set together with propertiesUnixSocketPath")
+	}
+
 	return nil
 }
 
@@ -291,3 +397,31 @@ type SplunkConfig struct {
 	// HEC
 	MaxBatchSize int `yaml:"maxBatchSize"`
 }
+
+// CorrelationRetryConfig configures retries of each kind of trace host
+// correlation request.
+type CorrelationRetryConfig struct {
+	// How requests that add trace host correlations are retried
+	Correlate OperationRetryConfig `yaml:"correlate" default:"{}"`
+	// How requests that remove trace host correlations are retried
+	Delete OperationRetryConfig `yaml:"delete" default:"{}"`
+	// How requests that fetch the trace host correlations of a dimension are
+	// retried
+	Get OperationRetryConfig `yaml:"get" default:"{}"`
+}
+
+// OperationRetryConfig configures retries of one kind of trace host
+// correlation request.
+type OperationRetryConfig struct {
+	// If set to `false`, a request of this kind fails on its first failed
+	// attempt.  `maxRetries` must be 0 then.
+	Enabled *bool `yaml:"enabled" default:"true"`
+	// How many times a request of this kind is retried.  If 0,
+	// `traceHostCorrelationMaxRequestRetries` is used, or
+	// `propertiesGetMaxRetries` for fetches.
+	MaxRetries uint `yaml:"maxRetries" default:"0"`
+	// The number of seconds to wait between retries of a request of this
+	// kind.  If 0, `propertiesSendDelaySeconds` is used, or
+	// `propertiesGetRetryDelaySeconds` for fetches.
+	RetryDelaySeconds uint `yaml:"retryDelaySeconds" default:"0"`
+}
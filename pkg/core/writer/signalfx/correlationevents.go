@@ -0,0 +1,47 @@
+package signalfx
+
+import (
+	"context"
+	"time"
+
+	"github.com/signalfx/golib/v3/event"
+
+	"github.com/signalfx/signalfx-agent/pkg/apm/correlations"
+)
+
+// correlationFailureEventType is the event type of trace host correlations that failed
+const correlationFailureEventType = "TraceHostCorrelationFailure"
+
+// correlationEventEmitter sends an agent event for each trace host correlation request that
+// fails, so that failures are visible alongside other events rather than only in the logs
+type correlationEventEmitter struct {
+	ctx    context.Context
+	events chan<- *event.Event
+}
+
+var _ correlations.EventEmitter = (*correlationEventEmitter)(nil)
+
+func (e *correlationEventEmitter) EmitFailure(failure correlations.FailureEvent) {
+	properties := map[string]interface{}{
+		"operation":  failure.Operation.String(),
+		"category":   string(failure.Category),
+		"statusCode": int64(failure.StatusCode),
+		"attempts":   int64(failure.Attempts),
+	}
+	if failure.Operation != correlations.OperationGet {
+		properties["correlationType"] = string(failure.Correlation.Type)
+		properties["correlationValue"] = failure.Correlation.Value
+	}
+	if failure.Err != nil {
+		properties["error"] = failure.Err.Error()
+	}
+
+	ev := event.NewWithProperties(correlationFailureEventType, event.AGENT,
+		map[string]string{failure.Correlation.DimName: failure.Correlation.DimValue}, properties, time.Now())
+
+	// the event is buffered by the writer, so this only waits for it to be taken
+	select {
+	case e.events <- ev:
+	case <-e.ctx.Done():
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"os"
 	"strings"
 	"syscall"
 	"time"
@@ -29,6 +30,7 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/signalfx/signalfx-agent/pkg/apm/correlations"
+	"github.com/signalfx/signalfx-agent/pkg/apm/requests/dnscache"
 
 	libtracker "github.com/signalfx/signalfx-agent/pkg/apm/tracetracker"
 	"github.com/signalfx/signalfx-agent/pkg/core/config"
@@ -58,6 +60,9 @@ type Writer struct {
 	datapointWriter   *sfxwriter.DatapointWriter
 	spanWriter        *sfxwriter.SpanWriter
 
+	// dnsCache caches the addresses correlation requests connect to, nil if disabled
+	dnsCache *dnscache.Resolver
+
 	// Monitors should send events to this
 	eventChan     chan *event.Event
 	dimensionChan chan *types.Dimension
@@ -110,14 +115,38 @@ func New(conf *config.WriterConfig, dpChan chan []*datapoint.Datapoint, eventCha
 		return nil, err
 	}
 
+	localAddr, err := parseLocalAddress(conf.PropertiesLocalAddress)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	dialContext := (&net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 30 * time.Second,
+		LocalAddr: localAddr,
+	}).DialContext
+	proxy := http.ProxyFromEnvironment
+	var dnsCache *dnscache.Resolver
+	switch {
+	case conf.PropertiesUnixSocketPath != "":
+		dialContext, err = unixSocketDialer(conf.PropertiesUnixSocketPath)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		// a proxy would be dialed through the socket as well, so requests go straight to it
+		proxy = nil
+	case conf.PropertiesDNSCacheTTLSeconds > 0:
+		dnsCache = dnscache.NewResolver(time.Duration(conf.PropertiesDNSCacheTTLSeconds)*time.Second, nil)
+		dialContext = dnsCache.DialContext(dialContext)
+	}
+
 	client := &http.Client{
 		Timeout: 10 * time.Second,
 		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   5 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
+			Proxy:               proxy,
+			DialContext:         dialContext,
 			MaxIdleConns:        conf.MaxRequests,
 			MaxIdleConnsPerHost: conf.MaxRequests,
 			IdleConnTimeout:     30 * time.Second,
@@ -125,7 +154,11 @@ func New(conf *config.WriterConfig, dpChan chan []*datapoint.Datapoint, eventCha
 		},
 	}
 
-	correlationClient, err := correlations.NewCorrelationClient(utils.NewAPMShim(log.StandardLogger()), ctx, client, config.ClientConfigFromWriterConfig(conf))
+	correlationConf := config.ClientConfigFromWriterConfig(conf)
+	if conf.PropertiesFailureEvents && eventChan != nil {
+		correlationConf.EventEmitter = &correlationEventEmitter{ctx: ctx, events: eventChan}
+	}
+	correlationClient, err := correlations.NewCorrelationClient(utils.NewAPMShim(log.StandardLogger()), ctx, client, correlationConf)
 	if err != nil {
 		cancel()
 		return nil, err
@@ -139,6 +172,7 @@ func New(conf *config.WriterConfig, dpChan chan []*datapoint.Datapoint, eventCha
 		logger:            logger,
 		correlationClient: correlationClient,
 		dimensionClient:   dimensionClient,
+		dnsCache:          dnsCache,
 		hostIDDims:        conf.HostIDDims,
 		eventChan:         eventChan,
 		dimensionChan:     dimensionChan,
@@ -409,3 +443,35 @@ func (sw *Writer) Shutdown() {
 	}
 	log.Debug("Stopped datapoint writer")
 }
+
+// parseLocalAddress returns the address correlation connections are made from, or nil if the
+// operating system should choose it
+func parseLocalAddress(address string) (net.Addr, error) {
+	if address == "" {
+		return nil, nil
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil, fmt.Errorf("correlation local address %q is not an IP address", address)
+	}
+	return &net.TCPAddr{IP: ip}, nil
+}
+
+// unixSocketDialer returns a dial function that connects to the Unix domain socket at path
+// regardless of the address being dialed
+func unixSocketDialer(path string) (func(ctx context.Context, network, address string) (net.Conn, error), error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("correlation unix socket %s is not available: %v", path, err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return nil, fmt.Errorf("correlation unix socket path %s is not a socket", path)
+	}
+	dialer := &net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "unix", path)
+	}, nil
+}
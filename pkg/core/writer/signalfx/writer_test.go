@@ -1,12 +1,21 @@
 package signalfx
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
 	"syscall"
 	"testing"
 	"time"
 
+	"github.com/signalfx/golib/v3/event"
+
+	"github.com/signalfx/signalfx-agent/pkg/apm/correlations"
 	"github.com/signalfx/signalfx-agent/pkg/utils/timeutil"
 
 	"github.com/signalfx/signalfx-agent/pkg/core/config"
@@ -52,6 +61,92 @@ type tempError struct {
 func (t tempError) Error() string   { return fmt.Sprintf("%v", t.temporary()) }
 func (t tempError) Temporary() bool { return t.temporary() }
 
+func TestWriterUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "writer")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := essentialWriterConfig
+	conf.PropertiesUnixSocketPath = filepath.Join(dir, "ingest.sock")
+	_, err = New(&conf, nil, nil, nil, nil, nil)
+	require.Error(t, err, "socket must exist")
+
+	listener, err := net.Listen("unix", conf.PropertiesUnixSocketPath)
+	require.Nil(t, err)
+	defer listener.Close()
+
+	writer, err := New(&conf, nil, nil, nil, nil, nil)
+	require.Nil(t, err)
+	defer writer.Shutdown()
+
+	dial, err := unixSocketDialer(conf.PropertiesUnixSocketPath)
+	require.Nil(t, err)
+	conn, err := dial(context.Background(), "tcp", "placeholder:443")
+	require.Nil(t, err)
+	conn.Close()
+}
+
+func TestWriterLocalAddress(t *testing.T) {
+	conf := essentialWriterConfig
+	conf.PropertiesLocalAddress = "127.0.0.1:8080"
+	_, err := New(&conf, nil, nil, nil, nil, nil)
+	require.Error(t, err, "address must not have a port")
+
+	addr, err := parseLocalAddress("")
+	require.Nil(t, err)
+	require.Nil(t, addr)
+
+	addr, err = parseLocalAddress("127.0.0.1")
+	require.Nil(t, err)
+	require.Equal(t, &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}, addr)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+	conn, err := (&net.Dialer{LocalAddr: addr}).Dial("tcp", listener.Addr().String())
+	require.Nil(t, err)
+	defer conn.Close()
+	require.Equal(t, "127.0.0.1", conn.LocalAddr().(*net.TCPAddr).IP.String())
+}
+
+func TestCorrelationEventEmitter(t *testing.T) {
+	events := make(chan *event.Event, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	emitter := &correlationEventEmitter{ctx: ctx, events: events}
+
+	emitter.EmitFailure(correlations.FailureEvent{
+		Correlation: &correlations.Correlation{Type: correlations.Service, DimName: "host", DimValue: "test-box", Value: "svc"},
+		Operation:   correlations.OperationCorrelate,
+		Category:    correlations.FailureAuth,
+		StatusCode:  401,
+		Err:         errors.New("unauthorized"),
+		Attempts:    1,
+	})
+	ev := <-events
+	require.Equal(t, correlationFailureEventType, ev.EventType)
+	require.Equal(t, event.AGENT, ev.Category)
+	require.Equal(t, map[string]string{"host": "test-box"}, ev.Dimensions)
+	require.Equal(t, "auth", ev.Properties["category"])
+	require.Equal(t, int64(401), ev.Properties["statusCode"])
+	require.Equal(t, "svc", ev.Properties["correlationValue"])
+	require.Equal(t, "unauthorized", ev.Properties["error"])
+
+	// a get doesn't have a type or value
+	emitter.EmitFailure(correlations.FailureEvent{
+		Correlation: &correlations.Correlation{DimName: "host", DimValue: "test-box"},
+		Operation:   correlations.OperationGet,
+		Category:    correlations.FailureRetriesExhausted,
+	})
+	ev = <-events
+	require.NotContains(t, ev.Properties, "correlationType")
+	require.NotContains(t, ev.Properties, "error")
+
+	// it gives up once the writer is shut down
+	events <- ev
+	cancel()
+	emitter.EmitFailure(correlations.FailureEvent{Correlation: &correlations.Correlation{}, Operation: correlations.OperationGet})
+}
+
 func TestIsTransientError(t *testing.T) {
 	tests := []struct {
 		name     string